@@ -0,0 +1,276 @@
+// Package agent continuously reconciles a host with a jobs repository: it
+// fetches the repository on an interval, syncs when the commit changes and
+// repairs hand-edited cron files in between.
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/yegor-usoltsev/cronctl/internal/gitrepo"
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/syncer"
+)
+
+var errNoRepo = errors.New("agent: repo and ref are required")
+
+// Config configures Run.
+type Config struct {
+	// Repo and Ref are checked out into the managed clone RepoDir (see
+	// gitrepo.Checkout); JobsDir is relative to its root.
+	Repo    string
+	Ref     string
+	RepoDir string
+	JobsDir string
+	// AllowedSigners, if set, requires every deployed commit to be signed by
+	// one of its keys (see gitrepo.VerifyHead).
+	AllowedSigners string
+
+	// Interval is the time between fetches, DriftInterval the time between
+	// drift checks of the deployed cron files.
+	Interval      time.Duration
+	DriftInterval time.Duration
+	// RetryMin and RetryMax bound the jittered exponential backoff after a
+	// failed fetch or sync.
+	RetryMin time.Duration
+	RetryMax time.Duration
+
+	LockFile   string
+	StatusFile string
+
+	// Listen, if set, is the address of the push webhook (see webhook),
+	// authenticated with WebhookSecret.
+	Listen        string
+	WebhookSecret []byte
+
+	// Discover, if set, replaces job.Discover, e.g. to apply the repo config
+	// of each checkout.
+	Discover func(ctx context.Context, jobsDir string) ([]job.Job, error)
+	// Select, if set, picks the jobs to deploy from the discovered ones.
+	Select func([]job.Job) ([]job.Job, error)
+	// Sync are the options passed to syncer.Sync and syncer.Repair.
+	Sync syncer.Options
+}
+
+type agent struct {
+	cfg    Config
+	status Status
+	// jobs and commit are what was last deployed successfully, or empty
+	// after a failed sync that may have deployed part of a commit.
+	jobs   []job.Job
+	commit string
+}
+
+// Run reconciles the host until ctx is canceled. SIGHUP triggers an immediate
+// fetch and sync, even if the commit did not change; so does a push webhook
+// if Config.Listen is set, unless the commit is already deployed. Only one
+// agent can run per lock file, and it runs one sync at a time.
+func Run(ctx context.Context, cfg Config) error {
+	if cfg.Repo == "" || cfg.Ref == "" {
+		return errNoRepo
+	}
+	if cfg.Listen != "" && len(cfg.WebhookSecret) == 0 {
+		return errNoWebhookSecret
+	}
+	unlock, err := Lock(cfg.LockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	queue := newRunQueue()
+	if cfg.Listen != "" {
+		stop, err := serve(ctx, cfg.Listen, (&webhook{secret: cfg.WebhookSecret, ref: cfg.Ref, queue: queue}).routes())
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	a := &agent{cfg: cfg, status: Status{PID: os.Getpid(), StartedAt: time.Now().UTC(), Repo: cfg.Repo, Ref: cfg.Ref}}
+	log.Printf("agent: watching %s %s every %s", cfg.Repo, cfg.Ref, cfg.Interval)
+
+	syncTimer := time.NewTimer(0)
+	defer syncTimer.Stop()
+	driftTimer := time.NewTimer(cfg.DriftInterval)
+	defer driftTimer.Stop()
+	force := false
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("agent: stopping")
+			a.status.NextSync = time.Time{}
+			a.writeStatus()
+			return nil
+		case <-hup:
+			log.Printf("agent: SIGHUP, syncing now")
+			force = true
+			syncTimer.Reset(0)
+		case <-queue.wake:
+			syncTimer.Reset(0)
+		case <-syncTimer.C:
+			// A queued webhook run is served by whichever sync starts next.
+			runID := queue.start()
+			res, err := a.reconcile(ctx, force)
+			queue.finish(runID, res)
+			force = false
+			if ctx.Err() != nil {
+				continue
+			}
+			delay := cfg.Interval
+			if err != nil {
+				a.status.Failures++
+				delay = Backoff(a.status.Failures, cfg.RetryMin, cfg.RetryMax)
+				log.Printf("agent: %v (retrying in %s)", err, delay.Round(time.Second))
+			} else {
+				a.status.Failures = 0
+			}
+			a.status.NextSync = time.Now().Add(delay).UTC()
+			a.writeStatus()
+			syncTimer.Reset(delay)
+		case <-driftTimer.C:
+			a.repair(ctx)
+			a.writeStatus()
+			driftTimer.Reset(cfg.DriftInterval)
+		}
+	}
+}
+
+// reconcile fetches the configured ref and syncs if it points to a commit
+// other than the deployed one.
+func (a *agent) reconcile(ctx context.Context, force bool) (*Result, error) {
+	started := time.Now()
+	res := &Result{Time: started.UTC()}
+	a.status.LastSync = res
+
+	err := a.sync(ctx, force, res)
+	res.Duration = time.Since(started).Round(time.Millisecond).String()
+	if err != nil {
+		res.Error = err.Error()
+		return res, err
+	}
+	res.OK = true
+	return res, nil
+}
+
+func (a *agent) sync(ctx context.Context, force bool, res *Result) error {
+	commit, err := gitrepo.Checkout(ctx, a.cfg.Repo, a.cfg.Ref, a.cfg.RepoDir)
+	if err != nil {
+		return fmt.Errorf("checkout: %w", err)
+	}
+	res.Commit = commit
+	if commit == a.commit && !force {
+		res.Skipped = true
+		return nil
+	}
+	jobsDir := filepath.Join(a.cfg.RepoDir, a.cfg.JobsDir)
+	if a.cfg.AllowedSigners != "" {
+		if err := gitrepo.VerifyHead(ctx, jobsDir, a.cfg.AllowedSigners); err != nil {
+			return err
+		}
+	}
+	discover := job.Discover
+	if a.cfg.Discover != nil {
+		discover = a.cfg.Discover
+	}
+	jobs, err := discover(ctx, jobsDir)
+	if err != nil {
+		return fmt.Errorf("discover jobs: %w", err)
+	}
+	if a.cfg.Select != nil {
+		if jobs, err = a.cfg.Select(jobs); err != nil {
+			return err
+		}
+	}
+	opts := a.cfg.Sync
+	opts.SourceCommit = commit
+	log.Printf("agent: syncing %d jobs at commit %s", len(jobs), commit)
+	if err := syncer.Sync(ctx, jobs, opts); err != nil {
+		// Some jobs may already be deployed from commit: repairing against
+		// the previous sync would roll them back, so drift repair waits for
+		// the next successful sync.
+		a.jobs, a.commit = nil, ""
+		return fmt.Errorf("sync: %w", err)
+	}
+	a.jobs, a.commit = jobs, commit
+	a.status.Commit = commit
+	return nil
+}
+
+// repair restores drifted cron files of the last successful sync, unless a
+// sync failed since.
+func (a *agent) repair(ctx context.Context) {
+	if a.commit == "" {
+		return
+	}
+	opts := a.cfg.Sync
+	opts.SourceCommit = a.commit
+	repaired, err := syncer.Repair(ctx, a.jobs, opts)
+	res := &DriftResult{Time: time.Now().UTC(), Repaired: repaired}
+	if err != nil {
+		res.Error = err.Error()
+		log.Printf("agent: drift check: %v", err)
+	}
+	a.status.LastDrift = res
+}
+
+func (a *agent) writeStatus() {
+	if a.cfg.StatusFile == "" {
+		return
+	}
+	if err := WriteStatus(a.cfg.StatusFile, a.status); err != nil {
+		log.Printf("agent: %v", err)
+	}
+}
+
+// serve serves h on addr until ctx is canceled or the returned function is
+// called.
+func serve(ctx context.Context, addr string, h http.Handler) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("agent: webhook: %v", err)
+		}
+	}()
+	log.Printf("agent: webhook listening on %s", ln.Addr())
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}, nil
+}
+
+// Backoff returns the delay before retry number failures (starting at 1):
+// min doubled per failure, capped at max, with up to half of it randomized
+// so that a fleet of hosts does not retry in lockstep.
+func Backoff(failures int, minDelay, maxDelay time.Duration) time.Duration {
+	d := minDelay
+	for i := 1; i < failures && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	// #nosec G404 -- jitter does not need a secure source.
+	return half + rand.N(half+1)
+}
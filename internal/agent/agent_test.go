@@ -0,0 +1,219 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/syncer"
+)
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+	minDelay, maxDelay := 10*time.Second, 80*time.Second
+	for failures, ceiling := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 4: 80 * time.Second, 10: 80 * time.Second} {
+		for range 50 {
+			d := Backoff(failures, minDelay, maxDelay)
+			if d < ceiling/2 || d > ceiling {
+				t.Fatalf("Backoff(%d) = %s, want within [%s, %s]", failures, d, ceiling/2, ceiling)
+			}
+		}
+	}
+}
+
+func TestLock(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "run", "agent.lock")
+	unlock, err := Lock(path)
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if _, err := Lock(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Lock: want ErrLocked, got %v", err)
+	}
+	unlock()
+	unlock2, err := Lock(path)
+	if err != nil {
+		t.Fatalf("Lock after unlock: %v", err)
+	}
+	unlock2()
+}
+
+func TestUnit(t *testing.T) {
+	t.Parallel()
+	unit, err := Unit("/usr/local/bin/cronctl", []string{"agent", "--repo", "/srv/my jobs.git", "--ref", "100%"})
+	if err != nil {
+		t.Fatalf("Unit: %v", err)
+	}
+	want := `ExecStart=/usr/local/bin/cronctl agent --repo "/srv/my jobs.git" --ref "100%%"` + "\n"
+	if !strings.Contains(string(unit), want) {
+		t.Fatalf("Unit() =\n%s\nwant line %q", unit, want)
+	}
+}
+
+func gitCmd(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	argv := append([]string{"-C", dir, "-c", "user.name=CI", "-c", "user.email=ci@example.com"}, args...)
+	cmd := exec.Command("git", argv...)
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSyncFailureStopsRepair(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "src")
+	jobDir := filepath.Join(src, "jobs", "hello")
+	if err := os.MkdirAll(jobDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	spec := "name: hello\nenabled: true\nuser: root\nrun:\n  entrypoint: run.sh\nschedule:\n  - cron: \"0 * * * *\"\n"
+	if err := os.WriteFile(filepath.Join(jobDir, "job.yaml"), []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(jobDir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	gitCmd(t, src, "init", "-q", "-b", "main")
+	gitCmd(t, src, "add", "-A")
+	gitCmd(t, src, "commit", "-q", "-m", "v1")
+
+	// A cron dir that is a file makes every sync fail.
+	cronDir := filepath.Join(tmp, "cron.d")
+	if err := os.WriteFile(cronDir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	a := &agent{
+		cfg: Config{
+			Repo:    src,
+			Ref:     "main",
+			RepoDir: filepath.Join(tmp, "clone"),
+			JobsDir: "jobs",
+			Sync:    syncer.Options{CronDir: cronDir, TargetDir: filepath.Join(tmp, "opt")},
+		},
+		jobs:   []job.Job{{ID: "hello"}},
+		commit: "0123456789abcdef",
+	}
+	if err := a.sync(context.Background(), false, &Result{}); err == nil {
+		t.Fatal("sync: want error")
+	}
+	if a.commit != "" || a.jobs != nil {
+		t.Fatalf("after failed sync: commit %q, jobs %v; want both empty", a.commit, a.jobs)
+	}
+	a.repair(context.Background())
+	if a.status.LastDrift != nil {
+		t.Fatalf("repair ran after failed sync: %+v", a.status.LastDrift)
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	if os.Geteuid() != 0 {
+		t.Skip("skipping test that requires root")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "src")
+	jobDir := filepath.Join(src, "jobs", "hello")
+	if err := os.MkdirAll(jobDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeJob := func(cron string) {
+		t.Helper()
+		spec := "name: hello\nenabled: true\nuser: root\nrun:\n  entrypoint: run.sh\nschedule:\n  - cron: \"" + cron + "\"\n"
+		if err := os.WriteFile(filepath.Join(jobDir, "job.yaml"), []byte(spec), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeJob("0 * * * *")
+	if err := os.WriteFile(filepath.Join(jobDir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	gitCmd(t, src, "init", "-q", "-b", "main")
+	gitCmd(t, src, "add", "-A")
+	gitCmd(t, src, "commit", "-q", "-m", "v1")
+
+	cronPath := filepath.Join(tmp, "cron.d", "cronctl-hello")
+	statusFile := filepath.Join(tmp, "status.json")
+	cfg := Config{
+		Repo:          src,
+		Ref:           "main",
+		RepoDir:       filepath.Join(tmp, "clone"),
+		JobsDir:       "jobs",
+		Interval:      50 * time.Millisecond,
+		DriftInterval: 50 * time.Millisecond,
+		RetryMin:      50 * time.Millisecond,
+		RetryMax:      100 * time.Millisecond,
+		LockFile:      filepath.Join(tmp, "agent.lock"),
+		StatusFile:    statusFile,
+		Sync:          syncer.Options{CronDir: filepath.Join(tmp, "cron.d"), TargetDir: filepath.Join(tmp, "opt")},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	}()
+
+	cronHas := func(s string) func() bool {
+		return func() bool {
+			b, err := os.ReadFile(cronPath)
+			return err == nil && strings.Contains(string(b), s)
+		}
+	}
+	first := gitCmd(t, src, "rev-parse", "HEAD")
+	waitFor(t, "first sync", cronHas("# Source commit: "+first))
+
+	if _, err := Lock(cfg.LockFile); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock while running: want ErrLocked, got %v", err)
+	}
+
+	// Hand edits are repaired.
+	if err := os.WriteFile(cronPath, []byte("* * * * * root true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "drift repair", cronHas("0 * * * * root"))
+
+	// New commits are deployed.
+	writeJob("30 * * * *")
+	gitCmd(t, src, "commit", "-q", "-am", "v2")
+	second := gitCmd(t, src, "rev-parse", "HEAD")
+	waitFor(t, "second sync", cronHas("30 * * * * root"))
+
+	waitFor(t, "status", func() bool {
+		s, err := ReadStatus(statusFile)
+		return err == nil && s.Commit == second && s.LastSync != nil && s.LastSync.OK
+	})
+}
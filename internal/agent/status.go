@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Status is the last known state of a running agent, written to
+// Config.StatusFile after every sync and drift check.
+type Status struct {
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+	Repo      string    `json:"repo"`
+	Ref       string    `json:"ref"`
+	// Commit is the last successfully deployed commit.
+	Commit    string       `json:"commit,omitempty"`
+	LastSync  *Result      `json:"last_sync,omitempty"`
+	LastDrift *DriftResult `json:"last_drift,omitempty"`
+	// Failures counts consecutive failed syncs.
+	Failures int       `json:"failures"`
+	NextSync time.Time `json:"next_sync,omitzero"`
+}
+
+// Result is the outcome of a fetch and sync.
+type Result struct {
+	Time   time.Time `json:"time"`
+	Commit string    `json:"commit,omitempty"`
+	OK     bool      `json:"ok"`
+	// Skipped is set when the commit was already deployed.
+	Skipped  bool   `json:"skipped,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// DriftResult is the outcome of a drift check.
+type DriftResult struct {
+	Time     time.Time `json:"time"`
+	Repaired []string  `json:"repaired,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// ReadStatus reads a status file written by the agent.
+func ReadStatus(path string) (Status, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Status{}, fmt.Errorf("read status: %w", err)
+	}
+	var s Status
+	if err := json.Unmarshal(b, &s); err != nil {
+		return Status{}, fmt.Errorf("parse status %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// WriteStatus atomically replaces the status file at path.
+func WriteStatus(path string, s Status) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode status: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(path), err)
+	}
+	tmp := path + ".tmp"
+	// #nosec G306 -- the status holds no secrets and is read by monitoring.
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write status: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write status: %w", err)
+	}
+	return nil
+}
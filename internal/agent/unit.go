@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templatesFS embed.FS
+
+// Unit renders a systemd service unit that runs exec with args.
+func Unit(exec string, args []string) ([]byte, error) {
+	tmpl, err := template.ParseFS(templatesFS, "templates/cronctl-agent.service.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("parse unit template: %w", err)
+	}
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		quoted = append(quoted, systemdQuote(a))
+	}
+	var buf bytes.Buffer
+	data := struct {
+		Exec string
+		Args []string
+	}{Exec: exec, Args: quoted}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("render unit: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// systemdQuote quotes s for an ExecStart= line if needed.
+func systemdQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\$%;") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$", "%", "%%")
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxWebhookBody = 5 << 20
+	maxRuns        = 100
+)
+
+var errNoWebhookSecret = errors.New("agent: a webhook secret is required to listen")
+
+// RunState is the state of a queued reconcile run.
+type RunState string
+
+const (
+	RunQueued    RunState = "queued"
+	RunRunning   RunState = "running"
+	RunSucceeded RunState = "succeeded"
+	RunFailed    RunState = "failed"
+)
+
+// QueuedRun is a reconcile run requested by a webhook.
+type QueuedRun struct {
+	ID         string    `json:"id"`
+	State      RunState  `json:"state"`
+	QueuedAt   time.Time `json:"queued_at"`
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	// Deliveries counts the webhook deliveries coalesced into this run.
+	Deliveries int     `json:"deliveries"`
+	Result     *Result `json:"result,omitempty"`
+}
+
+// runQueue coalesces webhook deliveries: all deliveries that arrive before a
+// run starts share that run.
+type runQueue struct {
+	mu      sync.Mutex
+	runs    map[string]*QueuedRun
+	order   []string
+	pending *QueuedRun
+	// wake is signaled when a run is queued.
+	wake chan struct{}
+}
+
+func newRunQueue() *runQueue {
+	return &runQueue{runs: make(map[string]*QueuedRun), wake: make(chan struct{}, 1)}
+}
+
+// enqueue returns the pending run, queuing a new one if there is none.
+func (q *runQueue) enqueue() QueuedRun {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending == nil {
+		r := &QueuedRun{ID: newRunID(), State: RunQueued, QueuedAt: time.Now().UTC()}
+		q.runs[r.ID] = r
+		q.order = append(q.order, r.ID)
+		if len(q.order) > maxRuns {
+			delete(q.runs, q.order[0])
+			q.order = q.order[1:]
+		}
+		q.pending = r
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	q.pending.Deliveries++
+	return *q.pending
+}
+
+// start marks the pending run, if any, as running and returns its ID.
+func (q *runQueue) start() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r := q.pending
+	if r == nil {
+		return ""
+	}
+	q.pending = nil
+	r.State, r.StartedAt = RunRunning, time.Now().UTC()
+	return r.ID
+}
+
+func (q *runQueue) finish(id string, res *Result) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r, ok := q.runs[id]
+	if !ok {
+		return
+	}
+	r.State, r.FinishedAt, r.Result = RunSucceeded, time.Now().UTC(), res
+	if !res.OK {
+		r.State = RunFailed
+	}
+}
+
+func (q *runQueue) get(id string) (QueuedRun, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r, ok := q.runs[id]
+	if !ok {
+		return QueuedRun{}, false
+	}
+	return *r, true
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// webhook serves POST /webhook and GET /runs/<id>.
+type webhook struct {
+	secret []byte
+	ref    string
+	queue  *runQueue
+}
+
+func (h *webhook) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webhook", h.handlePush)
+	mux.HandleFunc("GET /runs/{id}", h.handleRun)
+	return mux
+}
+
+func (h *webhook) handlePush(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "body too large"})
+		return
+	}
+	if !h.authorized(r.Header, body) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid signature"})
+		return
+	}
+	if r.Header.Get("X-GitHub-Event") == "ping" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "pong"})
+		return
+	}
+	var push struct {
+		Ref string `json:"ref"`
+	}
+	if err := json.Unmarshal(body, &push); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid payload: " + err.Error()})
+		return
+	}
+	if push.Ref != "refs/heads/"+h.ref && push.Ref != "refs/tags/"+h.ref {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "ref " + push.Ref + " is not " + h.ref})
+		return
+	}
+	run := h.queue.enqueue()
+	log.Printf("agent: webhook: push to %s, run %s queued", push.Ref, run.ID)
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": string(run.State), "run_id": run.ID})
+}
+
+// authorized checks a GitHub HMAC signature or a GitLab secret token.
+func (h *webhook) authorized(hdr http.Header, body []byte) bool {
+	if sig, ok := strings.CutPrefix(hdr.Get("X-Hub-Signature-256"), "sha256="); ok {
+		got, err := hex.DecodeString(sig)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, h.secret)
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+	if token := hdr.Get("X-Gitlab-Token"); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), h.secret) == 1
+	}
+	return false
+}
+
+func (h *webhook) handleRun(w http.ResponseWriter, r *http.Request) {
+	run, ok := h.queue.get(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "run not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
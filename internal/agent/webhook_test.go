@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func signBody(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func push(t *testing.T, h http.Handler, body string, headers map[string]string) (int, map[string]string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var resp map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func getRun(t *testing.T, h http.Handler, id string) (int, QueuedRun) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs/"+id, nil))
+	var run QueuedRun
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &run); err != nil {
+			t.Fatalf("decode run: %v", err)
+		}
+	}
+	return rec.Code, run
+}
+
+func TestWebhook(t *testing.T) {
+	t.Parallel()
+	const secret = "s3cret"
+	q := newRunQueue()
+	h := (&webhook{secret: []byte(secret), ref: "main", queue: q}).routes()
+	mainPush := `{"ref":"refs/heads/main"}`
+
+	tests := []struct {
+		name    string
+		body    string
+		headers map[string]string
+		code    int
+	}{
+		{"no signature", mainPush, nil, http.StatusUnauthorized},
+		{"bad signature", mainPush, map[string]string{"X-Hub-Signature-256": signBody("wrong", mainPush)}, http.StatusUnauthorized},
+		{"bad gitlab token", mainPush, map[string]string{"X-Gitlab-Token": "wrong"}, http.StatusUnauthorized},
+		{"other branch", `{"ref":"refs/heads/dev"}`, map[string]string{"X-Hub-Signature-256": signBody(secret, `{"ref":"refs/heads/dev"}`)}, http.StatusOK},
+		{"ping", `{}`, map[string]string{"X-Hub-Signature-256": signBody(secret, `{}`), "X-GitHub-Event": "ping"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code, resp := push(t, h, tt.body, tt.headers); code != tt.code {
+			t.Fatalf("%s: code = %d (%v), want %d", tt.name, code, resp, tt.code)
+		}
+	}
+	if id := q.start(); id != "" {
+		t.Fatalf("rejected deliveries queued run %s", id)
+	}
+
+	// A burst of deliveries is coalesced into one run.
+	code, first := push(t, h, mainPush, map[string]string{"X-Hub-Signature-256": signBody(secret, mainPush)})
+	if code != http.StatusAccepted || first["run_id"] == "" {
+		t.Fatalf("push: code = %d (%v), want 202 with run_id", code, first)
+	}
+	_, second := push(t, h, mainPush, map[string]string{"X-Gitlab-Token": secret})
+	if second["run_id"] != first["run_id"] {
+		t.Fatalf("second delivery got run %s, want coalesced into %s", second["run_id"], first["run_id"])
+	}
+	id := first["run_id"]
+	if _, run := getRun(t, h, id); run.State != RunQueued || run.Deliveries != 2 {
+		t.Fatalf("run = %+v, want queued with 2 deliveries", run)
+	}
+
+	if got := q.start(); got != id {
+		t.Fatalf("start() = %s, want %s", got, id)
+	}
+	// Deliveries while a run is in progress queue the next run.
+	_, third := push(t, h, mainPush, map[string]string{"X-Gitlab-Token": secret})
+	if third["run_id"] == id {
+		t.Fatalf("delivery during run %s was coalesced into it", id)
+	}
+	q.finish(id, &Result{OK: true, Commit: "abc"})
+	if code, run := getRun(t, h, id); code != http.StatusOK || run.State != RunSucceeded || run.Result == nil || run.Result.Commit != "abc" {
+		t.Fatalf("run = %d %+v, want succeeded at abc", code, run)
+	}
+	if code, _ := getRun(t, h, "nope"); code != http.StatusNotFound {
+		t.Fatalf("unknown run: code = %d, want 404", code)
+	}
+}
@@ -0,0 +1,289 @@
+// Package archive packs and unpacks directory trees as tar streams.
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	errUnsafePath    = errors.New("unsafe path in archive")
+	errUnsafeSymlink = errors.New("symlink escapes archive root")
+)
+
+// SkipFunc reports whether a slash-separated path relative to the packed dir
+// should be left out of the archive. Skipping a directory skips its contents.
+type SkipFunc func(rel string, isDir bool) bool
+
+// Write packs the contents of dir into a tar stream. Entries are written in
+// lexical order with zeroed ownership and timestamps preserved.
+func Write(w io.Writer, dir string, skip SkipFunc) error {
+	tw := tar.NewWriter(w)
+	if err := AddDir(tw, dir, "", skip); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("close tar: %w", err)
+	}
+	return nil
+}
+
+// AddDir adds the contents of dir to tw under the given slash-separated
+// prefix (which may be empty).
+func AddDir(tw *tar.Writer, dir, prefix string, skip SkipFunc) error {
+	var rels []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("walk %s: %w", p, err)
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return fmt.Errorf("rel %s: %w", p, err)
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if skip != nil && skip(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rels = append(rels, rel)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walk %s: %w", dir, err)
+	}
+	sort.Strings(rels)
+	for _, rel := range rels {
+		if err := addEntry(tw, filepath.Join(dir, filepath.FromSlash(rel)), path.Join(prefix, rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addEntry(tw *tar.Writer, src, name string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", src, err)
+	}
+	var link string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(src); err != nil {
+			return fmt.Errorf("readlink %s: %w", src, err)
+		}
+	}
+	switch {
+	case info.IsDir(), info.Mode().IsRegular(), link != "":
+	default:
+		// Skip devices, sockets, etc.
+		return nil
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("tar header %s: %w", src, err)
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write tar header %s: %w", name, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", src, err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("write tar %s: %w", name, err)
+	}
+	return nil
+}
+
+// AddFile adds a single regular file with the given contents to tw.
+func AddFile(tw *tar.Writer, name string, perm fs.FileMode, data []byte) error {
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: int64(perm), Size: int64(len(data))} //nolint:exhaustruct
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write tar header %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("write tar %s: %w", name, err)
+	}
+	return nil
+}
+
+// Extract unpacks a tar stream into dst, overwriting existing files. Entries
+// that would land outside dst (absolute paths, "..", escaping symlinks) are
+// rejected, as are entries below a symlink: every write goes through an
+// os.Root of dst, so no symlink, even one from the same archive, can lead
+// it outside.
+func Extract(r io.Reader, dst string) error {
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", dst, err)
+	}
+	root, err := os.OpenRoot(dst)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", dst, err)
+	}
+	defer func() { _ = root.Close() }()
+	return ExtractRoot(r, root)
+}
+
+// ExtractRoot is Extract into an open root.
+func ExtractRoot(r io.Reader, root *os.Root) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+		if err := ExtractEntry(root, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// ExtractEntry extracts the tar entry hdr, with contents r, into root, with
+// the checks of Extract.
+func ExtractEntry(root *os.Root, hdr *tar.Header, r io.Reader) error {
+	rel, err := cleanName(hdr.Name)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	if err := checkParents(root, hdr.Name, path.Dir(rel)); err != nil {
+		return err
+	}
+	name := filepath.FromSlash(rel)
+	mode := fs.FileMode(hdr.Mode).Perm() //nolint:gosec
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := root.MkdirAll(name, mode|0o700); err != nil {
+			return fmt.Errorf("mkdir %s: %w", rel, err)
+		}
+	case tar.TypeReg:
+		if err := root.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", path.Dir(rel), err)
+		}
+		if err := writeFile(root, name, mode, r); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := checkLink(root, rel, hdr.Linkname); err != nil {
+			return err
+		}
+		if err := root.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", path.Dir(rel), err)
+		}
+		_ = root.Remove(name)
+		if err := root.Symlink(hdr.Linkname, name); err != nil {
+			return fmt.Errorf("symlink %s: %w", rel, err)
+		}
+	default:
+		// Skip other entry types.
+	}
+	return nil
+}
+
+// checkParents rejects entry name if a dir on its way, dir, is a symlink.
+func checkParents(root *os.Root, name, dir string) error {
+	p := "."
+	for _, elem := range strings.Split(dir, "/") {
+		if elem == "." {
+			continue
+		}
+		p = path.Join(p, elem)
+		fi, err := root.Lstat(filepath.FromSlash(p))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", p, err)
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is below symlink %s", errUnsafePath, name, p)
+		}
+	}
+	return nil
+}
+
+// checkLink rejects a symlink at rel to target unless target stays inside
+// the root, without passing through ".." of a symlink that is already there.
+func checkLink(root *os.Root, rel, target string) error {
+	if path.IsAbs(target) {
+		return fmt.Errorf("%w: %s -> %s", errUnsafeSymlink, rel, target)
+	}
+	var stack []string
+	elems := append(strings.Split(path.Dir(rel), "/"), strings.Split(target, "/")...)
+	for i, elem := range elems {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(stack) == 0 {
+				return fmt.Errorf("%w: %s -> %s", errUnsafeSymlink, rel, target)
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		stack = append(stack, elem)
+		if i == len(elems)-1 {
+			continue
+		}
+		fi, err := root.Lstat(filepath.Join(stack...))
+		if err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s -> %s passes through symlink %s", errUnsafeSymlink, rel, target, path.Join(stack...))
+		}
+	}
+	return nil
+}
+
+func cleanName(name string) (string, error) {
+	rel := path.Clean(strings.TrimSuffix(name, "/"))
+	if path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%w: %s", errUnsafePath, name)
+	}
+	return rel, nil
+}
+
+func writeFile(root *os.Root, name string, mode fs.FileMode, r io.Reader) error {
+	// Replace rather than write through an existing symlink.
+	_ = root.Remove(name)
+	f, err := root.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_EXCL, mode)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", name, err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	// Apply the exact mode regardless of umask.
+	if err := f.Chmod(mode); err != nil {
+		return fmt.Errorf("chmod %s: %w", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteExtract_RoundTrip(t *testing.T) {
+	t.Parallel()
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "bin", "skip"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "bin", "tool"), []byte("tool"), 0o755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "bin", "skip", "x"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.Symlink("bin/tool", filepath.Join(src, "tool")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	var buf bytes.Buffer
+	skip := func(rel string, _ bool) bool { return rel == "bin/skip" }
+	if err := Write(&buf, src, skip); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	dst := t.TempDir()
+	if err := Extract(&buf, dst); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dst, "tool"))
+	if err != nil {
+		t.Fatalf("stat extracted symlink target: %v", err)
+	}
+	if info.Mode().Perm() != 0o755 {
+		t.Fatalf("mode = %v, want 0755", info.Mode().Perm())
+	}
+	if _, err := os.Stat(filepath.Join(dst, "bin", "skip")); !os.IsNotExist(err) {
+		t.Fatalf("expected skipped dir to be absent, stat: %v", err)
+	}
+}
+
+func TestExtract_RejectsUnsafeEntries(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		hdr  tar.Header
+	}{
+		{name: "parent path", hdr: tar.Header{Typeflag: tar.TypeReg, Name: "../evil", Mode: 0o644}},
+		{name: "absolute path", hdr: tar.Header{Typeflag: tar.TypeReg, Name: "/etc/evil", Mode: 0o644}},
+		{name: "escaping symlink", hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "a/link", Linkname: "../../etc"}},
+		{name: "absolute symlink", hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc/passwd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			hdr := tt.hdr
+			if err := tw.WriteHeader(&hdr); err != nil {
+				t.Fatalf("WriteHeader: %v", err)
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if err := Extract(&buf, t.TempDir()); err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
+
+func TestExtract_RejectsChainedSymlinks(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		hdrs []tar.Header
+	}{
+		{
+			name: "file below a symlink to the parent",
+			hdrs: []tar.Header{
+				{Typeflag: tar.TypeDir, Name: "x/", Mode: 0o755},
+				{Typeflag: tar.TypeSymlink, Name: "x/up", Linkname: ".."},
+				{Typeflag: tar.TypeSymlink, Name: "y", Linkname: "x/up/.."},
+				{Typeflag: tar.TypeReg, Name: "y/evil", Mode: 0o644},
+			},
+		},
+		{
+			name: "file below an in-root symlink",
+			hdrs: []tar.Header{
+				{Typeflag: tar.TypeDir, Name: "x/", Mode: 0o755},
+				{Typeflag: tar.TypeSymlink, Name: "y", Linkname: "x"},
+				{Typeflag: tar.TypeReg, Name: "y/evil", Mode: 0o644},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, hdr := range tt.hdrs {
+				if err := tw.WriteHeader(&hdr); err != nil {
+					t.Fatalf("WriteHeader: %v", err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			parent := t.TempDir()
+			dst := filepath.Join(parent, "a", "dst")
+			if err := Extract(&buf, dst); err == nil {
+				t.Fatalf("expected error")
+			}
+			for _, p := range []string{filepath.Join(parent, "a", "evil"), filepath.Join(parent, "evil"), filepath.Join(dst, "x", "evil")} {
+				if _, err := os.Lstat(p); !os.IsNotExist(err) {
+					t.Fatalf("%s was written: %v", p, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+// Package artifact implements a content-addressed cache of build outputs.
+//
+// Entries are gzip-compressed tarballs of a job dir after a successful build,
+// keyed by the job input hash and the host platform. The cache dir is a plain
+// local path and may live on shared storage; writes are atomic renames. The
+// digest next to each entry lives in the same dir, so it catches corruption,
+// not tampering: only share the cache with hosts that are trusted to build.
+package artifact
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yegor-usoltsev/cronctl/internal/archive"
+)
+
+const (
+	entrySuffix  = ".tar.gz"
+	digestSuffix = ".sha256"
+)
+
+var (
+	errIntegrity   = errors.New("artifact integrity check failed")
+	errInvalidSize = errors.New("invalid size")
+)
+
+// Store is an artifact cache rooted at Dir. A zero MaxBytes disables
+// size-based eviction.
+type Store struct {
+	Dir      string
+	MaxBytes int64
+}
+
+// Key derives the cache key for a job input hash on this platform.
+func Key(inputHash string) string {
+	h := sha256.Sum256([]byte(inputHash + "\x00" + runtime.GOOS + "/" + runtime.GOARCH))
+	return hex.EncodeToString(h[:])
+}
+
+func (s Store) entryPath(key string) string {
+	return filepath.Join(s.Dir, key[:2], key+entrySuffix)
+}
+
+// Get restores the entry for key into dstDir. It reports false on a cache
+// miss. Corrupted entries are removed and reported as a miss. The entry is
+// unpacked into a temp dir under the .cronctl dir of dstDir and checked
+// against its digest while it is read; only then are its files renamed into
+// place, so a failed restore leaves dstDir as it was. All of it goes through
+// an os.Root of dstDir, so symlinks in dstDir cannot lead it outside.
+func (s Store) Get(ctx context.Context, key, dstDir string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, fmt.Errorf("artifact get: %w", err)
+	}
+	p := s.entryPath(key)
+	want, err := os.ReadFile(p + digestSuffix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("read %s: %w", p+digestSuffix, err)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("open %s: %w", p, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	root, err := os.OpenRoot(dstDir)
+	if err != nil {
+		return false, fmt.Errorf("open %s: %w", dstDir, err)
+	}
+	defer func() { _ = root.Close() }()
+	_, err = root.Lstat(".cronctl")
+	hadMeta := err == nil
+	tmp := filepath.Join(".cronctl", ".artifact-"+key)
+	if err := root.RemoveAll(tmp); err != nil {
+		return false, fmt.Errorf("remove %s: %w", tmp, err)
+	}
+	if err := root.MkdirAll(tmp, 0o700); err != nil {
+		return false, fmt.Errorf("mkdir %s: %w", tmp, err)
+	}
+	defer func() {
+		_ = root.RemoveAll(tmp)
+		if !hadMeta {
+			_ = root.Remove(".cronctl")
+		}
+	}()
+
+	h := sha256.New()
+	r := io.TeeReader(f, h)
+	extractErr := extract(r, root, tmp)
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return false, fmt.Errorf("read %s: %w", p, err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != strings.TrimSpace(string(want)) {
+		log.Printf("artifact: %s: %v, removing entry", p, errIntegrity)
+		_ = os.Remove(p)
+		_ = os.Remove(p + digestSuffix)
+		return false, nil
+	}
+	if extractErr != nil {
+		return false, fmt.Errorf("extract %s: %w", p, extractErr)
+	}
+	if err := moveInto(root, tmp); err != nil {
+		return false, fmt.Errorf("restore %s: %w", p, err)
+	}
+	// Bump mtime so eviction is least-recently-used.
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+	return true, nil
+}
+
+func extract(r io.Reader, root *os.Root, dir string) error {
+	sub, err := root.OpenRoot(dir)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", dir, err)
+	}
+	defer func() { _ = sub.Close() }()
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("gunzip: %w", err)
+	}
+	if err := archive.ExtractRoot(zr, sub); err != nil {
+		return fmt.Errorf("unpack: %w", err)
+	}
+	return nil
+}
+
+// moveInto moves the tree at dir into the top of root, replacing what is
+// there file by file.
+func moveInto(root *os.Root, dir string) error {
+	type entry struct {
+		rel  string
+		mode fs.FileMode
+	}
+	var entries []entry
+	err := fs.WalkDir(root.FS(), filepath.ToSlash(dir), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("walk %s: %w", p, err)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("info %s: %w", p, err)
+		}
+		rel, err := filepath.Rel(dir, filepath.FromSlash(p))
+		if err != nil {
+			return fmt.Errorf("rel %s: %w", p, err)
+		}
+		if rel != "." {
+			entries = append(entries, entry{rel: rel, mode: info.Mode()})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walk %s: %w", dir, err)
+	}
+	for _, e := range entries {
+		cur, err := root.Lstat(e.rel)
+		exists := err == nil
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("stat %s: %w", e.rel, err)
+		}
+		if !e.mode.IsDir() {
+			if exists && cur.IsDir() {
+				if err := root.RemoveAll(e.rel); err != nil {
+					return fmt.Errorf("remove %s: %w", e.rel, err)
+				}
+			}
+			if err := root.Rename(filepath.Join(dir, e.rel), e.rel); err != nil {
+				return fmt.Errorf("rename %s: %w", e.rel, err)
+			}
+			continue
+		}
+		if exists && !cur.IsDir() {
+			if err := root.Remove(e.rel); err != nil {
+				return fmt.Errorf("remove %s: %w", e.rel, err)
+			}
+		}
+		if err := root.MkdirAll(e.rel, e.mode.Perm()); err != nil {
+			return fmt.Errorf("mkdir %s: %w", e.rel, err)
+		}
+		if err := root.Chmod(e.rel, e.mode.Perm()); err != nil {
+			return fmt.Errorf("chmod %s: %w", e.rel, err)
+		}
+	}
+	return nil
+}
+
+// Put stores the contents of srcDir under key, skipping cronctl metadata and
+// git dirs, then evicts old entries if the store exceeds MaxBytes.
+func (s Store) Put(ctx context.Context, key, srcDir string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("artifact put: %w", err)
+	}
+	p := s.entryPath(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(p), err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-"+key+"-")
+	if err != nil {
+		return fmt.Errorf("create temp in %s: %w", filepath.Dir(p), err)
+	}
+	tmpName := tmp.Name()
+	defer func() { _ = os.Remove(tmpName) }()
+
+	h := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(tmp, h))
+	if err := archive.Write(zw, srcDir, skipMeta); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("pack %s: %w", srcDir, err)
+	}
+	if err := zw.Close(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("gzip %s: %w", srcDir, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", tmpName, err)
+	}
+	if err := os.Chmod(tmpName, 0o644); err != nil {
+		return fmt.Errorf("chmod %s: %w", tmpName, err)
+	}
+	if err := os.Rename(tmpName, p); err != nil {
+		return fmt.Errorf("rename %s -> %s: %w", tmpName, p, err)
+	}
+	// The digest goes last: readers treat an entry without one as a miss.
+	if err := writeAtomic(p+digestSuffix, []byte(hex.EncodeToString(h.Sum(nil))+"\n")); err != nil {
+		return err
+	}
+	return s.Evict()
+}
+
+// Evict removes least-recently-used entries until the store fits MaxBytes.
+func (s Store) Evict() error {
+	if s.MaxBytes <= 0 {
+		return nil
+	}
+	type entry struct {
+		path  string
+		size  int64
+		mtime time.Time
+	}
+	var (
+		entries []entry
+		total   int64
+	)
+	err := filepath.WalkDir(s.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("walk %s: %w", p, err)
+		}
+		if d.IsDir() || !strings.HasSuffix(p, entrySuffix) || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("info %s: %w", p, err)
+		}
+		entries = append(entries, entry{path: p, size: info.Size(), mtime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("scan artifact cache: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].mtime.Before(entries[j].mtime) })
+	for _, e := range entries {
+		if total <= s.MaxBytes {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("evict %s: %w", e.path, err)
+		}
+		_ = os.Remove(e.path + digestSuffix)
+		total -= e.size
+		log.Printf("artifact: evicted %s", filepath.Base(e.path))
+	}
+	return nil
+}
+
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	// #nosec G306 -- build outputs are shared between hosts by design.
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("rename %s -> %s: %w", tmp, path, err)
+	}
+	return nil
+}
+
+func skipMeta(rel string, _ bool) bool {
+	return rel == ".cronctl" || rel == ".git" || strings.HasPrefix(rel, ".cronctl/") || strings.HasPrefix(rel, ".git/")
+}
+
+// ParseSize parses sizes such as "500M", "10G" or a plain byte count.
+// Binary multiples are used; an empty string means no limit.
+func ParseSize(s string) (int64, error) {
+	orig := s
+	s = strings.TrimSpace(strings.ToUpper(s))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%w: %q", errInvalidSize, orig)
+	}
+	return v * mult, nil
+}
@@ -0,0 +1,176 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yegor-usoltsev/cronctl/internal/archive"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o755); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestStore_PutGet(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := Store{Dir: t.TempDir()}
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "bin", "app"), "binary")
+	writeFile(t, filepath.Join(src, ".cronctl", "state.json"), "{}")
+
+	key := Key("inputs")
+	dst := t.TempDir()
+	if ok, err := store.Get(ctx, key, dst); err != nil || ok {
+		t.Fatalf("Get on empty store = %v, %v", ok, err)
+	}
+	if err := store.Put(ctx, key, src); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	ok, err := store.Get(ctx, key, dst)
+	if err != nil || !ok {
+		t.Fatalf("Get = %v, %v", ok, err)
+	}
+	b, err := os.ReadFile(filepath.Join(dst, "bin", "app"))
+	if err != nil || string(b) != "binary" {
+		t.Fatalf("restored file = %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, ".cronctl")); !os.IsNotExist(err) {
+		t.Fatalf("expected .cronctl to be excluded, stat: %v", err)
+	}
+}
+
+func TestStore_GetRejectsCorruptEntry(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := Store{Dir: t.TempDir()}
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "app"), "binary")
+
+	key := Key("inputs")
+	if err := store.Put(ctx, key, src); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	f, err := os.OpenFile(store.entryPath(key), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open entry: %v", err)
+	}
+	_, _ = f.WriteString("garbage")
+	_ = f.Close()
+
+	dst := t.TempDir()
+	if ok, err := store.Get(ctx, key, dst); err != nil || ok {
+		t.Fatalf("Get on corrupt entry = %v, %v; want miss", ok, err)
+	}
+	if _, err := os.Stat(store.entryPath(key)); !os.IsNotExist(err) {
+		t.Fatalf("expected corrupt entry to be removed, stat: %v", err)
+	}
+}
+
+func TestStore_GetLeavesDirOnFailure(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := Store{Dir: t.TempDir()}
+	key := Key("inputs")
+
+	// An intact entry that fails to unpack after app.
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	if err := archive.AddFile(tw, "app", 0o755, []byte("binary")); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.AddFile(tw, "../evil", 0o644, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	writeFile(t, store.entryPath(key), buf.String())
+	writeFile(t, store.entryPath(key)+digestSuffix, hex.EncodeToString(sum[:])+"\n")
+
+	dst := t.TempDir()
+	writeFile(t, filepath.Join(dst, "app"), "source")
+	if ok, err := store.Get(ctx, key, dst); err == nil || ok {
+		t.Fatalf("Get = %v, %v; want error", ok, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dst, "app")); err != nil || string(b) != "source" {
+		t.Fatalf("app after failed restore = %q, %v; want untouched", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, ".cronctl")); !os.IsNotExist(err) {
+		t.Fatalf("expected no leftovers in .cronctl, stat: %v", err)
+	}
+}
+
+func TestStore_EvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := Store{Dir: t.TempDir()}
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "app"), "binary")
+
+	oldKey, newKey := Key("old"), Key("new")
+	if err := store.Put(ctx, oldKey, src); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(store.entryPath(oldKey), past, past); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	info, err := os.Stat(store.entryPath(oldKey))
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	store.MaxBytes = info.Size() + info.Size()/2
+	if err := store.Put(ctx, newKey, src); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := os.Stat(store.entryPath(oldKey)); !os.IsNotExist(err) {
+		t.Fatalf("expected old entry to be evicted, stat: %v", err)
+	}
+	if _, err := os.Stat(store.entryPath(newKey)); err != nil {
+		t.Fatalf("expected new entry to remain: %v", err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1024", 1024, false},
+		{"512M", 512 << 20, false},
+		{"10GiB", 10 << 30, false},
+		{"2k", 2 << 10, false},
+		{"-1", 0, true},
+		{"lots", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d, err=%v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package build
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/yegor-usoltsev/cronctl/internal/artifact"
+)
+
+// RestoreArtifact restores build outputs for the inputs cur from the artifact
+// store into jobDir and records a build state for them, hashed with the
+// effective spec (see HashInputs). Store errors are
+// logged and reported as a miss so the caller falls back to building.
+func RestoreArtifact(ctx context.Context, store *artifact.Store, jobID, jobDir string, spec []byte, cur Inputs) (State, bool) {
+	if store == nil {
+		return State{}, false
+	}
+	started := time.Now()
+	ok, err := store.Get(ctx, artifact.Key(cur.Hash), jobDir)
+	if err != nil {
+		log.Printf("build: %s: artifact cache: %v", jobID, err)
+		return State{}, false
+	}
+	if !ok {
+		return State{}, false
+	}
+	after, err := HashInputs(ctx, jobDir, spec)
+	if err != nil {
+		log.Printf("build: %s: artifact cache: hash inputs after restore: %v", jobID, err)
+		return State{}, false
+	}
+	log.Printf("build: %s: restored (artifact cache)", jobID)
+	return NewState(cur.Hash, after, started, 0), true
+}
+
+// StoreArtifact saves the build outputs in jobDir, built from the inputs cur,
+// to the artifact store. Failures are logged; the cache is best-effort.
+func StoreArtifact(ctx context.Context, store *artifact.Store, jobID, jobDir string, cur Inputs) {
+	if store == nil {
+		return
+	}
+	if err := store.Put(ctx, artifact.Key(cur.Hash), jobDir); err != nil {
+		log.Printf("build: %s: artifact cache: %v", jobID, err)
+	}
+}
@@ -4,16 +4,26 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
+	"github.com/yegor-usoltsev/cronctl/internal/artifact"
 	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/version"
 )
 
 type Options struct {
 	Force    bool
 	Parallel int
+	// Artifacts, if set, is consulted before running a build and receives
+	// the outputs of successful builds.
+	Artifacts *artifact.Store
+	// Verbose streams build output live, prefixed with the job ID.
+	Verbose bool
 }
 
 func All(ctx context.Context, _ string, jobs []job.Job, opts Options) error {
@@ -40,7 +50,7 @@ func All(ctx context.Context, _ string, jobs []job.Job, opts Options) error {
 			if err := ctx.Err(); err != nil {
 				return
 			}
-			if err := one(ctx, j, opts.Force); err != nil {
+			if err := one(ctx, j, opts); err != nil {
 				select {
 				case errCh <- err:
 				default:
@@ -77,7 +87,7 @@ func All(ctx context.Context, _ string, jobs []job.Job, opts Options) error {
 	}
 }
 
-func one(ctx context.Context, j job.Job, force bool) error {
+func one(ctx context.Context, j job.Job, opts Options) error {
 	if err := ctx.Err(); err != nil {
 		return fmt.Errorf("job %s: %w", j.ID, err)
 	}
@@ -93,34 +103,110 @@ func one(ctx context.Context, j job.Job, force bool) error {
 	}
 
 	statePath := StateFilePath(j.Dir)
-	curHash, err := InputsHash(ctx, j.Dir)
+	cur, err := HashInputs(ctx, j.Dir, j.RawYAML)
 	if err != nil {
 		return fmt.Errorf("job %s: hash inputs: %w", j.ID, err)
 	}
 
 	prevHash, ok := ReadHash(statePath)
-	if !force && ok && prevHash == curHash {
+	if !opts.Force && ok && prevHash == cur.Hash {
 		log.Printf("build: %s: skipped (cache)", j.ID)
 		return nil
 	}
+	if !opts.Force {
+		if st, ok := RestoreArtifact(ctx, opts.Artifacts, j.ID, j.Dir, j.RawYAML, cur); ok {
+			if err := WriteState(statePath, st); err != nil {
+				return fmt.Errorf("job %s: write state: %w", j.ID, err)
+			}
+			return nil
+		}
+	}
 
 	log.Printf("build: %s: running %s", j.ID, entrypoint)
-	if err := runBuild(ctx, j.Dir, entrypoint); err != nil {
-		var ee *execError
+	started := time.Now()
+	var stream io.Writer
+	if opts.Verbose {
+		stream = os.Stderr
+	}
+	err = Exec(ctx, ExecOptions{JobID: j.ID, Dir: j.Dir, Entrypoint: entrypoint, Spec: j.Spec.Build, Credential: nil, Stream: stream})
+	if err != nil {
+		var ee *ExecError
 		if errors.As(err, &ee) {
+			// Record the failure so cache inspection can show it; a failed
+			// state never counts as a cache hit.
+			_ = WriteState(statePath, NewState(cur.Hash, cur, started, ee.Code))
 			return fmt.Errorf("job %s: build failed (%s): %w", j.ID, ee.Path, err)
 		}
 		return fmt.Errorf("job %s: build failed: %w", j.ID, err)
 	}
 
 	// Recompute after build so cache reflects in-place changes (esp. non-git mode).
-	newHash, err := InputsHash(ctx, j.Dir)
+	after, err := HashInputs(ctx, j.Dir, j.RawYAML)
 	if err != nil {
 		return fmt.Errorf("job %s: hash inputs after build: %w", j.ID, err)
 	}
-	if err := WriteHash(statePath, newHash); err != nil {
+	if err := WriteState(statePath, NewState(cur.Hash, after, started, 0)); err != nil {
 		return fmt.Errorf("job %s: write state: %w", j.ID, err)
 	}
+	StoreArtifact(ctx, opts.Artifacts, j.ID, j.Dir, cur)
 	log.Printf("build: %s: ok", j.ID)
 	return nil
 }
+
+// NewState returns the state for a build of the inputs with hash source that
+// started at started, finished now with exitCode and left the job inputs as in.
+func NewState(source string, in Inputs, started time.Time, exitCode int) State {
+	return State{
+		Version:        StateVersion,
+		Hash:           in.Hash,
+		SourceHash:     source,
+		Files:          in.Files,
+		DurationMS:     time.Since(started).Milliseconds(),
+		ExitCode:       exitCode,
+		CronctlVersion: version.Version,
+		BuiltAt:        started.UTC(),
+	}
+}
+
+// WhyResult explains whether and why a job would be rebuilt.
+type WhyResult struct {
+	JobID       string   `json:"job_id"`
+	Cached      bool     `json:"cached"`
+	CachedHash  string   `json:"cached_hash,omitempty"`
+	CurrentHash string   `json:"current_hash"`
+	Changes     []Change `json:"changes"`
+	// Legacy is set when the cached state has no per-file digests, so
+	// individual changes cannot be listed.
+	Legacy bool  `json:"legacy,omitempty"`
+	State  State `json:"-"`
+}
+
+// Stale reports whether a build would run.
+func (w WhyResult) Stale() bool {
+	return !w.Cached || !w.State.OK() || w.CachedHash != w.CurrentHash
+}
+
+// Why compares the cached build state of j with its current inputs.
+func Why(ctx context.Context, j job.Job) (WhyResult, error) {
+	cur, err := HashInputs(ctx, j.Dir, j.RawYAML)
+	if err != nil {
+		return WhyResult{}, fmt.Errorf("job %s: hash inputs: %w", j.ID, err)
+	}
+	res := WhyResult{JobID: j.ID, Cached: false, CachedHash: "", CurrentHash: cur.Hash, Changes: nil, Legacy: false, State: State{}}
+	st, ok := ReadState(StateFilePath(j.Dir))
+	if !ok {
+		res.Changes = Diff(nil, cur.Files)
+		return res, nil
+	}
+	res.Cached = true
+	res.CachedHash = st.Hash
+	res.State = st
+	switch {
+	case st.Hash == cur.Hash:
+	case st.Files == nil:
+		res.Legacy = true
+	default:
+		res.Changes = Diff(st.Files, cur.Files)
+	}
+	return res, nil
+}
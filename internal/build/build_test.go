@@ -1,12 +1,19 @@
 package build
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/yegor-usoltsev/cronctl/internal/artifact"
 	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/manifest"
 )
 
 func TestAll_SkipsAndWritesState(t *testing.T) {
@@ -29,9 +36,16 @@ func TestAll_SkipsAndWritesState(t *testing.T) {
 		t.Fatalf("All: %v", err)
 	}
 
-	statePath := filepath.Join(jobDir, ".cronctl", "filehash")
-	if _, err := os.Stat(statePath); err != nil {
-		t.Fatalf("expected filehash, stat: %v", err)
+	statePath := filepath.Join(jobDir, ".cronctl", "state.json")
+	st, ok := ReadState(statePath)
+	if !ok {
+		t.Fatalf("expected state at %s", statePath)
+	}
+	if !st.OK() || st.Version != StateVersion || st.CronctlVersion == "" || st.BuiltAt.IsZero() {
+		t.Fatalf("unexpected state: %+v", st)
+	}
+	if _, ok := st.Files["build.sh"]; !ok {
+		t.Fatalf("expected per-file digest for build.sh, got %v", st.Files)
 	}
 
 	// Second run should hit cache.
@@ -39,3 +53,292 @@ func TestAll_SkipsAndWritesState(t *testing.T) {
 		t.Fatalf("All (2): %v", err)
 	}
 }
+
+func TestReadState_MigratesLegacyFilehash(t *testing.T) {
+	t.Parallel()
+	jobDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(jobDir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("write run.sh: %v", err)
+	}
+	hash, err := InputsHash(context.Background(), jobDir, nil)
+	if err != nil {
+		t.Fatalf("InputsHash: %v", err)
+	}
+	legacy := filepath.Join(jobDir, ".cronctl", "filehash")
+	if err := os.MkdirAll(filepath.Dir(legacy), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(legacy, []byte(hash+"\n"), 0o644); err != nil {
+		t.Fatalf("write filehash: %v", err)
+	}
+
+	statePath := StateFilePath(jobDir)
+	got, ok := ReadHash(statePath)
+	if !ok || got != hash {
+		t.Fatalf("ReadHash = %q, %v; want %q", got, ok, hash)
+	}
+
+	j := job.Job{ID: "a-job", Dir: jobDir}
+	why, err := Why(context.Background(), j)
+	if err != nil {
+		t.Fatalf("Why: %v", err)
+	}
+	if why.Stale() {
+		t.Fatalf("expected migrated state to be fresh: %+v", why)
+	}
+
+	st, _ := ReadState(statePath)
+	if err := WriteState(statePath, st); err != nil {
+		t.Fatalf("WriteState: %v", err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Fatalf("expected legacy filehash to be removed, stat: %v", err)
+	}
+}
+
+func TestWhy_ListsChangedFiles(t *testing.T) {
+	t.Parallel()
+	jobDir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(jobDir, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	write("a.txt", "a")
+	write("b.txt", "b")
+	in, err := HashInputs(context.Background(), jobDir, nil)
+	if err != nil {
+		t.Fatalf("HashInputs: %v", err)
+	}
+	if err := WriteState(StateFilePath(jobDir), NewState(in.Hash, in, time.Now(), 0)); err != nil {
+		t.Fatalf("WriteState: %v", err)
+	}
+
+	write("a.txt", "changed")
+	write("c.txt", "c")
+	if err := os.Remove(filepath.Join(jobDir, "b.txt")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	why, err := Why(context.Background(), job.Job{ID: "x", Dir: jobDir})
+	if err != nil {
+		t.Fatalf("Why: %v", err)
+	}
+	want := []Change{{Path: "a.txt", Kind: ChangeModified}, {Path: "b.txt", Kind: ChangeRemoved}, {Path: "c.txt", Kind: ChangeAdded}}
+	if !reflect.DeepEqual(why.Changes, want) {
+		t.Fatalf("changes = %+v, want %+v", why.Changes, want)
+	}
+}
+
+func TestWhy_ListsEffectiveSpecChange(t *testing.T) {
+	t.Parallel()
+	jobDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(jobDir, "job.yaml"), []byte("name: x\n"), 0o644); err != nil {
+		t.Fatalf("write job.yaml: %v", err)
+	}
+	j := job.Job{ID: "x", Dir: jobDir, RawYAML: []byte("name: x\nbuild:\n  env:\n    GOFLAGS: -mod=vendor\n")}
+	in, err := HashInputs(context.Background(), jobDir, j.RawYAML)
+	if err != nil {
+		t.Fatalf("HashInputs: %v", err)
+	}
+	if err := WriteState(StateFilePath(jobDir), NewState(in.Hash, in, time.Now(), 0)); err != nil {
+		t.Fatalf("WriteState: %v", err)
+	}
+
+	// Only _defaults.yaml changed: no file of the job dir did.
+	j.RawYAML = []byte("name: x\nbuild:\n  env:\n    GOFLAGS: -mod=mod\n")
+	why, err := Why(context.Background(), j)
+	if err != nil {
+		t.Fatalf("Why: %v", err)
+	}
+	want := []Change{{Path: SpecInput, Kind: ChangeModified}}
+	if !why.Stale() || !reflect.DeepEqual(why.Changes, want) {
+		t.Fatalf("why = %+v, want stale with changes %+v", why, want)
+	}
+}
+
+func TestInspectAndClearState(t *testing.T) {
+	t.Parallel()
+	jobDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(jobDir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("write run.sh: %v", err)
+	}
+	j := job.Job{ID: "a-job", Dir: jobDir}
+	now := time.Now()
+
+	e, err := Inspect(context.Background(), j, now)
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	if e.Status != CacheMissing {
+		t.Fatalf("status = %q, want %q", e.Status, CacheMissing)
+	}
+
+	in, err := HashInputs(context.Background(), jobDir, nil)
+	if err != nil {
+		t.Fatalf("HashInputs: %v", err)
+	}
+	if err := WriteState(StateFilePath(jobDir), NewState(in.Hash, in, now.Add(-time.Minute), 0)); err != nil {
+		t.Fatalf("WriteState: %v", err)
+	}
+	if e, _ = Inspect(context.Background(), j, now); e.Status != CacheFresh || e.AgeSeconds < 60 {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+
+	if err := os.WriteFile(filepath.Join(jobDir, "run.sh"), []byte("#!/bin/sh\necho changed\n"), 0o755); err != nil {
+		t.Fatalf("write run.sh: %v", err)
+	}
+	if e, _ = Inspect(context.Background(), j, now); e.Status != CacheStale {
+		t.Fatalf("status = %q, want %q", e.Status, CacheStale)
+	}
+
+	if err := WriteState(StateFilePath(jobDir), NewState(in.Hash, in, now, 2)); err != nil {
+		t.Fatalf("WriteState: %v", err)
+	}
+	if e, _ = Inspect(context.Background(), j, now); e.Status != CacheFailed {
+		t.Fatalf("status = %q, want %q", e.Status, CacheFailed)
+	}
+
+	removed, err := ClearState(jobDir)
+	if err != nil || !removed {
+		t.Fatalf("ClearState = %v, %v", removed, err)
+	}
+	if removed, _ = ClearState(jobDir); removed {
+		t.Fatalf("expected second ClearState to remove nothing")
+	}
+}
+
+func TestAll_RestoresFromArtifactCache(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	store := &artifact.Store{Dir: filepath.Join(root, "artifacts")}
+	// The build refuses to run twice, so the second job must be restored.
+	script := "#!/usr/bin/env bash\nset -euo pipefail\n[ ! -e ../../ran ]\ntouch ../../ran\necho bin > out.bin\n"
+
+	var jobs []job.Job
+	for _, host := range []string{"host-a", "host-b"} {
+		jobDir := filepath.Join(root, host, "a-job")
+		if err := os.MkdirAll(jobDir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(jobDir, "build.sh"), []byte(script), 0o755); err != nil {
+			t.Fatalf("write build.sh: %v", err)
+		}
+		jobs = append(jobs, job.Job{ID: "a-job", Dir: jobDir, Spec: job.Spec{Enabled: true, Build: job.BuildSpec{Enabled: true, Entrypoint: "build.sh"}}})
+	}
+
+	for _, j := range jobs {
+		if err := All(context.Background(), root, []job.Job{j}, Options{Parallel: 1, Artifacts: store}); err != nil {
+			t.Fatalf("All(%s): %v", j.Dir, err)
+		}
+		if _, err := os.Stat(filepath.Join(j.Dir, "out.bin")); err != nil {
+			t.Fatalf("expected build output in %s: %v", j.Dir, err)
+		}
+		if _, ok := ReadHash(StateFilePath(j.Dir)); !ok {
+			t.Fatalf("expected state in %s", j.Dir)
+		}
+	}
+}
+
+func TestExec_FailureReportsLogTail(t *testing.T) {
+	t.Parallel()
+	jobDir := t.TempDir()
+	script := "#!/bin/sh\nfor i in $(seq 1 30); do echo \"line $i\"; done\necho boom >&2\nexit 3\n"
+	if err := os.WriteFile(filepath.Join(jobDir, "build.sh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write build.sh: %v", err)
+	}
+
+	var stream bytes.Buffer
+	err := Exec(context.Background(), ExecOptions{JobID: "a-job", Dir: jobDir, Entrypoint: "build.sh", Stream: &stream})
+	var ee *ExecError
+	if !errors.As(err, &ee) {
+		t.Fatalf("expected *ExecError, got %v", err)
+	}
+	if ee.Code != 3 || len(ee.Tail) != tailLines || ee.Tail[len(ee.Tail)-1] != "boom" {
+		t.Fatalf("unexpected error: %+v", ee)
+	}
+	b, err := os.ReadFile(LogFilePath(jobDir))
+	if err != nil {
+		t.Fatalf("read build log: %v", err)
+	}
+	if !strings.HasPrefix(string(b), "line 1\n") || !strings.HasSuffix(string(b), "boom\n") {
+		t.Fatalf("unexpected build log:\n%s", b)
+	}
+	if !strings.HasPrefix(stream.String(), "[a-job] line 1\n") {
+		t.Fatalf("unexpected stream output:\n%s", stream.String())
+	}
+}
+
+func TestExec_Timeout(t *testing.T) {
+	t.Parallel()
+	jobDir := t.TempDir()
+	// The background child keeps the output pipe open; it must be killed too.
+	script := "#!/bin/sh\nsleep 30 &\necho started\nsleep 30\n"
+	if err := os.WriteFile(filepath.Join(jobDir, "build.sh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write build.sh: %v", err)
+	}
+
+	started := time.Now()
+	err := Exec(context.Background(), ExecOptions{JobID: "a-job", Dir: jobDir, Entrypoint: "build.sh", Spec: job.BuildSpec{Timeout: "200ms"}})
+	var ee *ExecError
+	if !errors.As(err, &ee) || !ee.TimedOut {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("timeout took too long: %s", elapsed)
+	}
+	if !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Fatalf("unexpected message: %v", err)
+	}
+}
+
+func TestExport_WritesPayloadAndManifest(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	jobsDir := filepath.Join(root, "jobs")
+	jobDir := filepath.Join(jobsDir, "a-job")
+	if err := os.MkdirAll(filepath.Join(jobDir, "src"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for rel, content := range map[string]string{
+		"build.sh":    "#!/bin/sh\ncp src/main.sh app\n",
+		"run.sh":      "#!/bin/sh\nexec ./app\n",
+		"src/main.sh": "#!/bin/sh\necho ok\n",
+	} {
+		if err := os.WriteFile(filepath.Join(jobDir, rel), []byte(content), 0o755); err != nil {
+			t.Fatalf("write %s: %v", rel, err)
+		}
+	}
+	j := job.Job{ID: "a-job", Dir: jobDir, Spec: job.Spec{Enabled: true, Build: job.BuildSpec{Enabled: true}, Payload: job.PayloadSpec{Include: []string{"run.sh", "app"}}}}
+	source, err := InputsHash(context.Background(), jobDir, nil)
+	if err != nil {
+		t.Fatalf("InputsHash: %v", err)
+	}
+
+	outDir := filepath.Join(root, "out")
+	if err := Export(context.Background(), j, outDir, manifest.Deploy{}); !errors.Is(err, errNotBuilt) {
+		t.Fatalf("Export before build: expected errNotBuilt, got %v", err)
+	}
+	if err := All(context.Background(), jobsDir, []job.Job{j}, Options{Parallel: 1}); err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	if err := Export(context.Background(), j, outDir, manifest.Deploy{}); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	payloadDir := filepath.Join(outDir, "a-job")
+	m, err := manifest.Read(manifest.Path(payloadDir))
+	if err != nil {
+		t.Fatalf("Read manifest: %v", err)
+	}
+	if m.InputHash != source {
+		t.Fatalf("manifest input hash = %s, want pre-build hash %s", m.InputHash, source)
+	}
+	if len(m.Files) != 2 || m.Files["app"].SHA256 == "" || m.Files["run.sh"].Mode != "0755" {
+		t.Fatalf("unexpected manifest files: %+v", m.Files)
+	}
+	if err := m.Verify(payloadDir); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+)
+
+// Cache entry statuses reported by Inspect.
+const (
+	CacheFresh   = "fresh"
+	CacheStale   = "stale"
+	CacheFailed  = "failed"
+	CacheMissing = "missing"
+)
+
+// CacheEntry describes the build cache of a single job dir.
+type CacheEntry struct {
+	JobID       string    `json:"job_id"`
+	Dir         string    `json:"dir"`
+	CachedHash  string    `json:"cached_hash,omitempty"`
+	CurrentHash string    `json:"current_hash"`
+	Status      string    `json:"status"`
+	BuiltAt     time.Time `json:"built_at,omitzero"`
+	AgeSeconds  int64     `json:"age_seconds,omitempty"`
+	ExitCode    int       `json:"exit_code"`
+}
+
+// Inspect compares the cached build state of j with its current inputs.
+func Inspect(ctx context.Context, j job.Job, now time.Time) (CacheEntry, error) {
+	cur, err := InputsHash(ctx, j.Dir, j.RawYAML)
+	if err != nil {
+		return CacheEntry{}, fmt.Errorf("job %s: hash inputs: %w", j.ID, err)
+	}
+	e := CacheEntry{JobID: j.ID, Dir: j.Dir, CachedHash: "", CurrentHash: cur, Status: CacheMissing, BuiltAt: time.Time{}, AgeSeconds: 0, ExitCode: 0}
+	st, ok := ReadState(StateFilePath(j.Dir))
+	if !ok {
+		return e, nil
+	}
+	e.CachedHash = st.Hash
+	e.ExitCode = st.ExitCode
+	e.BuiltAt = st.BuiltAt
+	if !st.BuiltAt.IsZero() {
+		e.AgeSeconds = int64(now.Sub(st.BuiltAt).Seconds())
+	}
+	switch {
+	case !st.OK():
+		e.Status = CacheFailed
+	case st.Hash == cur:
+		e.Status = CacheFresh
+	default:
+		e.Status = CacheStale
+	}
+	return e, nil
+}
+
+// ClearState removes the build state of a job dir, including legacy filehash
+// files. It reports whether anything was removed.
+func ClearState(jobDir string) (bool, error) {
+	removed := false
+	for _, p := range []string{StateFilePath(jobDir), filepath.Join(StateDir(jobDir), legacyStateFileName)} {
+		if err := os.Remove(p); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return removed, fmt.Errorf("remove %s: %w", p, err)
+		}
+		removed = true
+	}
+	return removed, nil
+}
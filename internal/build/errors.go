@@ -3,5 +3,7 @@ package build
 import "errors"
 
 var (
-	errEmptyHash = errors.New("empty hash")
+	errEmptyHash    = errors.New("empty hash")
+	errNotBuilt     = errors.New("no up-to-date successful build")
+	errNoSourceHash = errors.New("build state has no source hash (rebuild with --force)")
 )
@@ -1,78 +1,250 @@
 package build
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/sandbox"
+)
+
+const (
+	logFileName       = "build.log"
+	failedLogFileName = "last-failed-build.log"
+	// tailLines is how many trailing log lines a failed build reports.
+	tailLines = 20
+	// waitDelay bounds how long we wait for output pipes after the build
+	// process exits or is killed (e.g. when a child keeps them open).
+	waitDelay = 5 * time.Second
 )
 
-type execError struct {
-	Path   string
-	Code   int
-	Stdout string
-	Stderr string
+// ExecError is returned when a build entrypoint fails or times out.
+type ExecError struct {
+	Path     string
+	Code     int
+	TimedOut bool
+	Timeout  time.Duration
+	LogPath  string
+	// Tail holds the last lines of the build log.
+	Tail []string
 }
 
-func (e *execError) Error() string {
+func (e *ExecError) Error() string {
 	msg := fmt.Sprintf("exit %d", e.Code)
-	if e.Stderr != "" {
-		msg += ": " + e.Stderr
+	if e.TimedOut {
+		msg = "timed out after " + e.Timeout.String()
+	}
+	if len(e.Tail) == 0 {
+		return msg
 	}
-	return msg
+	var b strings.Builder
+	if e.LogPath == "" {
+		fmt.Fprintf(&b, "%s (last %d lines):", msg, len(e.Tail))
+	} else {
+		fmt.Fprintf(&b, "%s (log: %s, last %d lines):", msg, e.LogPath, len(e.Tail))
+	}
+	for _, l := range e.Tail {
+		b.WriteString("\n  | ")
+		b.WriteString(l)
+	}
+	return b.String()
+}
+
+// LogFilePath returns the path of the captured build log for a job dir.
+func LogFilePath(jobDir string) string {
+	return filepath.Join(StateDir(jobDir), logFileName)
+}
+
+// FailedLogFilePath returns where sync keeps the log of a failed build in a
+// deployed job dir, next to the state of the payload still in use.
+func FailedLogFilePath(jobDir string) string {
+	return filepath.Join(StateDir(jobDir), failedLogFileName)
 }
 
-func runBuild(ctx context.Context, jobDir, entrypoint string) error {
+// ExecOptions configures Exec.
+type ExecOptions struct {
+	JobID string
+	// Dir is the job dir the build runs in.
+	Dir string
+	// Entrypoint is the build script path relative to Dir.
+	Entrypoint string
+	Spec       job.BuildSpec
+	// Credential, if set, runs the build as another user.
+	Credential *syscall.Credential
+	// Stream, if set, receives the build output live, one "[job-id] line"
+	// at a time.
+	Stream io.Writer
+}
+
+// Exec runs the build entrypoint of a job. The combined output is written to
+// .cronctl/build.log in the job dir; failures return an *ExecError with the
+// tail of that log.
+func Exec(ctx context.Context, o ExecOptions) error {
 	if err := ctx.Err(); err != nil {
 		return fmt.Errorf("build: %w", err)
 	}
+	timeout, err := o.Spec.TimeoutDuration()
+	if err != nil {
+		return fmt.Errorf("build.timeout: %w", err)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
 	// Execute directly to preserve shebang and executable bit.
-	path := filepath.Join(jobDir, entrypoint)
-	abs, err := filepath.Abs(path)
+	abs, err := filepath.Abs(filepath.Join(o.Dir, o.Entrypoint))
 	if err != nil {
-		return fmt.Errorf("abs %s: %w", path, err)
+		return fmt.Errorf("abs %s: %w", o.Entrypoint, err)
+	}
+	cmd, err := Command(ctx, o.Dir, abs, o.Spec, o.Credential)
+	if err != nil {
+		return err
 	}
-	cmd := exec.CommandContext(ctx, abs)
-	cmd.Dir = jobDir
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
 
-	err = cmd.Start()
+	logPath := LogFilePath(o.Dir)
+	if err := os.MkdirAll(filepath.Dir(logPath), 0o755); err != nil {
+		return fmt.Errorf("mkdir state dir: %w", err)
+	}
+	// #nosec G302 G304 -- build logs are non-secret and live next to the build state.
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
 	if err != nil {
-		return fmt.Errorf("start %s: %w", abs, err)
-	}
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
-	select {
-	case err := <-done:
-		if err == nil {
-			return nil
-		}
-		var exitErr *exec.ExitError
-		if errors.As(err, &exitErr) {
-			code := exitErr.ExitCode()
-			return &execError{Path: abs, Code: code, Stdout: stdout.String(), Stderr: trimOneLine(stderr.String())}
+		return fmt.Errorf("create build log: %w", err)
+	}
+	defer func() { _ = logFile.Close() }()
+
+	var out io.Writer = logFile
+	var stream *prefixWriter
+	if o.Stream != nil {
+		stream = &prefixWriter{w: o.Stream, prefix: "[" + o.JobID + "] ", buf: nil}
+		out = io.MultiWriter(logFile, stream)
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = waitDelay
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start %s: %w", abs, StartError(o.Spec, err))
+	}
+	err = cmd.Wait()
+	if stream != nil {
+		stream.flush()
+	}
+	if cerr := logFile.Close(); cerr != nil && err == nil {
+		return fmt.Errorf("close build log: %w", cerr)
+	}
+	if err == nil {
+		return nil
+	}
+
+	timedOut := timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) && !timedOut {
+		if ctx.Err() != nil {
+			return fmt.Errorf("build: %w", ctx.Err())
 		}
 		return fmt.Errorf("run %s: %w", abs, err)
-	case <-ctx.Done():
-		_ = cmd.Process.Kill()
-		return fmt.Errorf("build: %w", ctx.Err())
+	}
+	code := -1
+	if exitErr != nil {
+		code = exitErr.ExitCode()
+	}
+	return &ExecError{Path: abs, Code: code, TimedOut: timedOut, Timeout: timeout, LogPath: logPath, Tail: readTail(logPath, tailLines)}
+}
+
+func readTail(path string, n int) []string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return nil
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// streamMu serializes writes of whole lines from parallel builds.
+var streamMu sync.Mutex //nolint:gochecknoglobals
+
+// prefixWriter writes complete lines to w, each prefixed with prefix.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
 	}
 }
 
-func trimOneLine(s string) string {
-	// Keep error messages stable and short; stderr is still available in execError.
-	for i := range len(s) {
-		if s[i] == '\n' || s[i] == '\r' {
-			return s[:i]
+func (p *prefixWriter) flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	w := bufio.NewWriter(p.w)
+	_, _ = w.WriteString(p.prefix)
+	_, _ = w.Write(line)
+	_ = w.Flush()
+}
+
+// Command returns the command that runs the build entrypoint at path in dir,
+// sandboxed if the spec asks for it. A non-nil cred runs it as another user.
+func Command(ctx context.Context, dir, path string, spec job.BuildSpec, cred *syscall.Credential) (*exec.Cmd, error) {
+	if spec.Sandbox {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("abs %s: %w", path, err)
+		}
+		cmd, err := sandbox.Command(ctx, abs, sandbox.Options{Dir: dir, Network: spec.Network, Credential: cred})
+		if err != nil {
+			return nil, fmt.Errorf("sandbox: %w", err)
 		}
+		return cmd, nil
+	}
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = dir
+	// Run in its own process group so a timeout kills the whole build.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred, Setpgid: true} //nolint:exhaustruct
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd, nil
+}
+
+// StartError explains a failure to start a build command.
+func StartError(spec job.BuildSpec, err error) error {
+	if spec.Sandbox {
+		return sandbox.Explain(err)
 	}
-	return s
+	return err
 }
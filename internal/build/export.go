@@ -0,0 +1,89 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/manifest"
+	"github.com/yegor-usoltsev/cronctl/internal/payload"
+)
+
+// Export writes the deployable payload of the built job j to outDir/<id>,
+// with a manifest recording the source inputs hash, file digests and deploy.
+func Export(ctx context.Context, j job.Job, outDir string, deploy manifest.Deploy) error {
+	if !j.Spec.Enabled {
+		return nil
+	}
+	source, err := sourceHash(ctx, j)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", j.ID, err)
+	}
+	f, err := payload.Load(j.Dir, j.Spec.Payload)
+	if err != nil {
+		return fmt.Errorf("job %s: load payload filter: %w", j.ID, err)
+	}
+
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", outDir, err)
+	}
+	tmp, err := os.MkdirTemp(outDir, "."+j.ID+".tmp-")
+	if err != nil {
+		return fmt.Errorf("job %s: mkdir temp: %w", j.ID, err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+	// #nosec G302 -- payload dirs are world-readable like deployed ones.
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		return fmt.Errorf("job %s: chmod %s: %w", j.ID, tmp, err)
+	}
+	if err := payload.Copy(j.Dir, tmp, f); err != nil {
+		return fmt.Errorf("job %s: copy payload: %w", j.ID, err)
+	}
+	// Deploy the effective spec, with inherited defaults merged in.
+	if len(j.RawYAML) > 0 {
+		// #nosec G306 -- job.yaml is deployed world-readable.
+		if err := os.WriteFile(filepath.Join(tmp, "job.yaml"), j.RawYAML, 0o644); err != nil {
+			return fmt.Errorf("job %s: write spec: %w", j.ID, err)
+		}
+	}
+	m, err := manifest.New(j.ID, source, tmp)
+	if err != nil {
+		return fmt.Errorf("job %s: manifest: %w", j.ID, err)
+	}
+	m.Deploy = &deploy
+	if err := manifest.Write(manifest.Path(tmp), m); err != nil {
+		return fmt.Errorf("job %s: %w", j.ID, err)
+	}
+
+	dst := filepath.Join(outDir, j.ID)
+	if err := os.RemoveAll(dst); err != nil {
+		return fmt.Errorf("job %s: remove %s: %w", j.ID, dst, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return fmt.Errorf("job %s: rename %s: %w", j.ID, dst, err)
+	}
+	return nil
+}
+
+// sourceHash returns the inputs hash of the sources j was built from: the
+// current hash for jobs without a build, or the pre-build hash recorded by an
+// up-to-date successful build.
+func sourceHash(ctx context.Context, j job.Job) (string, error) {
+	cur, err := HashInputs(ctx, j.Dir, j.RawYAML)
+	if err != nil {
+		return "", fmt.Errorf("hash inputs: %w", err)
+	}
+	if !j.Spec.Build.Enabled {
+		return cur.Hash, nil
+	}
+	st, ok := ReadState(StateFilePath(j.Dir))
+	switch {
+	case !ok || !st.OK() || st.Hash != cur.Hash:
+		return "", errNotBuilt
+	case st.SourceHash == "":
+		return "", errNoSourceHash
+	}
+	return st.SourceHash, nil
+}
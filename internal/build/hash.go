@@ -1,7 +1,6 @@
 package build
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
@@ -12,9 +11,25 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+
+	"github.com/yegor-usoltsev/cronctl/internal/ignore"
 )
 
-// InputsHash computes the hash for a job directory.
+// SpecInput is the key of the effective job spec in Inputs.Files.
+const SpecInput = "(effective job spec)"
+
+// Inputs describes the hashed inputs of a job directory.
+type Inputs struct {
+	// Hash is the overall digest over paths, modes and contents of all inputs.
+	Hash string
+	// Files maps slash-separated paths (relative to the job dir) to a digest of
+	// the file mode and contents.
+	Files map[string]string
+}
+
+// InputsHash computes the hash for a job directory and its effective spec
+// (job.Job.RawYAML): _defaults.yaml, cronctl.yaml and env overlays change
+// the build environment without touching any file of the job dir.
 //
 // In a git repository, it relies on `git ls-files` to respect all applicable
 // .gitignore files (from the repo root down to the job directory), including
@@ -22,43 +37,111 @@ import (
 //
 // Outside of git, it walks the job directory and applies only the job-local
 // .gitignore.
-func InputsHash(ctx context.Context, jobDir string) (string, error) {
+func InputsHash(ctx context.Context, jobDir string, spec []byte) (string, error) {
+	in, err := HashInputs(ctx, jobDir, spec)
+	if err != nil {
+		return "", err
+	}
+	return in.Hash, nil
+}
+
+// HashInputs is like InputsHash but also returns per-file digests.
+func HashInputs(ctx context.Context, jobDir string, spec []byte) (Inputs, error) {
 	if err := ctx.Err(); err != nil {
-		return "", fmt.Errorf("hash inputs: %w", err)
+		return Inputs{}, fmt.Errorf("hash inputs: %w", err)
 	}
+	var (
+		files []inputFile
+		err   error
+	)
 	if root, ok := detectGitRoot(ctx, jobDir); ok {
-		return gitInputsHash(ctx, root, jobDir)
+		files, err = gitInputFiles(ctx, root, jobDir)
+	} else {
+		var m ignore.Matcher
+		m, err = loadJobIgnore(jobDir)
+		if err != nil {
+			return Inputs{}, fmt.Errorf("load job ignore: %w", err)
+		}
+		files, err = walkInputFiles(ctx, jobDir, m)
 	}
-	m, err := loadJobIgnore(jobDir)
 	if err != nil {
-		return "", fmt.Errorf("load job ignore: %w", err)
+		return Inputs{}, err
 	}
-	return walkHash(ctx, jobDir, m)
+	return hashFiles(files, spec)
+}
+
+type inputFile struct {
+	rel  string
+	abs  string
+	mode fs.FileMode
 }
 
-func gitInputsHash(ctx context.Context, gitRoot, jobDir string) (string, error) {
+func hashFiles(files []inputFile, spec []byte) (Inputs, error) {
+	sort.Slice(files, func(i, j int) bool { return files[i].rel < files[j].rel })
+	in := Inputs{Hash: "", Files: make(map[string]string, len(files))}
+	h := sha256.New()
+	for _, f := range files {
+		fh := sha256.New()
+		_, _ = io.WriteString(fh, f.mode.String())
+		_, _ = fh.Write([]byte{0})
+
+		_, _ = io.WriteString(h, f.rel)
+		_, _ = h.Write([]byte{0})
+		_, _ = io.WriteString(h, f.mode.String())
+		_, _ = h.Write([]byte{0})
+		if err := copyFileInto(io.MultiWriter(h, fh), f.abs); err != nil {
+			return Inputs{}, err
+		}
+		_, _ = h.Write([]byte{0})
+		in.Files[f.rel] = hex.EncodeToString(fh.Sum(nil))
+	}
+	if len(spec) > 0 {
+		sum := sha256.Sum256(spec)
+		_, _ = io.WriteString(h, SpecInput)
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write(spec)
+		_, _ = h.Write([]byte{0})
+		in.Files[SpecInput] = hex.EncodeToString(sum[:])
+	}
+	in.Hash = hex.EncodeToString(h.Sum(nil))
+	return in, nil
+}
+
+func copyFileInto(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("read input: %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("read input: %s: %w", path, err)
+	}
+	return nil
+}
+
+func gitInputFiles(ctx context.Context, gitRoot, jobDir string) ([]inputFile, error) {
 	paths, err := gitListInputs(ctx, gitRoot, jobDir)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	jobAbs, err := filepath.Abs(jobDir)
 	if err != nil {
-		return "", fmt.Errorf("abs job dir: %w", err)
+		return nil, fmt.Errorf("abs job dir: %w", err)
 	}
 	rootAbs, err := filepath.Abs(gitRoot)
 	if err != nil {
-		return "", fmt.Errorf("abs git root: %w", err)
+		return nil, fmt.Errorf("abs git root: %w", err)
 	}
 	relJobFromRoot, err := filepath.Rel(rootAbs, jobAbs)
 	if err != nil {
-		return "", fmt.Errorf("rel job dir: %w", err)
+		return nil, fmt.Errorf("rel job dir: %w", err)
 	}
 	relJobFromRoot = filepath.ToSlash(relJobFromRoot)
 	if relJobFromRoot != "" && relJobFromRoot != "." {
 		relJobFromRoot += "/"
 	}
 
-	h := sha256.New()
+	files := make([]inputFile, 0, len(paths))
 	for _, p := range paths {
 		if p == "" {
 			continue
@@ -70,37 +153,22 @@ func gitInputsHash(ctx context.Context, gitRoot, jobDir string) (string, error)
 		abs := filepath.Join(rootAbs, filepath.FromSlash(relJobFromRoot+p))
 		info, err := os.Stat(abs)
 		if err != nil {
-			return "", fmt.Errorf("stat input: %s: %w", abs, err)
+			return nil, fmt.Errorf("stat input: %s: %w", abs, err)
 		}
 		if !info.Mode().IsRegular() {
 			continue
 		}
-		b, err := os.ReadFile(abs)
-		if err != nil {
-			return "", fmt.Errorf("read input: %s: %w", abs, err)
-		}
-		_, _ = io.WriteString(h, p)
-		_, _ = h.Write([]byte{0})
-		_, _ = io.WriteString(h, info.Mode().String())
-		_, _ = h.Write([]byte{0})
-		_, _ = io.Copy(h, bytes.NewReader(b))
-		_, _ = h.Write([]byte{0})
+		files = append(files, inputFile{rel: p, abs: abs, mode: info.Mode()})
 	}
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return files, nil
 }
 
-func walkHash(ctx context.Context, jobDir string, ignore ignoreMatcher) (string, error) {
+func walkInputFiles(ctx context.Context, jobDir string, skip ignore.Matcher) ([]inputFile, error) {
 	if err := ctx.Err(); err != nil {
-		return "", fmt.Errorf("walk hash: %w", err)
-	}
-
-	type item struct {
-		path string
-		mode fs.FileMode
-		data []byte
+		return nil, fmt.Errorf("walk hash: %w", err)
 	}
-	var items []item
 
+	var files []inputFile
 	err := filepath.WalkDir(jobDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return fmt.Errorf("walk: %w", err)
@@ -116,7 +184,7 @@ func walkHash(ctx context.Context, jobDir string, ignore ignoreMatcher) (string,
 		if rel == "." {
 			return nil
 		}
-		if ignore.match(rel, d.IsDir()) {
+		if skip.Match(rel, d.IsDir()) {
 			if d.IsDir() {
 				return filepath.SkipDir
 			}
@@ -133,26 +201,11 @@ func walkHash(ctx context.Context, jobDir string, ignore ignoreMatcher) (string,
 			// Ignore symlinks/devices/etc.
 			return nil
 		}
-		b, rerr := os.ReadFile(path)
-		if rerr != nil {
-			return fmt.Errorf("read file: %w", rerr)
-		}
-		items = append(items, item{path: rel, mode: info.Mode(), data: b})
+		files = append(files, inputFile{rel: rel, abs: path, mode: info.Mode()})
 		return nil
 	})
 	if err != nil {
-		return "", fmt.Errorf("walk job dir: %w", err)
-	}
-
-	sort.Slice(items, func(i, j int) bool { return items[i].path < items[j].path })
-	h := sha256.New()
-	for _, it := range items {
-		_, _ = io.WriteString(h, it.path)
-		_, _ = h.Write([]byte{0})
-		_, _ = io.WriteString(h, it.mode.String())
-		_, _ = h.Write([]byte{0})
-		_, _ = io.Copy(h, bytes.NewReader(it.data))
-		_, _ = h.Write([]byte{0})
+		return nil, fmt.Errorf("walk job dir: %w", err)
 	}
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return files, nil
 }
@@ -1,162 +1,29 @@
 package build
 
 import (
-	"bufio"
 	"context"
-	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strings"
-)
-
-type ignoreRule struct {
-	negate   bool
-	dirOnly  bool
-	anchored bool
-	re       *regexp.Regexp
-}
-
-type ignoreMatcher struct {
-	rules []ignoreRule
-}
-
-func (m ignoreMatcher) match(rel string, isDir bool) bool {
-	rel = filepath.ToSlash(rel)
-	if rel == "" || rel == "." {
-		return false
-	}
 
-	ignored := false
-	for _, r := range m.rules {
-		if r.dirOnly && !isDir {
-			continue
-		}
-		if r.re == nil {
-			continue
-		}
-		if !r.anchored {
-			// Gitignore patterns without a slash can match basenames anywhere.
-			// We approximate by matching both full rel and basename.
-			if !r.re.MatchString(rel) && !r.re.MatchString(filepath.Base(rel)) {
-				continue
-			}
-		} else {
-			if !r.re.MatchString(rel) {
-				continue
-			}
-		}
-		if r.negate {
-			ignored = false
-		} else {
-			ignored = true
-		}
-	}
-	return ignored
-}
+	"github.com/yegor-usoltsev/cronctl/internal/ignore"
+)
 
-func loadJobIgnore(jobDir string) (ignoreMatcher, error) {
+func loadJobIgnore(jobDir string) (ignore.Matcher, error) {
 	// Defaults: always ignore internal state and git metadata.
 	lines := []string{
 		".cronctl/",
 		".git/",
 	}
 
-	path := filepath.Join(jobDir, ".gitignore")
-	b, err := os.ReadFile(path)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return compileIgnore(lines), nil
-		}
-		return ignoreMatcher{}, fmt.Errorf("read %s: %w", path, err)
-	}
-	s := bufio.NewScanner(strings.NewReader(string(b)))
-	for s.Scan() {
-		lines = append(lines, s.Text())
-	}
-	if err := s.Err(); err != nil {
-		return ignoreMatcher{}, fmt.Errorf("scan %s: %w", path, err)
-	}
-	return compileIgnore(lines), nil
-}
-
-func compileIgnore(lines []string) ignoreMatcher {
-	rules := make([]ignoreRule, 0, len(lines))
-	for _, raw := range lines {
-		line := strings.TrimSpace(raw)
-		if line == "" {
-			continue
-		}
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-		r := ignoreRule{negate: false, dirOnly: false, anchored: false, re: nil}
-		if strings.HasPrefix(line, "!") {
-			r.negate = true
-			line = strings.TrimSpace(strings.TrimPrefix(line, "!"))
-		}
-		if strings.HasSuffix(line, "/") {
-			r.dirOnly = true
-			line = strings.TrimSuffix(line, "/")
-		}
-		if strings.HasPrefix(line, "/") {
-			r.anchored = true
-			line = strings.TrimPrefix(line, "/")
-		}
-		if line == "" {
-			continue
-		}
-		re, ok := gitignorePatternToRegexp(line, r.anchored)
-		if !ok {
-			continue
-		}
-		r.re = re
-		rules = append(rules, r)
-	}
-	return ignoreMatcher{rules: rules}
-}
-
-func gitignorePatternToRegexp(pat string, anchored bool) (*regexp.Regexp, bool) {
-	// Minimal gitignore -> regexp conversion.
-	// Supported: '*', '?', character classes, and '**' spanning directories.
-	// For anchored patterns, match from start. Otherwise, match anywhere.
-	var b strings.Builder
-	if anchored {
-		b.WriteString("^")
-	} else {
-		b.WriteString("(?:^|.*/)")
-	}
-
-	for i := 0; i < len(pat); i++ {
-		c := pat[i]
-		switch c {
-		case '*':
-			// '**' => any chars including '/'
-			if i+1 < len(pat) && pat[i+1] == '*' {
-				b.WriteString(".*")
-				i++
-				continue
-			}
-			b.WriteString("[^/]*")
-		case '?':
-			b.WriteString("[^/]")
-		case '.', '+', '(', ')', '|', '^', '$', '{', '}', '\\':
-			b.WriteByte('\\')
-			b.WriteByte(c)
-		default:
-			b.WriteByte(c)
-		}
-	}
-	b.WriteString("$")
-
-	re, err := regexp.Compile(b.String())
+	extra, err := ignore.ReadFile(filepath.Join(jobDir, ".gitignore"))
 	if err != nil {
-		return nil, false
+		return ignore.Matcher{}, err
 	}
-	return re, true
+	return ignore.Compile(append(lines, extra...)), nil
 }
 
 func detectGitRoot(ctx context.Context, dir string) (string, bool) {
@@ -1,33 +1,108 @@
 package build
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
-func ReadHash(path string) (string, bool) {
+// StateVersion is the current version of the on-disk build state format.
+const StateVersion = 1
+
+const (
+	stateFileName       = "state.json"
+	legacyStateFileName = "filehash"
+)
+
+// State is the build cache record stored in <job>/.cronctl/state.json.
+type State struct {
+	Version int `json:"version"`
+	// Hash is the inputs hash recorded after the build (see InputsHash).
+	Hash string `json:"hash"`
+	// SourceHash is the inputs hash before the build ran, i.e. without any
+	// build outputs that count as inputs.
+	SourceHash string `json:"source_hash,omitempty"`
+	// Files holds per-file digests of the inputs. Empty for states migrated
+	// from the legacy filehash format.
+	Files          map[string]string `json:"files,omitempty"`
+	DurationMS     int64             `json:"duration_ms"`
+	ExitCode       int               `json:"exit_code"`
+	CronctlVersion string            `json:"cronctl_version,omitempty"`
+	BuiltAt        time.Time         `json:"built_at"`
+}
+
+// OK reports whether the recorded build succeeded.
+func (s State) OK() bool {
+	return s.Hash != "" && s.ExitCode == 0
+}
+
+// Duration returns the recorded build duration.
+func (s State) Duration() time.Duration {
+	return time.Duration(s.DurationMS) * time.Millisecond
+}
+
+// ReadState reads the build state at path. If it does not exist, a legacy
+// filehash file next to it is migrated on the fly.
+func ReadState(path string) (State, bool) {
 	b, err := os.ReadFile(path)
 	if err != nil {
-		return "", false
+		return readLegacyState(filepath.Join(filepath.Dir(path), legacyStateFileName))
+	}
+	var st State
+	if err := json.Unmarshal(b, &st); err != nil {
+		return State{}, false
+	}
+	if st.Version < 1 || st.Version > StateVersion {
+		return State{}, false
+	}
+	return st, true
+}
+
+func readLegacyState(path string) (State, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return State{}, false
 	}
 	s := strings.TrimSpace(string(b))
 	if s == "" {
+		return State{}, false
+	}
+	var builtAt time.Time
+	if info, err := os.Stat(path); err == nil {
+		builtAt = info.ModTime().UTC()
+	}
+	return State{Version: StateVersion, Hash: s, SourceHash: "", Files: nil, DurationMS: 0, ExitCode: 0, CronctlVersion: "", BuiltAt: builtAt}, true
+}
+
+// ReadHash returns the inputs hash of the last successful build.
+func ReadHash(path string) (string, bool) {
+	st, ok := ReadState(path)
+	if !ok || !st.OK() {
 		return "", false
 	}
-	return s, true
+	return st.Hash, true
 }
 
-func WriteHash(path string, hash string) error {
-	if strings.TrimSpace(hash) == "" {
+// WriteState atomically writes st to path and removes a legacy filehash file
+// next to it, if any.
+func WriteState(path string, st State) error {
+	if strings.TrimSpace(st.Hash) == "" {
 		return errEmptyHash
 	}
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return fmt.Errorf("mkdir state dir: %w", err)
 	}
+	st.Version = StateVersion
 
-	data := []byte(hash + "\n")
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+	data = append(data, '\n')
 	tmp := path + ".tmp"
 	// #nosec G306 -- this is non-secret cache metadata.
 	if err := os.WriteFile(tmp, data, 0o644); err != nil {
@@ -37,9 +112,48 @@ func WriteHash(path string, hash string) error {
 		_ = os.Remove(tmp)
 		return fmt.Errorf("rename state: %w", err)
 	}
+	_ = os.Remove(filepath.Join(filepath.Dir(path), legacyStateFileName))
 	return nil
 }
 
+// StateDir returns the directory holding cronctl metadata for a job dir.
+func StateDir(jobDir string) string {
+	return filepath.Join(jobDir, ".cronctl")
+}
+
 func StateFilePath(jobDir string) string {
-	return filepath.Join(jobDir, ".cronctl", "filehash")
+	return filepath.Join(StateDir(jobDir), stateFileName)
+}
+
+// Change describes how an input file differs from the cached build.
+type Change struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+}
+
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// Diff compares per-file digests of a cached build with the current inputs.
+func Diff(prev, cur map[string]string) []Change {
+	var out []Change
+	for p, d := range cur {
+		old, ok := prev[p]
+		switch {
+		case !ok:
+			out = append(out, Change{Path: p, Kind: ChangeAdded})
+		case old != d:
+			out = append(out, Change{Path: p, Kind: ChangeModified})
+		}
+	}
+	for p := range prev {
+		if _, ok := cur[p]; !ok {
+			out = append(out, Change{Path: p, Kind: ChangeRemoved})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
 }
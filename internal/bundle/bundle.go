@@ -0,0 +1,401 @@
+// Package bundle packs built job payloads and rendered cron files into a
+// single archive that can be installed without the jobs repository.
+//
+// Layout:
+//
+//	bundle.json             manifest (see Manifest)
+//	bundle.json.sig         optional signature (see Sign)
+//	payloads/<id>/...       payloads as written by build.Export
+//	cron/cronctl-<id>       rendered cron files
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yegor-usoltsev/cronctl/internal/archive"
+	"github.com/yegor-usoltsev/cronctl/internal/build"
+	"github.com/yegor-usoltsev/cronctl/internal/gitrepo"
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/manifest"
+	"github.com/yegor-usoltsev/cronctl/internal/signing"
+	"github.com/yegor-usoltsev/cronctl/internal/syncer"
+	"github.com/yegor-usoltsev/cronctl/internal/version"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the current bundle format version.
+const Version = 1
+
+const (
+	manifestName = "bundle.json"
+	payloadsDir  = "payloads"
+	cronDir      = "cron"
+	// maxManifest caps what is read of the manifest and its signature.
+	maxManifest = 64 << 20
+)
+
+var (
+	errUnsupportedVersion = errors.New("unsupported bundle version")
+	errNoTargetDir        = errors.New("bundle has no target dir")
+	errBadTargetDir       = errors.New("target dir must be a clean absolute path other than /")
+	errInvalidJobID       = errors.New("invalid job id in bundle")
+	errDigestMismatch     = errors.New("digest mismatch")
+	errNoManifest         = errors.New("bundle has no " + manifestName + " at the start")
+	errLateManifest       = errors.New("bundle manifest must come first, once")
+	errManifestSize       = errors.New("bundle manifest too large")
+)
+
+// Manifest describes the contents of a bundle.
+type Manifest struct {
+	Version        int    `json:"version"`
+	CronctlVersion string `json:"cronctl_version,omitempty"`
+	// SourceCommit is the git commit of the jobs repository, if known.
+	SourceCommit string `json:"source_commit,omitempty"`
+	SourceDirty  bool   `json:"source_dirty,omitempty"`
+	// TargetDir is the payload directory the cron files were rendered for.
+	TargetDir string    `json:"target_dir"`
+	CreatedAt time.Time `json:"created_at"`
+	Jobs      []Job     `json:"jobs"`
+}
+
+// Job is a bundled job. Disabled jobs are listed without a payload so that
+// installing the bundle removes their cron files.
+type Job struct {
+	ID      string `json:"id"`
+	Enabled bool   `json:"enabled"`
+	// InputHash is the inputs hash of the sources the payload was built from.
+	InputHash string `json:"input_hash,omitempty"`
+	// ManifestSHA256 is the digest of the payload manifest, which in turn
+	// holds the digests of the payload files.
+	ManifestSHA256 string `json:"manifest_sha256,omitempty"`
+	// Spec is the job.yaml the bundle was created from.
+	Spec string `json:"spec"`
+	// CronFile is the archive path of the rendered cron file, if the job has
+	// a schedule.
+	CronFile   string `json:"cron_file,omitempty"`
+	CronSHA256 string `json:"cron_sha256,omitempty"`
+}
+
+// Options configures Create.
+type Options struct {
+	// TargetDir is the payload directory on the host the cron files are
+	// rendered for.
+	TargetDir string
+	// RepoDir is used to record the source commit.
+	RepoDir string
+}
+
+// Create writes a bundle of the (already built) jobs to file. The
+// compression is chosen from the file extension (see CompressionFor).
+func Create(ctx context.Context, file string, jobs []job.Job, opts Options) error {
+	if err := CheckTargetDir(opts.TargetDir); err != nil {
+		return err
+	}
+	work, err := os.MkdirTemp("", "cronctl-bundle-")
+	if err != nil {
+		return fmt.Errorf("mkdir temp: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(work) }()
+
+	m := Manifest{
+		Version:        Version,
+		CronctlVersion: version.Version,
+		SourceCommit:   "",
+		SourceDirty:    false,
+		TargetDir:      opts.TargetDir,
+		CreatedAt:      time.Now().UTC(),
+		Jobs:           make([]Job, 0, len(jobs)),
+	}
+	m.SourceCommit, m.SourceDirty = sourceCommit(ctx, opts.RepoDir)
+
+	payloads := filepath.Join(work, payloadsDir)
+	for _, j := range jobs {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("bundle: %w", err)
+		}
+		bj := Job{ID: j.ID, Enabled: j.Spec.Enabled, InputHash: "", ManifestSHA256: "", Spec: string(j.RawYAML), CronFile: "", CronSHA256: ""}
+		if j.Spec.Enabled {
+			deploy, err := syncer.DescribeDeploy(j, opts.TargetDir)
+			if err != nil {
+				return fmt.Errorf("job %s: %w", j.ID, err)
+			}
+			if err := build.Export(ctx, j, payloads, deploy); err != nil {
+				return fmt.Errorf("export: %w", err)
+			}
+			mp := manifest.Path(filepath.Join(payloads, j.ID))
+			pm, err := manifest.Read(mp)
+			if err != nil {
+				return fmt.Errorf("job %s: %w", j.ID, err)
+			}
+			bj.InputHash = pm.InputHash
+			if bj.ManifestSHA256, err = fileSHA256(mp); err != nil {
+				return fmt.Errorf("job %s: %w", j.ID, err)
+			}
+			if len(j.Spec.Schedule) > 0 {
+				data, err := syncer.RenderCron(j, filepath.Join(opts.TargetDir, j.ID), m.SourceCommit)
+				if err != nil {
+					return fmt.Errorf("job %s: render cron: %w", j.ID, err)
+				}
+				bj.CronFile = path.Join(cronDir, "cronctl-"+j.ID)
+				bj.CronSHA256 = sha256Hex(data)
+				if err := writeWorkFile(work, bj.CronFile, data); err != nil {
+					return err
+				}
+			}
+		}
+		m.Jobs = append(m.Jobs, bj)
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	if err := writeWorkFile(work, manifestName, append(b, '\n')); err != nil {
+		return err
+	}
+	return pack(ctx, file, work)
+}
+
+// Sign adds a signature of the bundle manifest to the bundle file.
+func Sign(ctx context.Context, file string, key ed25519.PrivateKey) error {
+	work, err := os.MkdirTemp("", "cronctl-bundle-")
+	if err != nil {
+		return fmt.Errorf("mkdir temp: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(work) }()
+	if _, err := Open(ctx, file, work, nil); err != nil {
+		return err
+	}
+	if err := signing.SignFile(filepath.Join(work, manifestName), key); err != nil {
+		return err
+	}
+	return pack(ctx, file, work)
+}
+
+// pack writes the contents of work to file as a bundle archive.
+func pack(ctx context.Context, file, work string) error {
+	return writeFile(ctx, file, func(tw *tar.Writer) error {
+		return archive.AddDir(tw, work, "", nil)
+	})
+}
+
+func writeWorkFile(work, rel string, data []byte) error {
+	p := filepath.Join(work, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(p), err)
+	}
+	// #nosec G306 -- bundle contents are world-readable like deployed files.
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", p, err)
+	}
+	return nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func fileSHA256(p string) (string, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", p, err)
+	}
+	return sha256Hex(b), nil
+}
+
+// Open extracts the bundle file into dir and returns its manifest. The
+// manifest and its signature come first in the archive; with keys, the
+// manifest must be signed by one of them, and that is checked before
+// anything else is extracted. The digests in the manifest then pin the
+// payloads and cron files (see LoadJobs).
+func Open(ctx context.Context, file, dir string, keys []ed25519.PublicKey) (Manifest, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return Manifest{}, fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("open %s: %w", dir, err)
+	}
+	defer func() { _ = root.Close() }()
+	var m Manifest
+	err = readFile(ctx, file, func(r io.Reader) error {
+		tr := tar.NewReader(r)
+		hdr, data, sig, err := readManifest(tr)
+		if err != nil {
+			return err
+		}
+		if m, err = checkManifest(data, sig, keys); err != nil {
+			return err
+		}
+		if err := root.WriteFile(manifestName, data, 0o644); err != nil {
+			return fmt.Errorf("write bundle manifest: %w", err)
+		}
+		if sig != nil {
+			if err := root.WriteFile(signing.SigPath(manifestName), sig, 0o644); err != nil {
+				return fmt.Errorf("write bundle signature: %w", err)
+			}
+		}
+		for hdr != nil {
+			if name := path.Clean(hdr.Name); name == manifestName || name == signing.SigPath(manifestName) {
+				return fmt.Errorf("%w: %s", errLateManifest, hdr.Name)
+			}
+			if err := archive.ExtractEntry(root, hdr, tr); err != nil {
+				return fmt.Errorf("extract bundle: %w", err)
+			}
+			hdr, err = tr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("read bundle: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	return m, nil
+}
+
+// readManifest reads the leading manifest and signature entries of tr. It
+// returns the first other entry, if there is one.
+func readManifest(tr *tar.Reader) (*tar.Header, []byte, []byte, error) {
+	var data, sig []byte
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, data, sig, nil
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("read bundle: %w", err)
+		}
+		var dst *[]byte
+		switch path.Clean(hdr.Name) {
+		case manifestName:
+			dst = &data
+		case signing.SigPath(manifestName):
+			dst = &sig
+		default:
+			return hdr, data, sig, nil
+		}
+		if hdr.Typeflag != tar.TypeReg || *dst != nil {
+			return nil, nil, nil, fmt.Errorf("%w: %s", errLateManifest, hdr.Name)
+		}
+		b, err := io.ReadAll(io.LimitReader(tr, maxManifest+1))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("read %s: %w", hdr.Name, err)
+		}
+		if len(b) > maxManifest {
+			return nil, nil, nil, fmt.Errorf("%w: %s", errManifestSize, hdr.Name)
+		}
+		*dst = b
+	}
+}
+
+// checkManifest verifies the signature sig of the manifest data with keys,
+// if any, and parses it.
+func checkManifest(data, sig []byte, keys []ed25519.PublicKey) (Manifest, error) {
+	if data == nil {
+		return Manifest{}, errNoManifest
+	}
+	if len(keys) > 0 {
+		if sig == nil {
+			return Manifest{}, fmt.Errorf("bundle: %w: %s", signing.ErrNoSignature, signing.SigPath(manifestName))
+		}
+		if err := signing.Verify(manifestName, data, sig, keys); err != nil {
+			return Manifest{}, fmt.Errorf("bundle: %w", err)
+		}
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("parse bundle manifest: %w", err)
+	}
+	if m.Version < 1 || m.Version > Version {
+		return Manifest{}, fmt.Errorf("%w: %d", errUnsupportedVersion, m.Version)
+	}
+	if strings.TrimSpace(m.TargetDir) == "" {
+		return Manifest{}, errNoTargetDir
+	}
+	if err := CheckTargetDir(m.TargetDir); err != nil {
+		return Manifest{}, err
+	}
+	return m, nil
+}
+
+// CheckTargetDir returns an error unless dir can be the payload directory of
+// a bundle: install replaces and removes directories under it as root.
+func CheckTargetDir(dir string) error {
+	if !filepath.IsAbs(dir) || filepath.Clean(dir) != dir || dir == "/" {
+		return fmt.Errorf("%w: %q", errBadTargetDir, dir)
+	}
+	return nil
+}
+
+// LoadJobs returns the jobs of the bundle extracted to dir and what
+// syncer.Sync needs to deploy them. Payload manifests and cron files are
+// checked against the digests in m.
+func (m Manifest) LoadJobs(dir string) ([]job.Job, *syncer.Bundle, error) {
+	sb := &syncer.Bundle{PayloadDir: filepath.Join(dir, payloadsDir), CronFiles: make(map[string][]byte)}
+	jobs := make([]job.Job, 0, len(m.Jobs))
+	for _, bj := range m.Jobs {
+		if bj.ID == "" || bj.ID != filepath.Base(bj.ID) || strings.HasPrefix(bj.ID, ".") {
+			return nil, nil, fmt.Errorf("%w: %q", errInvalidJobID, bj.ID)
+		}
+		raw := []byte(bj.Spec)
+		j := job.New(bj.ID, filepath.Join(sb.PayloadDir, bj.ID), filepath.Join(sb.PayloadDir, bj.ID, "job.yaml"), raw)
+		if err := yaml.Unmarshal(raw, &j.Spec); err != nil {
+			return nil, nil, fmt.Errorf("job %s: parse spec: %w", bj.ID, err)
+		}
+		if bj.Enabled {
+			sum, err := fileSHA256(manifest.Path(j.Dir))
+			if err != nil {
+				return nil, nil, fmt.Errorf("job %s: %w", bj.ID, err)
+			}
+			if sum != bj.ManifestSHA256 {
+				return nil, nil, fmt.Errorf("job %s: %w: payload manifest", bj.ID, errDigestMismatch)
+			}
+		}
+		if bj.CronFile != "" {
+			data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(bj.CronFile)))
+			if err != nil {
+				return nil, nil, fmt.Errorf("job %s: read cron file: %w", bj.ID, err)
+			}
+			if sha256Hex(data) != bj.CronSHA256 {
+				return nil, nil, fmt.Errorf("job %s: %w: %s", bj.ID, errDigestMismatch, bj.CronFile)
+			}
+			sb.CronFiles[bj.ID] = data
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, sb, nil
+}
+
+// sourceCommit returns the HEAD commit of the git repository containing dir
+// and whether dir has uncommitted changes.
+func sourceCommit(ctx context.Context, dir string) (string, bool) {
+	if dir == "" {
+		return "", false
+	}
+	head, err := gitrepo.Head(ctx, dir)
+	if err != nil {
+		return "", false
+	}
+	changes, err := gitrepo.Changes(ctx, dir)
+	return head, err == nil && len(changes) > 0
+}
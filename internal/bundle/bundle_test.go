@@ -0,0 +1,244 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yegor-usoltsev/cronctl/internal/archive"
+	"github.com/yegor-usoltsev/cronctl/internal/build"
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/signing"
+	"github.com/yegor-usoltsev/cronctl/internal/syncer"
+)
+
+func writeJob(t *testing.T, jobsDir, id string, enabled bool) {
+	t.Helper()
+	dir := filepath.Join(jobsDir, id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	spec := "$schema: https://cronctl.usoltsev.xyz/v0.json\nname: " + id + "\nenabled: " + strconv.FormatBool(enabled) +
+		"\nuser: root\ntags: []\nbuild:\n  enabled: true\nrun:\n  entrypoint: run.sh\nschedule:\n  - cron: \"0 * * * *\"\n"
+	files := map[string]string{
+		"job.yaml": spec,
+		"run.sh":   "#!/bin/sh\nexec ./app\n",
+		"build.sh": "#!/bin/sh\necho built > app\n",
+	}
+	for rel, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, rel), []byte(content), 0o755); err != nil {
+			t.Fatalf("write %s: %v", rel, err)
+		}
+	}
+}
+
+func createBundle(t *testing.T, file string) {
+	t.Helper()
+	ctx := context.Background()
+	jobsDir := filepath.Join(t.TempDir(), "jobs")
+	writeJob(t, jobsDir, "backup", true)
+	writeJob(t, jobsDir, "retired", false)
+	jobs, err := job.Discover(ctx, jobsDir)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	if err := build.All(ctx, jobsDir, jobs, build.Options{Parallel: 1}); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if err := Create(ctx, file, jobs, Options{TargetDir: "/opt/cronctl/jobs", RepoDir: jobsDir}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+}
+
+func TestCreateOpen_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	exts := []string{".tar", ".tar.gz"}
+	if _, err := exec.LookPath("zstd"); err == nil {
+		exts = append(exts, ".tar.zst")
+	}
+	for _, ext := range exts {
+		file := filepath.Join(t.TempDir(), "jobs"+ext)
+		createBundle(t, file)
+
+		dir := t.TempDir()
+		m, err := Open(context.Background(), file, dir, nil)
+		if err != nil {
+			t.Fatalf("%s: Open: %v", ext, err)
+		}
+		if m.TargetDir != "/opt/cronctl/jobs" || len(m.Jobs) != 2 {
+			t.Fatalf("%s: unexpected manifest: %+v", ext, m)
+		}
+		jobs, sb, err := m.LoadJobs(dir)
+		if err != nil {
+			t.Fatalf("%s: LoadJobs: %v", ext, err)
+		}
+		if len(jobs) != 2 || !jobs[0].Spec.Enabled || jobs[1].Spec.Enabled {
+			t.Fatalf("%s: unexpected jobs: %+v", ext, jobs)
+		}
+		cron := string(sb.CronFiles["backup"])
+		if !strings.Contains(cron, "'/opt/cronctl/jobs/backup/run.sh'") {
+			t.Fatalf("%s: unexpected cron file:\n%s", ext, cron)
+		}
+		if _, ok := sb.CronFiles["retired"]; ok {
+			t.Fatalf("%s: disabled job should have no cron file", ext)
+		}
+		b, err := os.ReadFile(filepath.Join(sb.PayloadDir, "backup", "app"))
+		if err != nil || string(b) != "built\n" {
+			t.Fatalf("%s: expected built payload, got %q (%v)", ext, b, err)
+		}
+	}
+}
+
+func TestInstall(t *testing.T) {
+	t.Parallel()
+	if os.Geteuid() != 0 {
+		t.Skip("skipping test that requires root")
+	}
+
+	root := t.TempDir()
+	file := filepath.Join(root, "jobs.tar.gz")
+	createBundle(t, file)
+
+	dir := t.TempDir()
+	m, err := Open(context.Background(), file, dir, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	jobs, sb, err := m.LoadJobs(dir)
+	if err != nil {
+		t.Fatalf("LoadJobs: %v", err)
+	}
+	cronDir := filepath.Join(root, "cron.d")
+	targetDir := filepath.Join(root, "deployed")
+	if err := os.MkdirAll(cronDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for _, name := range []string{"cronctl-retired", "cronctl-orphan"} {
+		if err := os.WriteFile(filepath.Join(cronDir, name), []byte("# old\n"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	opts := syncer.Options{CronDir: cronDir, TargetDir: targetDir, RemoveOrphans: true, Chown: true, Bundle: sb}
+	if err := syncer.Sync(context.Background(), jobs, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(cronDir, "cronctl-backup"))
+	if err != nil || string(got) != string(sb.CronFiles["backup"]) {
+		t.Fatalf("expected bundled cron file, got %q (%v)", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "backup", "app")); err != nil {
+		t.Fatalf("expected payload to be deployed: %v", err)
+	}
+	for _, name := range []string{"cronctl-retired", "cronctl-orphan"} {
+		if _, err := os.Stat(filepath.Join(cronDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, stat err = %v", name, err)
+		}
+	}
+
+	// A tampered payload must be rejected before anything changes.
+	if err := os.WriteFile(filepath.Join(sb.PayloadDir, "backup", "app"), []byte("evil\n"), 0o755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := syncer.Sync(context.Background(), jobs, opts); err == nil || !strings.Contains(err.Error(), "modified app") {
+		t.Fatalf("expected manifest mismatch, got %v", err)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "jobs.tar.gz")
+	createBundle(t, file)
+
+	dir := t.TempDir()
+	if _, err := Open(context.Background(), file, dir, []ed25519.PublicKey{pub}); !errors.Is(err, signing.ErrNoSignature) {
+		t.Fatalf("expected ErrNoSignature for unsigned bundle, got %v", err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("unsigned bundle extracted %d entries (%v), want none", len(entries), err)
+	}
+
+	if err := Sign(context.Background(), file, priv); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if _, err := Open(context.Background(), file, t.TempDir(), []ed25519.PublicKey{other}); !errors.Is(err, signing.ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature for another key, got %v", err)
+	}
+	dir = t.TempDir()
+	m, err := Open(context.Background(), file, dir, []ed25519.PublicKey{pub})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// The signed manifest pins the cron files and payload manifests.
+	if err := os.WriteFile(filepath.Join(dir, "cron", "cronctl-backup"), []byte("* * * * * root /bin/evil\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, _, err := m.LoadJobs(dir); !errors.Is(err, errDigestMismatch) {
+		t.Fatalf("expected digest mismatch for tampered cron file, got %v", err)
+	}
+}
+
+func TestOpenRejectsLateManifest(t *testing.T) {
+	t.Parallel()
+	file := filepath.Join(t.TempDir(), "jobs.tar")
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := archive.AddFile(tw, "payloads/backup/run.sh", 0o755, []byte("#!/bin/sh\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.AddFile(tw, manifestName, 0o644, []byte(`{"version":1,"target_dir":"/opt/cronctl/jobs"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if _, err := Open(context.Background(), file, dir, nil); !errors.Is(err, errNoManifest) {
+		t.Fatalf("Open = %v, want %v", err, errNoManifest)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("extracted %d entries (%v), want none", len(entries), err)
+	}
+}
+
+func TestCheckTargetDir(t *testing.T) {
+	t.Parallel()
+
+	for dir, ok := range map[string]bool{
+		"/opt/cronctl/jobs":         true,
+		"/":                         false,
+		"":                          false,
+		"opt/cronctl/jobs":          false,
+		"/opt/cronctl/jobs/":        false,
+		"/opt/cronctl/../../etc":    false,
+		"/opt//cronctl/jobs":        false,
+		"/opt/cronctl/./jobs/../..": false,
+	} {
+		if err := CheckTargetDir(dir); (err == nil) != ok {
+			t.Fatalf("CheckTargetDir(%q) = %v, want ok=%v", dir, err, ok)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Compression is the compression of a bundle file.
+type Compression string
+
+const (
+	None Compression = "none"
+	Gzip Compression = "gzip"
+	// Zstd uses the external zstd binary.
+	Zstd Compression = "zstd"
+)
+
+var (
+	errNoZstd = errors.New("zstd not found in PATH (needed for .zst bundles)")
+
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// CompressionFor returns the compression implied by the extension of path:
+// .zst/.tzst for zstd, .gz/.tgz for gzip and none otherwise.
+func CompressionFor(path string) Compression {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zst", ".tzst":
+		return Zstd
+	case ".gz", ".tgz":
+		return Gzip
+	default:
+		return None
+	}
+}
+
+// writeFile writes a tar archive filled by fill to path, atomically.
+func writeFile(ctx context.Context, path string, fill func(tw *tar.Writer) error) error {
+	comp := CompressionFor(path)
+	if comp == Zstd {
+		if _, err := exec.LookPath("zstd"); err != nil {
+			return errNoZstd
+		}
+	}
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, ".cronctl-bundle-")
+	if err != nil {
+		return fmt.Errorf("create temp in %s: %w", dir, err)
+	}
+	tmpName := f.Name()
+	defer func() { _ = os.Remove(tmpName) }()
+	defer func() { _ = f.Close() }()
+
+	if err := writeCompressed(ctx, f, comp, fill); err != nil {
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		return fmt.Errorf("chmod %s: %w", tmpName, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", tmpName, err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("rename %s -> %s: %w", tmpName, path, err)
+	}
+	return nil
+}
+
+func writeCompressed(ctx context.Context, w io.Writer, comp Compression, fill func(tw *tar.Writer) error) error {
+	writeTar := func(w io.Writer) error {
+		tw := tar.NewWriter(w)
+		if err := fill(tw); err != nil {
+			return err
+		}
+		if err := tw.Close(); err != nil {
+			return fmt.Errorf("close tar: %w", err)
+		}
+		return nil
+	}
+
+	switch comp {
+	case Gzip:
+		zw := gzip.NewWriter(w)
+		if err := writeTar(zw); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("close gzip: %w", err)
+		}
+		return nil
+	case Zstd:
+		cmd := exec.CommandContext(ctx, "zstd", "-q", "-c", "-")
+		cmd.Stdout = w
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		in, err := cmd.StdinPipe()
+		if err != nil {
+			return fmt.Errorf("zstd: %w", err)
+		}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("start zstd: %w", err)
+		}
+		werr := writeTar(in)
+		_ = in.Close()
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("zstd: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return werr
+	default:
+		return writeTar(w)
+	}
+}
+
+// readFile passes the tar stream of the bundle at path to read, detecting
+// the compression from its contents.
+func readFile(ctx context.Context, path string, read func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open bundle: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("open gzip: %w", err)
+		}
+		defer func() { _ = zr.Close() }()
+		return read(zr)
+	case bytes.HasPrefix(head, zstdMagic):
+		if _, err := exec.LookPath("zstd"); err != nil {
+			return errNoZstd
+		}
+		cmd := exec.CommandContext(ctx, "zstd", "-q", "-d", "-c", "-")
+		cmd.Stdin = br
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return fmt.Errorf("zstd: %w", err)
+		}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("start zstd: %w", err)
+		}
+		xerr := read(out)
+		// Drain so zstd can exit even if reading stopped early.
+		_, _ = io.Copy(io.Discard, out)
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("zstd: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return xerr
+	default:
+		return read(br)
+	}
+}
@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/yegor-usoltsev/cronctl/internal/agent"
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/selector"
+	"github.com/yegor-usoltsev/cronctl/internal/syncer"
+)
+
+var errAgentNeedsRoot = errors.New("agent must be run as root")
+var errLastSyncFailed = errors.New("last sync failed")
+var errListenNeedsSecret = errors.New("--listen requires --webhook-secret-file")
+
+type agentCmd struct {
+	Run    agentRunCmd    `cmd:"" default:"withargs" help:"Run the agent (default)."`
+	Status agentStatusCmd `cmd:"" help:"Print the status of the running agent."`
+	Unit   agentUnitCmd   `cmd:"" passthrough:"all" help:"Print a systemd unit running the agent with the given flags."`
+}
+
+type agentRunCmd struct {
+	Repo    string `name:"repo" required:"" help:"Git URL or path of the jobs repository."`
+	Ref     string `name:"ref" required:"" help:"Branch, tag or commit SHA to deploy."`
+	RepoDir string `name:"repo-dir" default:"/var/lib/cronctl/repo" help:"Managed clone of --repo."`
+	JobsDir string `name:"jobs-dir" default:"jobs" help:"Jobs directory, relative to the repository root."`
+	Env     string `name:"env" help:"Apply the job.<env>.yaml overlays and environments.<env> blocks of this environment."`
+
+	Tags                   []string          `name:"tags" sep:"," help:"Include jobs that have ANY of these tags."`
+	SkipTags               []string          `name:"skip-tags" sep:"," help:"Exclude jobs that have ANY of these tags."`
+	Select                 selector.Selector `name:"select" help:"Include only jobs matching this expression, e.g. 'prod AND NOT legacy' (see README)."`
+	Inventory              string            `name:"inventory" help:"Select jobs for this host from an inventory file, relative to the repository root."`
+	Host                   string            `name:"host" help:"Hostname to look up in --inventory (default: this host's name)."`
+	CronDir                string            `name:"cron-dir" default:"/etc/cron.d" help:"Cron directory to write cronctl-* files."`
+	TargetDir              string            `name:"target-dir" default:"/opt/cronctl/jobs" help:"Target directory for deployed job payloads."`
+	RemoveOrphans          bool              `name:"remove-orphans" help:"Remove cronctl-managed cron files, and their decrypted secrets, not present in selection."`
+	RemovePayloadOnDisable bool              `name:"remove-payload-on-disable" help:"Remove payload dir when a job is disabled."`
+	AllowInsecureSecrets   bool              `name:"allow-insecure-secrets" help:"Warn instead of failing when a secret env file is missing or readable by others."`
+	AgeIdentity            string            `name:"age-identity" default:"/etc/cronctl/age.key" help:"age identity to decrypt secrets.encrypted files with."`
+	Verbose                bool              `name:"verbose" short:"v" help:"Stream build output live, prefixed with [job-id]."`
+
+	ArtifactCache        string `name:"artifact-cache" help:"Shared artifact cache dir; restores build outputs by input hash instead of building."`
+	ArtifactCacheMaxSize string `name:"artifact-cache-max-size" help:"Evict least recently used artifacts above this size (e.g. 10G)."`
+
+	RequireSignedCommit bool   `name:"require-signed-commit" help:"Only deploy commits signed by an allowed signer."`
+	AllowedSigners      string `name:"allowed-signers" default:"/etc/cronctl/allowed_signers" help:"SSH allowed signers file or armored GPG public keys for --require-signed-commit."`
+
+	Interval      time.Duration `name:"interval" default:"1m" help:"Time between fetches of --repo."`
+	DriftInterval time.Duration `name:"drift-interval" default:"5m" help:"Time between checks for hand-edited cron files."`
+	RetryMin      time.Duration `name:"retry-min" default:"10s" help:"First retry delay after a failed sync."`
+	RetryMax      time.Duration `name:"retry-max" default:"10m" help:"Maximum retry delay after failed syncs."`
+	LockFile      string        `name:"lock-file" default:"/run/cronctl/agent.lock" help:"Single-instance lock file."`
+	StatusFile    string        `name:"status-file" default:"/var/lib/cronctl/agent-status.json" help:"File the agent writes its last result to."`
+
+	Listen            string `name:"listen" help:"Serve a push webhook on this address (e.g. :9797)."`
+	WebhookSecretFile string `name:"webhook-secret-file" type:"existingfile" help:"File with the shared webhook secret (required with --listen)."`
+}
+
+func (c *agentRunCmd) Run(ctx context.Context, files *configFiles) error {
+	if os.Geteuid() != 0 {
+		return errAgentNeedsRoot
+	}
+	if !filepath.IsLocal(c.JobsDir) {
+		return fmt.Errorf("%w: %s", errJobsDirNotInRepo, c.JobsDir)
+	}
+	repo := c.Repo
+	if _, err := os.Stat(repo); err == nil {
+		if repo, err = filepath.Abs(repo); err != nil {
+			return fmt.Errorf("abs %s: %w", c.Repo, err)
+		}
+	}
+	store, err := artifactStore(c.ArtifactCache, c.ArtifactCacheMaxSize)
+	if err != nil {
+		return err
+	}
+	cfg := agent.Config{
+		Repo:          repo,
+		Ref:           c.Ref,
+		RepoDir:       c.RepoDir,
+		JobsDir:       c.JobsDir,
+		Interval:      c.Interval,
+		DriftInterval: c.DriftInterval,
+		RetryMin:      c.RetryMin,
+		RetryMax:      c.RetryMax,
+		LockFile:      c.LockFile,
+		StatusFile:    c.StatusFile,
+		Sync: syncer.Options{
+			CronDir:                c.CronDir,
+			TargetDir:              c.TargetDir,
+			RemoveOrphans:          c.RemoveOrphans,
+			RemovePayloadOnDisable: c.RemovePayloadOnDisable,
+			Chown:                  true,
+			RunBuildAsJobUser:      true,
+			Artifacts:              store,
+			Verbose:                c.Verbose,
+			AllowInsecureSecrets:   c.AllowInsecureSecrets,
+			AgeIdentity:            c.AgeIdentity,
+		},
+	}
+	if c.RequireSignedCommit {
+		cfg.AllowedSigners = c.AllowedSigners
+	}
+	if c.Listen != "" {
+		if c.WebhookSecretFile == "" {
+			return errListenNeedsSecret
+		}
+		secret, err := os.ReadFile(c.WebhookSecretFile)
+		if err != nil {
+			return fmt.Errorf("read webhook secret: %w", err)
+		}
+		cfg.Listen, cfg.WebhookSecret = c.Listen, bytes.TrimSpace(secret)
+	}
+	if c.Host != "" && c.Inventory == "" {
+		return errHostNeedsInventory
+	}
+	if c.Inventory != "" && !filepath.IsLocal(c.Inventory) {
+		return fmt.Errorf("%w: %s", errInventoryNotInRepo, c.Inventory)
+	}
+	cfg.Discover = func(ctx context.Context, jobsDir string) ([]job.Job, error) {
+		jobOpts, err := files.jobOptions(c.RepoDir, c.Env)
+		if err != nil {
+			return nil, err
+		}
+		if c.Inventory != "" {
+			h, err := resolveHost(filepath.Join(c.RepoDir, c.Inventory), c.Host)
+			if err != nil {
+				return nil, err
+			}
+			jobOpts = withInventoryHost(jobOpts, h)
+		}
+		return job.DiscoverWith(ctx, jobsDir, jobOpts)
+	}
+	cfg.Select = func(jobs []job.Job) ([]job.Job, error) {
+		jobs = filterParsedJobs(jobs, c.Tags, c.SkipTags, c.Select)
+		if c.Inventory == "" {
+			return jobs, nil
+		}
+		// The inventory is read from each checkout, like the jobs.
+		h, err := resolveHost(filepath.Join(c.RepoDir, c.Inventory), c.Host)
+		if err != nil {
+			return nil, err
+		}
+		return selectHostJobs(jobs, h), nil
+	}
+	if err := agent.Run(ctx, cfg); err != nil {
+		return fmt.Errorf("agent: %w", err)
+	}
+	return nil
+}
+
+type agentStatusCmd struct {
+	StatusFile string `name:"status-file" default:"/var/lib/cronctl/agent-status.json" help:"Status file written by the agent."`
+	JSON       bool   `name:"json" help:"Print JSON instead of a summary."`
+}
+
+// Run prints the agent status and fails if the last sync failed, so it can
+// be used as a health check.
+func (c *agentStatusCmd) Run() error {
+	s, err := agent.ReadStatus(c.StatusFile)
+	if err != nil {
+		return err
+	}
+	if c.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(s); err != nil {
+			return fmt.Errorf("encode status: %w", err)
+		}
+	} else {
+		printAgentStatus(os.Stdout, s)
+	}
+	if s.LastSync != nil && !s.LastSync.OK {
+		return errLastSyncFailed
+	}
+	return nil
+}
+
+func printAgentStatus(w io.Writer, s agent.Status) {
+	_, _ = fmt.Fprintf(w, "repo:      %s %s\n", s.Repo, s.Ref)
+	_, _ = fmt.Fprintf(w, "pid:       %d (since %s)\n", s.PID, s.StartedAt.Format(time.RFC3339))
+	_, _ = fmt.Fprintf(w, "deployed:  %s\n", orDash(s.Commit))
+	if r := s.LastSync; r != nil {
+		result := "ok"
+		switch {
+		case !r.OK:
+			result = "failed: " + r.Error
+		case r.Skipped:
+			result = "ok (up to date)"
+		}
+		_, _ = fmt.Fprintf(w, "last sync: %s %s\n", r.Time.Format(time.RFC3339), result)
+	}
+	if s.Failures > 0 {
+		_, _ = fmt.Fprintf(w, "failures:  %d in a row\n", s.Failures)
+	}
+	if !s.NextSync.IsZero() {
+		_, _ = fmt.Fprintf(w, "next sync: %s\n", s.NextSync.Format(time.RFC3339))
+	}
+	if d := s.LastDrift; d != nil {
+		result := fmt.Sprintf("%d repaired", len(d.Repaired))
+		if d.Error != "" {
+			result = "failed: " + d.Error
+		}
+		_, _ = fmt.Fprintf(w, "drift:     %s %s\n", d.Time.Format(time.RFC3339), result)
+	}
+}
+
+type agentUnitCmd struct {
+	Args []string `arg:"" optional:"" help:"Agent flags, e.g. --repo <url> --ref main."`
+}
+
+func (c *agentUnitCmd) Run() error {
+	// Parse the flags like the agent would, so typos fail here rather than in
+	// the service.
+	var flags agentRunCmd
+	parser, err := kong.New(&flags, kong.Name("cronctl agent"), kong.Exit(func(int) {}))
+	if err != nil {
+		return fmt.Errorf("init flags: %w", err)
+	}
+	if _, err := parser.Parse(c.Args); err != nil {
+		return fmt.Errorf("agent flags: %w", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("executable: %w", err)
+	}
+	unit, err := agent.Unit(exe, append([]string{"agent"}, c.Args...))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stdout.Write(unit); err != nil {
+		return fmt.Errorf("write unit: %w", err)
+	}
+	return nil
+}
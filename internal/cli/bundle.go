@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"strings"
+
+	"github.com/yegor-usoltsev/cronctl/internal/bundle"
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/selector"
+	"github.com/yegor-usoltsev/cronctl/internal/syncer"
+)
+
+type bundleCmd struct {
+	JobsDir   string            `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
+	Env       string            `name:"env" help:"Apply the job.<env>.yaml overlays and environments.<env> blocks of this environment."`
+	Tags      []string          `name:"tags" sep:"," help:"Include jobs that have ANY of these tags."`
+	SkipTags  []string          `name:"skip-tags" sep:"," help:"Exclude jobs that have ANY of these tags."`
+	Select    selector.Selector `name:"select" help:"Include only jobs matching this expression, e.g. 'prod AND NOT legacy' (see README)."`
+	Output    string            `name:"output" short:"o" required:"" help:"Bundle file to write (.tar, .tar.gz or .tar.zst)."`
+	TargetDir string            `name:"target-dir" default:"/opt/cronctl/jobs" help:"Target directory for deployed job payloads on the host."`
+	Force     bool              `name:"force" help:"Rebuild regardless of cache."`
+	Parallel  int               `name:"parallel" default:"1" help:"Max parallel builds."`
+	Verbose   bool              `name:"verbose" short:"v" help:"Stream build output live, prefixed with [job-id]."`
+	JobID     string            `arg:"" optional:"" name:"job-id" help:"Bundle only this job ID."`
+}
+
+func (c *bundleCmd) Run(ctx context.Context, files *configFiles) error {
+	jobOpts, err := files.jobOptions("", c.Env)
+	if err != nil {
+		return err
+	}
+	// The bundle is installed on other hosts: their facts are not known here.
+	maps.DeleteFunc(jobOpts.Vars, func(k, _ string) bool { return strings.HasPrefix(k, "host.") })
+	jobs, err := job.DiscoverWith(ctx, c.JobsDir, jobOpts)
+	if err != nil {
+		return fmt.Errorf("discover jobs: %w", err)
+	}
+	if c.JobID != "" {
+		jobs = onlyJob(jobs, c.JobID)
+		if len(jobs) == 0 {
+			return fmt.Errorf("%w: %s", errJobNotFound, c.JobID)
+		}
+	}
+	jobs = filterParsedJobs(jobs, c.Tags, c.SkipTags, c.Select)
+	if err := buildJobs(ctx, c.JobsDir, jobs, c.Force, c.Parallel, c.Verbose, nil); err != nil {
+		return fmt.Errorf("build: %w", err)
+	}
+	if err := bundle.Create(ctx, c.Output, jobs, bundle.Options{TargetDir: c.TargetDir, RepoDir: c.JobsDir}); err != nil {
+		return fmt.Errorf("bundle: %w", err)
+	}
+	log.Printf("bundle: wrote %d jobs to %s", len(jobs), c.Output)
+	return nil
+}
+
+type installCmd struct {
+	DryRun                 bool   `name:"dry-run" help:"Print actions without making changes."`
+	CronDir                string `name:"cron-dir" default:"/etc/cron.d" help:"Cron directory to write cronctl-* files."`
+	TargetDir              string `name:"target-dir" default:"/opt/cronctl/jobs" help:"Target directory for deployed job payloads; the bundle must have been created for it."`
+	RemoveOrphans          bool   `name:"remove-orphans" help:"Remove cronctl-managed cron files, and their decrypted secrets, not present in the bundle."`
+	RemovePayloadOnDisable bool   `name:"remove-payload-on-disable" help:"Remove payload dir when a job is disabled."`
+	AllowInsecureSecrets   bool   `name:"allow-insecure-secrets" help:"Warn instead of failing when a secret env file is missing or readable by others."`
+	AgeIdentity            string `name:"age-identity" default:"/etc/cronctl/age.key" help:"age identity to decrypt secrets.encrypted files with."`
+	VerifyKey              string `name:"verify-key" type:"existingfile" help:"Require the bundle to be signed by a key in this PEM file."`
+	Bundle                 string `arg:"" name:"bundle" type:"existingfile" help:"Bundle file written by cronctl bundle."`
+}
+
+func (c *installCmd) Run(ctx context.Context) error {
+	if os.Geteuid() != 0 {
+		return errInstallNeedsRoot
+	}
+	keys, err := verifyKeys(c.VerifyKey)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "cronctl-install-")
+	if err != nil {
+		return fmt.Errorf("mkdir temp: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// With --verify-key, nothing is extracted before the manifest, which
+	// pins every payload and cron file, is verified.
+	m, err := bundle.Open(ctx, c.Bundle, dir, keys)
+	if err != nil {
+		return fmt.Errorf("install: %w", err)
+	}
+	// The cron files are rendered for the bundle's target dir, so it must be
+	// the one this host deploys to.
+	if m.TargetDir != c.TargetDir {
+		return fmt.Errorf("install: %w: bundle has %s, --target-dir is %s", errTargetDirMismatch, m.TargetDir, c.TargetDir)
+	}
+	jobs, sb, err := m.LoadJobs(dir)
+	if err != nil {
+		return fmt.Errorf("install: %w", err)
+	}
+	log.Printf("install: %d jobs from %s (commit %s, cronctl %s)", len(jobs), c.Bundle, orDash(m.SourceCommit), orDash(m.CronctlVersion))
+	opts := syncer.Options{
+		CronDir:                c.CronDir,
+		TargetDir:              c.TargetDir,
+		DryRun:                 c.DryRun,
+		RemoveOrphans:          c.RemoveOrphans,
+		RemovePayloadOnDisable: c.RemovePayloadOnDisable,
+		Chown:                  true,
+		Bundle:                 sb,
+		AllowInsecureSecrets:   c.AllowInsecureSecrets,
+		AgeIdentity:            c.AgeIdentity,
+	}
+	if err := syncJobs(ctx, jobs, opts); err != nil {
+		return fmt.Errorf("install: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yegor-usoltsev/cronctl/internal/build"
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/selector"
+)
+
+type cacheCmd struct {
+	List  cacheListCmd  `cmd:"" help:"List build cache state per job."`
+	Clear cacheClearCmd `cmd:"" help:"Remove build cache state."`
+}
+
+type cacheListCmd struct {
+	JobsDir   string            `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
+	Env       string            `name:"env" help:"Apply the job.<env>.yaml overlays and environments.<env> blocks of this environment."`
+	Host      bool              `name:"host" help:"Inspect deployed payloads in --target-dir instead of the repo."`
+	TargetDir string            `name:"target-dir" default:"/opt/cronctl/jobs" help:"Target directory for deployed job payloads (with --host)."`
+	Tags      []string          `name:"tags" sep:"," help:"Include jobs that have ANY of these tags."`
+	SkipTags  []string          `name:"skip-tags" sep:"," help:"Exclude jobs that have ANY of these tags."`
+	Select    selector.Selector `name:"select" help:"Include only jobs matching this expression, e.g. 'prod AND NOT legacy' (see README)."`
+	JSON      bool              `name:"json" help:"Print JSON instead of a table."`
+	JobID     string            `arg:"" optional:"" name:"job-id" help:"List only this job ID."`
+}
+
+func (c *cacheListCmd) Run(ctx context.Context, files *configFiles) error {
+	jobs, err := discoverCacheJobs(ctx, files, c.Host, cacheDir(c.Host, c.JobsDir, c.TargetDir), c.Env, c.JobID, c.Tags, c.SkipTags, c.Select)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	entries := make([]build.CacheEntry, 0, len(jobs))
+	for _, j := range jobs {
+		e, err := build.Inspect(ctx, j, now)
+		if err != nil {
+			return fmt.Errorf("cache list: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if c.JSON {
+		return writeJSON(os.Stdout, entries)
+	}
+	return writeCacheTable(os.Stdout, entries)
+}
+
+type cacheClearCmd struct {
+	JobsDir   string            `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
+	Env       string            `name:"env" help:"Apply the job.<env>.yaml overlays and environments.<env> blocks of this environment."`
+	Host      bool              `name:"host" help:"Clear deployed payload caches in --target-dir instead of the repo."`
+	TargetDir string            `name:"target-dir" default:"/opt/cronctl/jobs" help:"Target directory for deployed job payloads (with --host)."`
+	Tags      []string          `name:"tags" sep:"," help:"Include jobs that have ANY of these tags."`
+	SkipTags  []string          `name:"skip-tags" sep:"," help:"Exclude jobs that have ANY of these tags."`
+	Select    selector.Selector `name:"select" help:"Include only jobs matching this expression, e.g. 'prod AND NOT legacy' (see README)."`
+	JSON      bool              `name:"json" help:"Print cleared jobs as JSON."`
+	JobID     string            `arg:"" optional:"" name:"job-id" help:"Clear only this job ID."`
+}
+
+type clearedCache struct {
+	JobID   string `json:"job_id"`
+	Dir     string `json:"dir"`
+	Removed bool   `json:"removed"`
+}
+
+func (c *cacheClearCmd) Run(ctx context.Context, files *configFiles) error {
+	jobs, err := discoverCacheJobs(ctx, files, c.Host, cacheDir(c.Host, c.JobsDir, c.TargetDir), c.Env, c.JobID, c.Tags, c.SkipTags, c.Select)
+	if err != nil {
+		return err
+	}
+	out := make([]clearedCache, 0, len(jobs))
+	for _, j := range jobs {
+		removed, err := build.ClearState(j.Dir)
+		if err != nil {
+			return fmt.Errorf("cache clear: job %s: %w", j.ID, err)
+		}
+		out = append(out, clearedCache{JobID: j.ID, Dir: j.Dir, Removed: removed})
+		if removed && !c.JSON {
+			log.Printf("cache: %s: cleared", j.ID)
+		}
+	}
+	if c.JSON {
+		return writeJSON(os.Stdout, out)
+	}
+	return nil
+}
+
+func cacheDir(host bool, jobsDir, targetDir string) string {
+	if host {
+		// Deployed payloads keep the <dir>/<id>/job.yaml layout.
+		return targetDir
+	}
+	return jobsDir
+}
+
+func discoverCacheJobs(ctx context.Context, files *configFiles, host bool, dir, env, jobID string, tags, skip []string, sel selector.Selector) ([]job.Job, error) {
+	jobOpts, err := files.jobOptions("", env)
+	if err != nil {
+		return nil, err
+	}
+	if host {
+		// Deployed specs are already interpolated.
+		jobOpts.Vars = nil
+	}
+	jobs, err := job.DiscoverWith(ctx, dir, jobOpts)
+	if err != nil {
+		return nil, fmt.Errorf("discover jobs: %w", err)
+	}
+	if jobID != "" {
+		jobs = onlyJob(jobs, jobID)
+		if len(jobs) == 0 {
+			return nil, fmt.Errorf("%w: %s", errJobNotFound, jobID)
+		}
+	}
+	return filterParsedJobs(jobs, tags, skip, sel), nil
+}
+
+func writeCacheTable(w io.Writer, entries []build.CacheEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tCACHED\tCURRENT\tSTATUS\tAGE")
+	for _, e := range entries {
+		age := "-"
+		if !e.BuiltAt.IsZero() {
+			age = (time.Duration(e.AgeSeconds) * time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.JobID, orDash(shortHash(e.CachedHash)), shortHash(e.CurrentHash), e.Status, age)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write table: %w", err)
+	}
+	return nil
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	return h
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("write json: %w", err)
+	}
+	return nil
+}
@@ -5,12 +5,26 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/yegor-usoltsev/cronctl/internal/artifact"
 	"github.com/yegor-usoltsev/cronctl/internal/build"
+	"github.com/yegor-usoltsev/cronctl/internal/config"
+	"github.com/yegor-usoltsev/cronctl/internal/gitrepo"
+	"github.com/yegor-usoltsev/cronctl/internal/inventory"
 	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/sandbox"
 	"github.com/yegor-usoltsev/cronctl/internal/scaffold"
+	"github.com/yegor-usoltsev/cronctl/internal/selector"
 	"github.com/yegor-usoltsev/cronctl/internal/syncer"
 	"github.com/yegor-usoltsev/cronctl/internal/validate"
 	"github.com/yegor-usoltsev/cronctl/internal/version"
@@ -19,11 +33,22 @@ import (
 )
 
 type root struct {
-	Init     initCmd     `cmd:"" help:"Create a new job scaffold."`
-	Validate validateCmd `cmd:"" help:"Validate job specs."`
-	Build    buildCmd    `cmd:"" help:"Run job build steps with caching."`
-	Sync     syncCmd     `cmd:"" help:"Deploy jobs and manage /etc/cron.d entries."`
-	Version  versionCmd  `cmd:"" help:"Print cronctl version."`
+	Init      initCmd      `cmd:"" help:"Create a new job scaffold."`
+	Validate  validateCmd  `cmd:"" help:"Validate job specs."`
+	Show      showCmd      `cmd:"" help:"Print a job spec."`
+	Build     buildCmd     `cmd:"" help:"Run job build steps with caching."`
+	Sync      syncCmd      `cmd:"" help:"Deploy jobs and manage /etc/cron.d entries."`
+	Cache     cacheCmd     `cmd:"" help:"Inspect and clear build caches."`
+	Bundle    bundleCmd    `cmd:"" help:"Build jobs and pack them into a self-contained deploy bundle."`
+	Install   installCmd   `cmd:"" help:"Deploy a bundle written by cronctl bundle."`
+	Exec      execCmd      `cmd:"" help:"Run a deployed job with its secret env files (used in cron files)."`
+	Secrets   secretsCmd   `cmd:"" help:"Edit the age-encrypted secrets of jobs."`
+	Sign      signCmd      `cmd:"" help:"Sign a bundle or prebuilt payload manifests with an ed25519 key."`
+	Agent     agentCmd     `cmd:"" help:"Continuously fetch a jobs repository and keep the host in sync."`
+	Serve     serveCmd     `cmd:"" help:"Serve a read-only dashboard and JSON API of the deployed jobs."`
+	Inventory inventoryCmd `cmd:"" help:"Inspect the host inventory."`
+	Config    configCmd    `cmd:"" help:"Inspect the cronctl.yaml configuration."`
+	Version   versionCmd   `cmd:"" help:"Print cronctl version."`
 }
 
 type versionCmd struct{}
@@ -47,59 +72,234 @@ func (c *initCmd) Run(ctx context.Context) error {
 }
 
 type validateCmd struct {
-	JobsDir  string   `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
-	Tags     []string `name:"tags" sep:"," help:"Include jobs that have ANY of these tags."`
-	SkipTags []string `name:"skip-tags" sep:"," help:"Exclude jobs that have ANY of these tags."`
-	JobID    string   `arg:"" optional:"" name:"job-id" help:"Validate only this job ID."`
+	JobsDir   string            `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
+	Env       string            `name:"env" help:"Validate only this environment (default: the base specs and every environment overlay)."`
+	Inventory string            `name:"inventory" help:"Take ${vars.*} from the inventory entry of --host (without it, the vars the inventory sets are placeholders)."`
+	Host      string            `name:"host" help:"Hostname to look up in --inventory (default: this host's name with --on-host)."`
+	Arch      string            `name:"arch" help:"Architecture (GOARCH) ELF entrypoints must be built for (default: this machine's with --on-host, else unchecked)."`
+	OnHost    bool              `name:"on-host" help:"Also check that #! interpreters exist on this machine; use on the hosts jobs run on."`
+	Tags      []string          `name:"tags" sep:"," help:"Include jobs that have ANY of these tags."`
+	SkipTags  []string          `name:"skip-tags" sep:"," help:"Exclude jobs that have ANY of these tags."`
+	Select    selector.Selector `name:"select" help:"Include only jobs matching this expression, e.g. 'prod AND NOT legacy' (see README)."`
+	JobID     string            `arg:"" optional:"" name:"job-id" help:"Validate only this job ID."`
 }
 
-func (c *validateCmd) Run(ctx context.Context) error {
-	jobs, err := job.DiscoverRaw(ctx, c.JobsDir)
+func (c *validateCmd) Run(ctx context.Context, files *configFiles) error {
+	cfg, err := files.effective("")
 	if err != nil {
-		return fmt.Errorf("discover jobs: %w", err)
+		return err
+	}
+	jobs, err := c.discover(ctx, files, c.Env)
+	if err != nil {
+		return err
+	}
+	// Without --env, check the base specs and every environment overlay.
+	envJobs := make(map[string][]job.Job)
+	if c.Env == "" {
+		for _, env := range jobEnvs(jobs) {
+			if envJobs[env], err = c.discover(ctx, files, env); err != nil {
+				return err
+			}
+			envJobs[env] = slices.DeleteFunc(envJobs[env], func(j job.Job) bool { return !slices.Contains(j.Envs, env) })
+		}
+	}
+	// Where the jobs run is only known from --arch, or from --on-host.
+	arch := c.Arch
+	if arch == "" && c.OnHost {
+		arch = runtime.GOARCH
+	}
+	if err := validate.Envs(ctx, jobs, envJobs, validate.Options{AllowedTags: cfg.AllowedTags, Arch: arch, OnHost: c.OnHost}); err != nil {
+		return fmt.Errorf("validate: %w", err)
+	}
+	return nil
+}
+
+func (c *validateCmd) discover(ctx context.Context, files *configFiles, env string) ([]job.Job, error) {
+	jobOpts, err := files.jobOptions("", env)
+	if err != nil {
+		return nil, err
+	}
+	if jobOpts, err = c.hostOptions(jobOpts); err != nil {
+		return nil, err
+	}
+	jobs, err := job.DiscoverRawWith(ctx, c.JobsDir, jobOpts)
+	if err != nil {
+		return nil, fmt.Errorf("discover jobs: %w", err)
 	}
 	if c.JobID != "" {
 		jobs = onlyJob(jobs, c.JobID)
 		if len(jobs) == 0 {
-			return fmt.Errorf("%w: %s", errJobNotFound, c.JobID)
+			return nil, fmt.Errorf("%w: %s", errJobNotFound, c.JobID)
 		}
 	}
-	if len(c.Tags) > 0 || len(c.SkipTags) > 0 {
-		jobs = filterJobsByTags(jobs, c.Tags, c.SkipTags)
+	return filterJobs(jobs, c.Tags, c.SkipTags, c.Select), nil
+}
+
+// hostOptions adds the variables of the host jobs are validated for to opts.
+// Without --host or --on-host that is no one host, so ${vars.*} an inventory
+// may set (any, without --inventory) are placeholders, and so are the
+// ${host.*} facts of a host other than this one.
+func (c *validateCmd) hostOptions(opts job.Options) (job.Options, error) {
+	oneHost := c.Host != "" || c.OnHost
+	facts := []string{"host.name", "host.short", "host.os", "host.arch", "host.cpus"}
+	var invVars []string
+	if oneHost {
+		var err error
+		if opts, err = inventoryOptions(opts, c.Inventory, c.Host); err != nil {
+			return opts, err
+		}
+	} else if c.Inventory != "" {
+		inv, err := inventory.Load(c.Inventory)
+		if err != nil {
+			return opts, err
+		}
+		invVars = inv.VarNames()
 	}
-	if err := validate.All(ctx, jobs); err != nil {
-		return fmt.Errorf("validate: %w", err)
+	if !c.OnHost {
+		opts.Vars = maps.Clone(opts.Vars)
+		for _, name := range facts {
+			// --host names the host, but the other facts are this one's.
+			if c.Host == "" || (name != "host.name" && name != "host.short") {
+				delete(opts.Vars, name)
+			}
+		}
 	}
-	return nil
+	opts.Placeholder = func(name string) bool {
+		if slices.Contains(facts, name) {
+			return !c.OnHost
+		}
+		v, ok := strings.CutPrefix(name, "vars.")
+		return ok && !oneHost && (c.Inventory == "" || slices.Contains(invVars, v))
+	}
+	return opts, nil
+}
+
+// jobEnvs returns the environments any of jobs has overlays for.
+func jobEnvs(jobs []job.Job) []string {
+	var envs []string
+	for _, j := range jobs {
+		envs = append(envs, j.Envs...)
+	}
+	slices.Sort(envs)
+	return slices.Compact(envs)
 }
 
 type buildCmd struct {
-	JobsDir  string   `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
-	Tags     []string `name:"tags" sep:"," help:"Include jobs that have ANY of these tags."`
-	SkipTags []string `name:"skip-tags" sep:"," help:"Exclude jobs that have ANY of these tags."`
-	Force    bool     `name:"force" help:"Rebuild regardless of cache."`
-	Parallel int      `name:"parallel" default:"1" help:"Max parallel builds."`
-	JobID    string   `arg:"" optional:"" name:"job-id" help:"Build only this job ID."`
+	JobsDir  string            `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
+	Env      string            `name:"env" help:"Apply the job.<env>.yaml overlays and environments.<env> blocks of this environment."`
+	Tags     []string          `name:"tags" sep:"," help:"Include jobs that have ANY of these tags."`
+	SkipTags []string          `name:"skip-tags" sep:"," help:"Exclude jobs that have ANY of these tags."`
+	Select   selector.Selector `name:"select" help:"Include only jobs matching this expression, e.g. 'prod AND NOT legacy' (see README)."`
+	Force    bool              `name:"force" help:"Rebuild regardless of cache."`
+	Parallel int               `name:"parallel" default:"1" help:"Max parallel builds."`
+	Why      bool              `name:"why" help:"Show input files changed since the cached build of job-id instead of building."`
+	Verbose  bool              `name:"verbose" short:"v" help:"Stream build output live, prefixed with [job-id]."`
+
+	ArtifactCache        string `name:"artifact-cache" help:"Shared artifact cache dir; restores build outputs by input hash instead of building."`
+	ArtifactCacheMaxSize string `name:"artifact-cache-max-size" help:"Evict least recently used artifacts above this size (e.g. 10G)."`
+	OutputDir            string `name:"output-dir" help:"Write ready-to-deploy payloads with manifests to <dir>/<job-id> (see sync --from-artifacts)."`
+	TargetDir            string `name:"target-dir" default:"/opt/cronctl/jobs" help:"Target directory on the hosts, recorded in --output-dir manifests with the cron file rendered for it."`
+
+	JobID string `arg:"" optional:"" name:"job-id" help:"Build only this job ID."`
 }
 
 type syncCmd struct {
-	JobsDir                string   `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
-	Tags                   []string `name:"tags" sep:"," help:"Include jobs that have ANY of these tags."`
-	SkipTags               []string `name:"skip-tags" sep:"," help:"Exclude jobs that have ANY of these tags."`
-	DryRun                 bool     `name:"dry-run" help:"Print actions without making changes."`
-	CronDir                string   `name:"cron-dir" default:"/etc/cron.d" help:"Cron directory to write cronctl-* files."`
-	TargetDir              string   `name:"target-dir" default:"/opt/cronctl/jobs" help:"Target directory for deployed job payloads."`
-	RemoveOrphans          bool     `name:"remove-orphans" help:"Remove cronctl-managed cron files not present in selection."`
-	RemovePayloadOnDisable bool     `name:"remove-payload-on-disable" help:"Remove payload dir when a job is disabled."`
-	ForceBuild             bool     `name:"force-build" help:"Force rebuild regardless of cache."`
-	JobID                  string   `arg:"" optional:"" name:"job-id" help:"Sync only this job ID."`
+	JobsDir                string            `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
+	Env                    string            `name:"env" help:"Apply the job.<env>.yaml overlays and environments.<env> blocks of this environment."`
+	Tags                   []string          `name:"tags" sep:"," help:"Include jobs that have ANY of these tags."`
+	SkipTags               []string          `name:"skip-tags" sep:"," help:"Exclude jobs that have ANY of these tags."`
+	Select                 selector.Selector `name:"select" help:"Include only jobs matching this expression, e.g. 'prod AND NOT legacy' (see README)."`
+	DryRun                 bool              `name:"dry-run" help:"Print actions without making changes."`
+	CronDir                string            `name:"cron-dir" default:"/etc/cron.d" help:"Cron directory to write cronctl-* files."`
+	TargetDir              string            `name:"target-dir" default:"/opt/cronctl/jobs" help:"Target directory for deployed job payloads."`
+	RemoveOrphans          bool              `name:"remove-orphans" help:"Remove cronctl-managed cron files, and their decrypted secrets, not present in selection."`
+	RemovePayloadOnDisable bool              `name:"remove-payload-on-disable" help:"Remove payload dir when a job is disabled."`
+	AllowInsecureSecrets   bool              `name:"allow-insecure-secrets" help:"Warn instead of failing when a secret env file is missing or readable by others."`
+	AgeIdentity            string            `name:"age-identity" default:"/etc/cronctl/age.key" help:"age identity to decrypt secrets.encrypted files with."`
+	ForceBuild             bool              `name:"force-build" help:"Force rebuild regardless of cache."`
+	Verbose                bool              `name:"verbose" short:"v" help:"Stream build output live, prefixed with [job-id]."`
+
+	ArtifactCache        string `name:"artifact-cache" help:"Shared artifact cache dir; restores build outputs by input hash instead of building."`
+	ArtifactCacheMaxSize string `name:"artifact-cache-max-size" help:"Evict least recently used artifacts above this size (e.g. 10G)."`
+	FromArtifacts        string `name:"from-artifacts" help:"Deploy prebuilt payloads from this dir (see build --output-dir) instead of building."`
+	VerifyKey            string `name:"verify-key" type:"existingfile" help:"Require --from-artifacts or --signed-manifests manifests to be signed by a key in this PEM file."`
+	SignedManifests      string `name:"signed-manifests" help:"Build from the repo only if every job matches its signed manifest in this dir (see build --output-dir); requires --verify-key."`
+
+	RequireSignedCommit bool   `name:"require-signed-commit" help:"Require HEAD of the jobs repository to be signed by an allowed signer and the job dirs to be clean."`
+	AllowedSigners      string `name:"allowed-signers" default:"/etc/cronctl/allowed_signers" help:"SSH allowed signers file or armored GPG public keys for --require-signed-commit."`
+	AllowDirty          bool   `name:"allow-dirty" help:"With --require-signed-commit, sync even if the job dirs have uncommitted or untracked changes."`
+
+	Inventory string `name:"inventory" help:"Select jobs for this host from an inventory file (relative to the repository root with --repo)."`
+	Host      string `name:"host" help:"Hostname to look up in --inventory (default: this host's name)."`
+
+	Repo    string `name:"repo" help:"Git URL or path to sync from; --jobs-dir is then relative to the repository root."`
+	Ref     string `name:"ref" help:"Branch, tag or commit SHA to check out from --repo."`
+	RepoDir string `name:"repo-dir" default:"/var/lib/cronctl/repo" help:"Managed clone of --repo."`
+
+	JobID string `arg:"" optional:"" name:"job-id" help:"Sync only this job ID."`
 }
 
-func (c *syncCmd) Run(ctx context.Context) error {
+func (c *syncCmd) Run(ctx context.Context, files *configFiles) error {
 	if os.Geteuid() != 0 {
 		return errSyncNeedsRoot
 	}
-	jobs, err := job.Discover(ctx, c.JobsDir)
+	if c.FromArtifacts != "" && (c.ForceBuild || c.ArtifactCache != "") {
+		return errFromArtifactsNoBuild
+	}
+	if c.FromArtifacts != "" && c.SignedManifests != "" {
+		return errSignedManifestsWithArtifacts
+	}
+	if c.VerifyKey != "" && c.FromArtifacts == "" && c.SignedManifests == "" {
+		return errVerifyKeyNeedsManifests
+	}
+	if c.SignedManifests != "" && c.VerifyKey == "" {
+		return errSignedManifestsNeedKey
+	}
+	if c.AllowDirty && !c.RequireSignedCommit {
+		return errAllowDirtyNeedsSigned
+	}
+	jobsDir, commit, err := c.checkout(ctx)
+	if err != nil {
+		return err
+	}
+	// The checkout is verified before its job specs are read. The
+	// cronctl.yaml and inventory that select and shape them must be
+	// committed to it as well; outside --repo, the cronctl.yaml of the
+	// working directory has been read already, so that is checked too.
+	if c.RequireSignedCommit {
+		head, err := checkSignedCommit(ctx, jobsDir, c.verifiedFiles(files), c.AllowedSigners, c.AllowDirty)
+		if err != nil {
+			return err
+		}
+		commit = head
+	}
+	repoDir := ""
+	if c.Repo != "" {
+		repoDir = c.RepoDir
+	}
+	jobOpts, err := files.jobOptions(repoDir, c.Env)
+	if err != nil {
+		return err
+	}
+	if c.Host != "" && c.Inventory == "" {
+		return errHostNeedsInventory
+	}
+	var host *inventory.Host
+	if c.Inventory != "" {
+		file := c.Inventory
+		if c.Repo != "" {
+			if !filepath.IsLocal(file) {
+				return fmt.Errorf("%w: %s", errInventoryNotInRepo, file)
+			}
+			file = filepath.Join(c.RepoDir, file)
+		}
+		h, err := resolveHost(file, c.Host)
+		if err != nil {
+			return err
+		}
+		jobOpts, host = withInventoryHost(jobOpts, h), &h
+	}
+	jobs, err := job.DiscoverWith(ctx, jobsDir, jobOpts)
 	if err != nil {
 		return fmt.Errorf("discover jobs: %w", err)
 	}
@@ -109,17 +309,107 @@ func (c *syncCmd) Run(ctx context.Context) error {
 			return fmt.Errorf("%w: %s", errJobNotFound, c.JobID)
 		}
 	}
-	if len(c.Tags) > 0 || len(c.SkipTags) > 0 {
-		jobs = filterParsedJobsByTags(jobs, c.Tags, c.SkipTags)
+	jobs = filterParsedJobs(jobs, c.Tags, c.SkipTags, c.Select)
+	if host != nil {
+		jobs = selectHostJobs(jobs, *host)
+	}
+	keys, err := verifyKeys(c.VerifyKey)
+	if err != nil {
+		return err
 	}
-	if err := syncJobs(ctx, jobs, c.DryRun, c.CronDir, c.TargetDir, c.RemoveOrphans, c.RemovePayloadOnDisable, c.ForceBuild); err != nil {
+	store, err := artifactStore(c.ArtifactCache, c.ArtifactCacheMaxSize)
+	if err != nil {
+		return err
+	}
+	opts := c.options(store)
+	opts.VerifyKeys = keys
+	opts.SourceCommit = commit
+	if err := syncJobs(ctx, jobs, opts); err != nil {
 		return fmt.Errorf("sync: %w", err)
 	}
 	return nil
 }
 
-func (c *buildCmd) Run(ctx context.Context) error {
-	jobs, err := job.Discover(ctx, c.JobsDir)
+// checkout returns the jobs dir to sync from. With --repo, the ref is checked
+// out into the managed clone first and its commit returned.
+func (c *syncCmd) checkout(ctx context.Context) (string, string, error) {
+	if c.Repo == "" {
+		if c.Ref != "" {
+			return "", "", errRefNeedsRepo
+		}
+		return c.JobsDir, "", nil
+	}
+	if c.Ref == "" {
+		return "", "", errRepoNeedsRef
+	}
+	if !filepath.IsLocal(c.JobsDir) {
+		return "", "", fmt.Errorf("%w: %s", errJobsDirNotInRepo, c.JobsDir)
+	}
+	url := c.Repo
+	if _, err := os.Stat(url); err == nil {
+		if url, err = filepath.Abs(url); err != nil {
+			return "", "", fmt.Errorf("abs %s: %w", c.Repo, err)
+		}
+	}
+	commit, err := gitrepo.Checkout(ctx, url, c.Ref, c.RepoDir)
+	if err != nil {
+		return "", "", fmt.Errorf("checkout %s %s: %w", c.Repo, c.Ref, err)
+	}
+	log.Printf("sync: %s %s at commit %s", c.Repo, c.Ref, commit)
+	return filepath.Join(c.RepoDir, c.JobsDir), commit, nil
+}
+
+// verifiedFiles returns the repo config and inventory files that sync reads
+// and that --require-signed-commit must therefore cover.
+func (c *syncCmd) verifiedFiles(files *configFiles) []string {
+	var paths []string
+	repoFile, inv := files.repoFile, c.Inventory
+	if c.Repo != "" {
+		repoFile = filepath.Join(c.RepoDir, config.RepoFile)
+		if _, err := os.Stat(repoFile); err != nil {
+			repoFile = ""
+		}
+		if inv != "" {
+			inv = filepath.Join(c.RepoDir, inv)
+		}
+	}
+	for _, p := range []string{repoFile, inv} {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// checkSignedCommit refuses to sync from a checkout whose HEAD is not signed by
+// an allowed signer or whose job dirs, or any of files, differ from HEAD. It
+// returns HEAD.
+func checkSignedCommit(ctx context.Context, jobsDir string, files []string, allowedSigners string, allowDirty bool) (string, error) {
+	if !allowDirty {
+		if err := gitrepo.CheckClean(ctx, jobsDir); err != nil {
+			return "", fmt.Errorf("%w (use --allow-dirty to sync anyway)", err)
+		}
+		if err := gitrepo.CheckFilesClean(ctx, jobsDir, files...); err != nil {
+			return "", fmt.Errorf("%w (use --allow-dirty to sync anyway)", err)
+		}
+	}
+	if err := gitrepo.VerifyHead(ctx, jobsDir, allowedSigners); err != nil {
+		return "", err
+	}
+	head, err := gitrepo.Head(ctx, jobsDir)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("sync: commit %s: signature verified", head)
+	return head, nil
+}
+
+func (c *buildCmd) Run(ctx context.Context, files *configFiles) error {
+	jobOpts, err := files.jobOptions("", c.Env)
+	if err != nil {
+		return err
+	}
+	jobs, err := job.DiscoverWith(ctx, c.JobsDir, jobOpts)
 	if err != nil {
 		return fmt.Errorf("discover jobs: %w", err)
 	}
@@ -129,12 +419,32 @@ func (c *buildCmd) Run(ctx context.Context) error {
 			return fmt.Errorf("%w: %s", errJobNotFound, c.JobID)
 		}
 	}
-	if len(c.Tags) > 0 || len(c.SkipTags) > 0 {
-		jobs = filterParsedJobsByTags(jobs, c.Tags, c.SkipTags)
+	if c.Why {
+		if c.JobID == "" {
+			return errWhyNeedsJob
+		}
+		return explainBuild(ctx, jobs[0])
+	}
+	jobs = filterParsedJobs(jobs, c.Tags, c.SkipTags, c.Select)
+	store, err := artifactStore(c.ArtifactCache, c.ArtifactCacheMaxSize)
+	if err != nil {
+		return err
 	}
-	if err := buildJobs(ctx, c.JobsDir, jobs, c.Force, c.Parallel); err != nil {
+	if err := buildJobs(ctx, c.JobsDir, jobs, c.Force, c.Parallel, c.Verbose, store); err != nil {
 		return fmt.Errorf("build: %w", err)
 	}
+	if c.OutputDir != "" {
+		for _, j := range jobs {
+			deploy, err := syncer.DescribeDeploy(j, c.TargetDir)
+			if err != nil {
+				return fmt.Errorf("export: job %s: %w", j.ID, err)
+			}
+			if err := build.Export(ctx, j, c.OutputDir, deploy); err != nil {
+				return fmt.Errorf("export: %w", err)
+			}
+		}
+		log.Printf("build: payloads written to %s", c.OutputDir)
+	}
 	return nil
 }
 
@@ -142,6 +452,19 @@ func Run(args []string) int {
 	if len(args) == 0 {
 		args = []string{"--help"}
 	}
+	if args[0] == sandbox.InitArg {
+		return sandbox.Main(args[1:])
+	}
+
+	// Cancel on Ctrl-C / SIGTERM so running builds get killed, not orphaned.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	files, err := loadConfigFiles(config.RepoFile, config.HostFile)
+	if err != nil {
+		log.Printf("load config: %v", err)
+		return 1
+	}
 
 	var cli root
 	k, err := kong.New(
@@ -149,7 +472,9 @@ func Run(args []string) int {
 		kong.Name("cronctl"),
 		kong.Description("Manage Linux cron jobs from a git repository."),
 		kong.UsageOnError(),
-		kong.BindTo(context.Background(), (*context.Context)(nil)),
+		kong.BindTo(ctx, (*context.Context)(nil)),
+		kong.Bind(files),
+		kong.Resolvers(files),
 		kong.Writers(os.Stdout, os.Stderr),
 	)
 	if err != nil {
@@ -166,7 +491,7 @@ func Run(args []string) int {
 		var verrs validate.Errors
 		if errors.As(err, &verrs) {
 			for _, e := range verrs {
-				log.Printf("validate: %s (%s): %s", e.Path, e.JobID, e.Msg)
+				log.Printf("validate: %s", e)
 			}
 			return 1
 		}
@@ -179,6 +504,19 @@ func Run(args []string) int {
 
 var errJobNotFound = errors.New("job not found")
 var errSyncNeedsRoot = errors.New("sync must be run as root (try: sudo cronctl sync ...)")
+var errInstallNeedsRoot = errors.New("install must be run as root (try: sudo cronctl install ...)")
+var errTargetDirMismatch = errors.New("bundle target dir differs from --target-dir")
+var errWhyNeedsJob = errors.New("--why requires a job ID")
+var errFromArtifactsNoBuild = errors.New("--from-artifacts cannot be combined with --force-build or --artifact-cache")
+var errVerifyKeyNeedsManifests = errors.New("--verify-key requires --from-artifacts or --signed-manifests")
+var errSignedManifestsNeedKey = errors.New("--signed-manifests requires --verify-key")
+var errSignedManifestsWithArtifacts = errors.New("--signed-manifests cannot be combined with --from-artifacts, whose manifests are checked instead")
+var errNothingToSign = errors.New("no payload manifests found")
+var errAllowDirtyNeedsSigned = errors.New("--allow-dirty requires --require-signed-commit")
+var errRepoNeedsRef = errors.New("--repo requires --ref")
+var errRefNeedsRepo = errors.New("--ref requires --repo")
+var errJobsDirNotInRepo = errors.New("--jobs-dir must be a relative path inside --repo")
+var errInventoryNotInRepo = errors.New("--inventory must be a relative path inside --repo")
 
 func parseExitCode(err error) int {
 	var ec interface{ ExitCode() int }
@@ -204,7 +542,8 @@ func onlyJob(jobs []job.Job, id string) []job.Job {
 	return nil
 }
 
-func filterJobsByTags(jobs []job.Job, tags, skip []string) []job.Job {
+// filterJobs keeps the jobs selected by --tags, --skip-tags and --select.
+func filterJobs(jobs []job.Job, tags, skip []string, sel selector.Selector) []job.Job {
 	out := make([]job.Job, 0, len(jobs))
 	for _, j := range jobs {
 		spec, err := tryParseSpecForTags(j.RawYAML)
@@ -213,7 +552,7 @@ func filterJobsByTags(jobs []job.Job, tags, skip []string) []job.Job {
 			out = append(out, j)
 			continue
 		}
-		if matchTags(spec.Tags, tags, skip) {
+		if matchTags(spec.Tags, tags, skip) && sel.Match(j.ID, spec) {
 			out = append(out, j)
 		}
 	}
@@ -228,10 +567,10 @@ func tryParseSpecForTags(raw []byte) (job.Spec, error) {
 	return spec, nil
 }
 
-func filterParsedJobsByTags(jobs []job.Job, tags, skip []string) []job.Job {
+func filterParsedJobs(jobs []job.Job, tags, skip []string, sel selector.Selector) []job.Job {
 	out := make([]job.Job, 0, len(jobs))
 	for _, j := range jobs {
-		if matchTags(j.Spec.Tags, tags, skip) {
+		if matchTags(j.Spec.Tags, tags, skip) && sel.Match(j.ID, j.Spec) {
 			out = append(out, j)
 		}
 	}
@@ -264,26 +603,81 @@ func hasAny(haystack, needles []string) bool {
 	return false
 }
 
-func buildJobs(ctx context.Context, jobsDir string, jobs []job.Job, force bool, parallel int) error {
-	if err := build.All(ctx, jobsDir, jobs, build.Options{Force: force, Parallel: parallel}); err != nil {
+func artifactStore(dir, maxSize string) (*artifact.Store, error) {
+	if dir == "" {
+		return nil, nil //nolint:nilnil
+	}
+	maxBytes, err := artifact.ParseSize(maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("--artifact-cache-max-size: %w", err)
+	}
+	return &artifact.Store{Dir: dir, MaxBytes: maxBytes}, nil
+}
+
+func buildJobs(ctx context.Context, jobsDir string, jobs []job.Job, force bool, parallel int, verbose bool, store *artifact.Store) error {
+	if err := build.All(ctx, jobsDir, jobs, build.Options{Force: force, Parallel: parallel, Artifacts: store, Verbose: verbose}); err != nil {
 		return fmt.Errorf("build jobs: %w", err)
 	}
 	return nil
 }
 
-func syncJobs(ctx context.Context, jobs []job.Job, dryRun bool, cronDir, targetDir string, removeOrphans, removePayloadOnDisable, forceBuild bool) error {
-	opts := syncer.Options{
-		CronDir:                cronDir,
-		TargetDir:              targetDir,
-		DryRun:                 dryRun,
-		RemoveOrphans:          removeOrphans,
-		RemovePayloadOnDisable: removePayloadOnDisable,
-		ForceBuild:             forceBuild,
+func (c *syncCmd) options(store *artifact.Store) syncer.Options {
+	return syncer.Options{
+		CronDir:                c.CronDir,
+		TargetDir:              c.TargetDir,
+		DryRun:                 c.DryRun,
+		RemoveOrphans:          c.RemoveOrphans,
+		RemovePayloadOnDisable: c.RemovePayloadOnDisable,
+		ForceBuild:             c.ForceBuild,
 		Chown:                  true,
 		RunBuildAsJobUser:      true,
+		Artifacts:              store,
+		Verbose:                c.Verbose,
+		FromArtifacts:          c.FromArtifacts,
+		SignedManifests:        c.SignedManifests,
+		AllowInsecureSecrets:   c.AllowInsecureSecrets,
+		AgeIdentity:            c.AgeIdentity,
 	}
+}
+
+func syncJobs(ctx context.Context, jobs []job.Job, opts syncer.Options) error {
 	if err := syncer.Sync(ctx, jobs, opts); err != nil {
 		return fmt.Errorf("sync jobs: %w", err)
 	}
 	return nil
 }
+
+func explainBuild(ctx context.Context, j job.Job) error {
+	res, err := build.Why(ctx, j)
+	if err != nil {
+		return fmt.Errorf("why: %w", err)
+	}
+	w := os.Stdout
+	if !res.Cached {
+		fmt.Fprintf(w, "%s: no cached build\n", j.ID)
+	} else {
+		st := res.State
+		fmt.Fprintf(w, "%s: last built %s by cronctl %s in %s (exit %d)\n",
+			j.ID, st.BuiltAt.Local().Format(time.RFC3339), orDash(st.CronctlVersion), st.Duration(), st.ExitCode)
+	}
+	switch {
+	case !res.Stale():
+		fmt.Fprintf(w, "%s: up to date (hash %s)\n", j.ID, res.CurrentHash)
+	case res.Legacy:
+		fmt.Fprintf(w, "%s: inputs changed; cached state has no per-file digests (legacy filehash)\n", j.ID)
+	case res.Cached && res.CachedHash == res.CurrentHash:
+		fmt.Fprintf(w, "%s: inputs unchanged, but the last build failed\n", j.ID)
+	default:
+		for _, ch := range res.Changes {
+			fmt.Fprintf(w, "%-8s %s\n", ch.Kind, ch.Path)
+		}
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
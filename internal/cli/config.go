@@ -0,0 +1,373 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alecthomas/kong"
+	"github.com/yegor-usoltsev/cronctl/internal/config"
+	"github.com/yegor-usoltsev/cronctl/internal/inventory"
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+)
+
+var (
+	errUnknownConfigFlag    = errors.New("unknown flag")
+	errUnknownConfigCommand = errors.New("unknown command")
+	errConfigValue          = errors.New("flag values must be scalars or lists of scalars")
+	errHostOnlyFlag         = errors.New("can only be set in the host config, a CRONCTL_* variable or on the command line")
+	errHostOnlySetting      = errors.New("can only be set in the host config")
+)
+
+// hostOnly reports whether flag decides what a host trusts, where it gets jobs
+// from or where it deploys them. Whoever can push to the jobs repo controls
+// its cronctl.yaml, so only the host config, the environment and the command
+// line may set these flags.
+func hostOnly(flag string) bool {
+	switch flag {
+	case "age-identity", "allow-dirty", "allow-insecure-secrets", "allowed-signers",
+		"artifact-cache", "cron-dir", "from-artifacts", "listen", "lock-file",
+		"ref", "repo", "repo-dir", "require-signed-commit", "signed-manifests",
+		"status-file", "target-dir", "token-file", "verify-key", "webhook-secret-file":
+		return true
+	}
+	return false
+}
+
+// configFiles are the repo and host configs. Flag defaults come from the repo
+// config, then the host config, then CRONCTL_* environment variables, and
+// flags on the command line win over all of them. The repo config sets no
+// hostOnly flags, and none at all when syncing from --repo.
+type configFiles struct {
+	// repoFile and hostFile are empty if the file does not exist.
+	repoFile, hostFile string
+	repo, host         *config.Config
+}
+
+func loadConfigFiles(repoFile, hostFile string) (*configFiles, error) {
+	f := &configFiles{repoFile: "", hostFile: "", repo: nil, host: nil}
+	var err error
+	if f.repo, err = config.Load(repoFile); err != nil {
+		return nil, err
+	}
+	if f.host, err = config.Load(hostFile); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(repoFile); err == nil {
+		f.repoFile = repoFile
+	}
+	if _, err := os.Stat(hostFile); err == nil {
+		f.hostFile = hostFile
+	}
+	return f, nil
+}
+
+// effective returns the repo config, read from repoDir instead of the working
+// directory if set, overridden by the host config.
+func (f *configFiles) effective(repoDir string) (*config.Config, error) {
+	repo, _, err := f.repoConfig(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	return config.Merge(repo, f.host), nil
+}
+
+// repoConfig returns the repo config of repoDir (see effective) and its file.
+func (f *configFiles) repoConfig(repoDir string) (*config.Config, string, error) {
+	if repoDir == "" {
+		return f.repo, f.repoFile, nil
+	}
+	file := filepath.Join(repoDir, config.RepoFile)
+	repo, err := config.Load(file)
+	if err != nil {
+		return nil, "", err
+	}
+	return repo, file, nil
+}
+
+// jobOptions returns the job discovery options of the repo at repoDir (see
+// effective) for environment env.
+func (f *configFiles) jobOptions(repoDir, env string) (job.Options, error) {
+	repo, repoFile, err := f.repoConfig(repoDir)
+	if err != nil {
+		return job.Options{}, err
+	}
+	var from []string
+	if !repo.Job.IsZero() {
+		from = append(from, repoFile)
+	}
+	if !f.host.Job.IsZero() {
+		from = append(from, f.hostFile)
+	}
+	cfg := config.Merge(repo, f.host)
+	vars, err := hostFacts()
+	if err != nil {
+		return job.Options{}, err
+	}
+	for k, v := range cfg.Vars {
+		vars["vars."+k] = v
+	}
+	// A repo config cannot widen what specs read from the environment of
+	// root, whatever its source.
+	for _, name := range f.host.AllowedEnv {
+		if v, ok := os.LookupEnv(name); ok {
+			vars["env."+name] = v
+		}
+	}
+	return job.Options{Defaults: cfg.Job, DefaultsSource: strings.Join(from, ", "), Env: env, Vars: vars, Placeholder: nil}, nil
+}
+
+// hostFacts returns the ${host.*} variables of this host.
+func hostFacts() (map[string]string, error) {
+	name, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("hostname: %w", err)
+	}
+	vars := map[string]string{
+		"host.os":   runtime.GOOS,
+		"host.arch": runtime.GOARCH,
+		"host.cpus": strconv.Itoa(runtime.NumCPU()),
+	}
+	setHostName(vars, name)
+	return vars, nil
+}
+
+func setHostName(vars map[string]string, name string) {
+	short, _, _ := strings.Cut(name, ".")
+	vars["host.name"], vars["host.short"] = name, short
+}
+
+// withInventoryHost adds the inventory vars of h to opts, over the config
+// ones, and makes h the host name.
+func withInventoryHost(opts job.Options, h inventory.Host) job.Options {
+	vars := maps.Clone(opts.Vars)
+	for k, v := range h.Vars {
+		vars["vars."+k] = v
+	}
+	setHostName(vars, h.Name)
+	opts.Vars = vars
+	return opts
+}
+
+func envName(flag string) string {
+	return "CRONCTL_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// lookup returns the configured default of flag name of the command at path
+// and where it comes from. The repo config is only consulted if useRepo is
+// set.
+func (f *configFiles) lookup(path, name string, useRepo bool) (any, string, bool) {
+	if v, ok := os.LookupEnv(envName(name)); ok {
+		return v, "env " + envName(name), true
+	}
+	if v, ok := f.host.Flag(path, name); ok {
+		return v, f.hostFile, true
+	}
+	if !useRepo || hostOnly(name) {
+		return nil, "", false
+	}
+	if v, ok := f.repo.Flag(path, name); ok {
+		return v, f.repoFile, true
+	}
+	return nil, "", false
+}
+
+// Resolve implements kong.Resolver.
+func (f *configFiles) Resolve(kctx *kong.Context, _ *kong.Path, flag *kong.Flag) (any, error) {
+	if flag.Name == "help" {
+		return nil, nil //nolint:nilnil
+	}
+	path := commandPath(kctx.Selected())
+	v, _, ok := f.lookup(path, flag.Name, !f.fromRepo(kctx, path))
+	if !ok {
+		return nil, nil //nolint:nilnil
+	}
+	return flagValue(v)
+}
+
+// fromRepo reports whether the command at path syncs from a --repo clone: the
+// working directory is then not the jobs repo, so its config is not used.
+func (f *configFiles) fromRepo(kctx *kong.Context, path string) bool {
+	for _, p := range kctx.Path {
+		if p.Flag != nil && p.Flag.Name == "repo" && !p.Resolved {
+			return kctx.Value(p).String() != ""
+		}
+	}
+	v, _, ok := f.lookup(path, "repo", false)
+	return ok && fmt.Sprint(v) != ""
+}
+
+// flagValue turns a YAML value into what kong parses from the command line,
+// so that e.g. interval: 60 is an error rather than 60ns.
+func flagValue(v any) (any, error) {
+	switch v := v.(type) {
+	case []any:
+		out := make([]any, 0, len(v))
+		for _, e := range v {
+			s, err := flagValue(e)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	case map[string]any:
+		return nil, errConfigValue
+	case nil:
+		return "", nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+// Validate implements kong.Resolver. It rejects config keys that name no
+// command or flag, so typos do not go unnoticed.
+func (f *configFiles) Validate(app *kong.Application) error {
+	flags := make(map[string]map[string]bool)
+	all := make(map[string]bool)
+	var walk func(n *kong.Node)
+	walk = func(n *kong.Node) {
+		if n.Type == kong.CommandNode {
+			names := make(map[string]bool)
+			for _, fl := range n.Flags {
+				names[fl.Name] = true
+				all[fl.Name] = true
+			}
+			flags[commandPath(n)] = names
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(app.Node)
+
+	for _, src := range []struct {
+		file string
+		cfg  *config.Config
+	}{{f.repoFile, f.repo}, {f.hostFile, f.host}} {
+		file, cfg := src.file, src.cfg
+		repo := cfg == f.repo
+		if repo && cfg.AllowedEnv != nil {
+			return fmt.Errorf("%s: allowed_env %w", file, errHostOnlySetting)
+		}
+		for name := range cfg.Flags {
+			if !all[name] {
+				return fmt.Errorf("%s: flags: %w: %s", file, errUnknownConfigFlag, name)
+			}
+			if repo && hostOnly(name) {
+				return fmt.Errorf("%s: flags: %s %w", file, name, errHostOnlyFlag)
+			}
+		}
+		for path, cmdFlags := range cfg.Commands {
+			names, ok := flags[path]
+			if !ok {
+				return fmt.Errorf("%s: commands: %w: %s", file, errUnknownConfigCommand, path)
+			}
+			for name := range cmdFlags {
+				if !names[name] {
+					return fmt.Errorf("%s: commands: %s: %w: %s", file, path, errUnknownConfigFlag, name)
+				}
+				if repo && hostOnly(name) {
+					return fmt.Errorf("%s: commands: %s: %s %w", file, path, name, errHostOnlyFlag)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// commandPath returns the path of command n without the application name,
+// e.g. "cache list".
+func commandPath(n *kong.Node) string {
+	var names []string
+	for ; n != nil && n.Type == kong.CommandNode; n = n.Parent {
+		names = append(names, n.Name)
+	}
+	slices.Reverse(names)
+	return strings.Join(names, " ")
+}
+
+type configCmd struct {
+	Show configShowCmd `cmd:"" help:"Print the effective configuration and where each value comes from."`
+}
+
+type configShowCmd struct {
+	All     bool     `name:"all" help:"Also list flags left at their built-in default."`
+	Command []string `arg:"" optional:"" name:"command" help:"Only show flags of this command, e.g. cache list (implies --all)."`
+}
+
+func (c *configShowCmd) Run(kctx *kong.Context, files *configFiles) error {
+	path := strings.Join(c.Command, " ")
+	var node *kong.Node
+	if path != "" {
+		for _, n := range kctx.Model.Leaves(true) {
+			if commandPath(n) == path {
+				node = n
+			}
+		}
+		if node == nil {
+			return fmt.Errorf("%w: %s", errUnknownConfigCommand, path)
+		}
+	}
+	cfg, err := files.effective("")
+	if err != nil {
+		return err
+	}
+	w := os.Stdout
+	_, _ = fmt.Fprintf(w, "repo config:  %s\n", orDash(files.repoFile))
+	_, _ = fmt.Fprintf(w, "host config:  %s\n", orDash(files.hostFile))
+	_, _ = fmt.Fprintf(w, "job user:     %s\n", orDash(cfg.Job.User))
+	_, _ = fmt.Fprintln(w, "job env:")
+	for _, k := range slices.Sorted(maps.Keys(cfg.Job.Env)) {
+		_, _ = fmt.Fprintf(w, "  %s=%s\n", k, cfg.Job.Env[k])
+	}
+	_, _ = fmt.Fprintf(w, "allowed tags: %s\n", orDash(strings.Join(cfg.AllowedTags, ", ")))
+	_, _ = fmt.Fprintf(w, "allowed env:  %s\n", orDash(strings.Join(files.host.AllowedEnv, ", ")))
+	_, _ = fmt.Fprintln(w, "vars:")
+	for _, k := range slices.Sorted(maps.Keys(cfg.Vars)) {
+		_, _ = fmt.Fprintf(w, "  %s=%s\n", k, cfg.Vars[k])
+	}
+	_, _ = fmt.Fprintln(w, "secrets recipients:")
+	for _, r := range cfg.Secrets.Recipients {
+		_, _ = fmt.Fprintf(w, "  %s\n", r)
+	}
+	_, _ = fmt.Fprintln(w)
+
+	nodes := kctx.Model.Leaves(true)
+	if node != nil {
+		nodes = []*kong.Node{node}
+	}
+	return files.writeFlags(w, nodes, c.All || node != nil)
+}
+
+func (f *configFiles) writeFlags(w io.Writer, nodes []*kong.Node, all bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "COMMAND\tFLAG\tVALUE\tSOURCE")
+	for _, n := range nodes {
+		path := commandPath(n)
+		for _, fl := range n.Flags {
+			if fl.Name == "help" {
+				continue
+			}
+			v, source, ok := f.lookup(path, fl.Name, true)
+			if !ok {
+				if !all {
+					continue
+				}
+				v, source = fl.Default, "default"
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t--%s\t%s\t%s\n", path, fl.Name, orDash(fmt.Sprint(v)), source)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package cli
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+// newConfigFiles writes the repo and host configs (skipped if empty) and
+// loads them.
+func newConfigFiles(t *testing.T, repo, host string) *configFiles {
+	t.Helper()
+	dir := t.TempDir()
+	repoFile, hostFile := filepath.Join(dir, "cronctl.yaml"), filepath.Join(dir, "config.yaml")
+	for file, data := range map[string]string{repoFile: repo, hostFile: host} {
+		if data == "" {
+			continue
+		}
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := loadConfigFiles(repoFile, hostFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// parseArgs parses args with files as the resolver, as Run does.
+func parseArgs(files *configFiles, args ...string) (*root, error) {
+	var cli root
+	k, err := kong.New(&cli,
+		kong.Name("cronctl"),
+		kong.Bind(files),
+		kong.Resolvers(files),
+		kong.Exit(func(int) {}),
+		kong.Writers(io.Discard, io.Discard),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := k.Parse(args); err != nil {
+		return nil, err
+	}
+	return &cli, nil
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		repo, host string
+		env        string
+		args       []string
+		want       string
+	}{
+		{name: "built-in default", want: "jobs"},
+		{name: "repo config", repo: "flags: {jobs-dir: repo}\n", want: "repo"},
+		{name: "repo command over repo flags", repo: "flags: {jobs-dir: repo}\ncommands: {sync: {jobs-dir: repo-sync}}\n", want: "repo-sync"},
+		{name: "host over repo", repo: "flags: {jobs-dir: repo}\n", host: "flags: {jobs-dir: host}\n", want: "host"},
+		{name: "env over host", repo: "flags: {jobs-dir: repo}\n", host: "flags: {jobs-dir: host}\n", env: "env", want: "env"},
+		{name: "command line over env", host: "flags: {jobs-dir: host}\n", env: "env", args: []string{"--jobs-dir", "cli"}, want: "cli"},
+		{name: "no repo config with --repo", repo: "flags: {jobs-dir: repo}\n", args: []string{"--repo", "/srv/jobs.git"}, want: "jobs"},
+		{name: "no repo config with host repo", repo: "flags: {jobs-dir: repo}\n", host: "flags: {repo: /srv/jobs.git}\n", want: "jobs"},
+		{name: "host config with --repo", host: "flags: {jobs-dir: host}\n", args: []string{"--repo", "/srv/jobs.git"}, want: "host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// CRONCTL_* variables are process-wide, so these run serially.
+			t.Setenv(envName("jobs-dir"), tt.env)
+			if tt.env == "" {
+				if err := os.Unsetenv(envName("jobs-dir")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			files := newConfigFiles(t, tt.repo, tt.host)
+			cli, err := parseArgs(files, append([]string{"sync"}, tt.args...)...)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if cli.Sync.JobsDir != tt.want {
+				t.Fatalf("jobs-dir = %q, want %q", cli.Sync.JobsDir, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigHostOnlyFlags(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		repo, host string
+		wantErr    error
+	}{
+		{name: "repo flags", repo: "flags: {target-dir: /tmp/evil}\n", wantErr: errHostOnlyFlag},
+		{name: "repo command", repo: "commands: {sync: {require-signed-commit: false}}\n", wantErr: errHostOnlyFlag},
+		{name: "repo allowed signers", repo: "flags: {allowed-signers: /tmp/evil}\n", wantErr: errHostOnlyFlag},
+		{name: "repo allowed env", repo: "allowed_env: [HOME]\n", wantErr: errHostOnlySetting},
+		{name: "repo selection", repo: "flags: {jobs-dir: jobs, tags: [prod]}\n"},
+		{name: "host", host: "flags: {target-dir: /srv/jobs}\ncommands: {sync: {require-signed-commit: true}}\nallowed_env: [HOME]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := parseArgs(newConfigFiles(t, tt.repo, tt.host), "version")
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parse = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHostOnlyNamesFlags(t *testing.T) {
+	t.Parallel()
+	k, err := kong.New(&root{}, kong.Name("cronctl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := make(map[string]bool)
+	for _, n := range k.Model.Leaves(true) {
+		for _, fl := range n.Flags {
+			all[fl.Name] = true
+		}
+	}
+	// A renamed flag must not silently drop out of the deny-list.
+	for _, name := range []string{
+		"age-identity", "allow-dirty", "allow-insecure-secrets", "allowed-signers",
+		"artifact-cache", "cron-dir", "from-artifacts", "listen", "lock-file",
+		"ref", "repo", "repo-dir", "require-signed-commit", "signed-manifests",
+		"status-file", "target-dir", "token-file", "verify-key", "webhook-secret-file",
+	} {
+		if !hostOnly(name) {
+			t.Errorf("hostOnly(%q) = false", name)
+		}
+		if !all[name] {
+			t.Errorf("hostOnly flag %q is not a flag of any command", name)
+		}
+	}
+	for _, name := range []string{"jobs-dir", "env", "tags", "select", "dry-run"} {
+		if hostOnly(name) {
+			t.Errorf("hostOnly(%q) = true", name)
+		}
+	}
+}
+
+func TestConfigFromRepo(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		host string
+		args []string
+		want bool
+	}{
+		{name: "working directory", args: []string{"sync"}},
+		{name: "--repo", args: []string{"sync", "--repo", "/srv/jobs.git"}, want: true},
+		{name: "empty --repo", args: []string{"sync", "--repo", ""}},
+		{name: "host config repo", host: "commands: {sync: {repo: /srv/jobs.git}}\n", args: []string{"sync"}, want: true},
+		{name: "host repo of another command", host: "commands: {agent: {repo: /srv/jobs.git}}\n", args: []string{"sync"}},
+		{name: "--repo over host config", host: "flags: {repo: /srv/jobs.git}\n", args: []string{"sync", "--repo", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			files := newConfigFiles(t, "", tt.host)
+			var cli root
+			k, err := kong.New(&cli, kong.Name("cronctl"), kong.Exit(func(int) {}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			kctx, err := k.Parse(tt.args)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if got := files.fromRepo(kctx, commandPath(kctx.Selected())); got != tt.want {
+				t.Fatalf("fromRepo = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/yegor-usoltsev/cronctl/internal/runner"
+)
+
+type execCmd struct {
+	User     string   `name:"user" required:"" help:"Job user to run the command as."`
+	Job      string   `name:"job" help:"Job ID; root-owned env files are only read from its dir under /etc/cronctl/secrets."`
+	EnvFiles []string `name:"env-file" help:"Secret KEY=VALUE file to load into the environment (repeatable; must be 0600, owned by root in the --job secrets dir, else by --user)."`
+	Command  []string `arg:"" passthrough:"" name:"command" help:"Command and arguments, after --."`
+}
+
+func (c *execCmd) Run() error {
+	cmd := c.Command
+	if len(cmd) > 0 && cmd[0] == "--" {
+		cmd = cmd[1:]
+	}
+	err := runner.Exec(runner.Options{User: c.User, JobID: c.Job, EnvFiles: c.EnvFiles, Command: cmd})
+	return fmt.Errorf("exec: %w", err)
+}
@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/yegor-usoltsev/cronctl/internal/inventory"
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+)
+
+var errHostNeedsInventory = errors.New("--host requires --inventory")
+
+type inventoryCmd struct {
+	Show inventoryShowCmd `cmd:"" help:"Print the selection, variables and jobs a host gets from the inventory."`
+}
+
+type inventoryShowCmd struct {
+	Inventory string `name:"inventory" default:"inventory.yaml" type:"existingfile" help:"Inventory file."`
+	JobsDir   string `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
+	Env       string `name:"env" help:"Apply the job.<env>.yaml overlays and environments.<env> blocks of this environment."`
+	JSON      bool   `name:"json" help:"Print JSON instead of a summary."`
+	Host      string `arg:"" optional:"" name:"host" help:"Hostname to look up (default: this host's name)."`
+}
+
+type inventoryShow struct {
+	inventory.Host
+
+	Jobs []string `json:"jobs"`
+}
+
+func (c *inventoryShowCmd) Run(ctx context.Context, files *configFiles) error {
+	host, err := resolveHost(c.Inventory, c.Host)
+	if err != nil {
+		return err
+	}
+	jobOpts, err := files.jobOptions("", c.Env)
+	if err != nil {
+		return err
+	}
+	jobs, err := job.DiscoverWith(ctx, c.JobsDir, withInventoryHost(jobOpts, host))
+	if err != nil {
+		return fmt.Errorf("discover jobs: %w", err)
+	}
+	out := inventoryShow{Host: host, Jobs: []string{}}
+	for _, j := range filterHostJobs(jobs, host) {
+		out.Jobs = append(out.Jobs, j.ID)
+	}
+	if c.JSON {
+		return writeJSON(os.Stdout, out)
+	}
+	printInventoryShow(os.Stdout, out)
+	return nil
+}
+
+func printInventoryShow(w io.Writer, s inventoryShow) {
+	_, _ = fmt.Fprintf(w, "host:      %s\n", s.Name)
+	_, _ = fmt.Fprintf(w, "groups:    %s\n", orDash(strings.Join(s.Groups, ", ")))
+	_, _ = fmt.Fprintf(w, "matched:   %s\n", orDash(strings.Join(s.Patterns, ", ")))
+	_, _ = fmt.Fprintf(w, "tags:      %s\n", orDash(strings.Join(s.Tags, ", ")))
+	_, _ = fmt.Fprintf(w, "skip tags: %s\n", orDash(strings.Join(s.SkipTags, ", ")))
+	_, _ = fmt.Fprintf(w, "select:    %s\n", orDash(s.Select))
+	_, _ = fmt.Fprintln(w, "vars:")
+	for _, k := range slices.Sorted(maps.Keys(s.Vars)) {
+		_, _ = fmt.Fprintf(w, "  %s=%s\n", k, s.Vars[k])
+	}
+	_, _ = fmt.Fprintf(w, "jobs:      %d\n", len(s.Jobs))
+	for _, id := range s.Jobs {
+		_, _ = fmt.Fprintf(w, "  %s\n", id)
+	}
+}
+
+// resolveHost looks up host, or this host's name if empty, in the inventory
+// file.
+func resolveHost(file, host string) (inventory.Host, error) {
+	inv, err := inventory.Load(file)
+	if err != nil {
+		return inventory.Host{}, err
+	}
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			return inventory.Host{}, fmt.Errorf("hostname: %w", err)
+		}
+	}
+	h, err := inv.Resolve(host)
+	if err != nil {
+		return inventory.Host{}, fmt.Errorf("%s: %w", file, err)
+	}
+	return h, nil
+}
+
+func filterHostJobs(jobs []job.Job, host inventory.Host) []job.Job {
+	out := make([]job.Job, 0, len(jobs))
+	for _, j := range jobs {
+		if host.Match(j.ID, j.Spec) {
+			out = append(out, j)
+		}
+	}
+	return out
+}
+
+// inventoryOptions adds the inventory vars of host to opts if file is set.
+func inventoryOptions(opts job.Options, file, host string) (job.Options, error) {
+	if file == "" {
+		if host != "" {
+			return opts, errHostNeedsInventory
+		}
+		return opts, nil
+	}
+	h, err := resolveHost(file, host)
+	if err != nil {
+		return opts, err
+	}
+	return withInventoryHost(opts, h), nil
+}
+
+// selectHostJobs returns the jobs the inventory assigns to host h.
+func selectHostJobs(jobs []job.Job, h inventory.Host) []job.Job {
+	jobs = filterHostJobs(jobs, h)
+	log.Printf("inventory: host %s: %d jobs (groups: %s)", h.Name, len(jobs), orDash(strings.Join(h.Groups, ", ")))
+	return jobs
+}
@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/runner"
+	"github.com/yegor-usoltsev/cronctl/internal/secrets"
+)
+
+var errEncryptedNotLocal = errors.New("secrets.encrypted must be a relative path inside the job directory")
+
+type secretsCmd struct {
+	Edit secretsEditCmd `cmd:"" help:"Edit a job's encrypted secrets in $EDITOR."`
+	Set  secretsSetCmd  `cmd:"" help:"Set one variable of a job's encrypted secrets to a value read from stdin."`
+}
+
+type secretsEditCmd struct {
+	JobsDir  string `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
+	Env      string `name:"env" help:"Edit the secrets file of this environment's overlays."`
+	Identity string `name:"identity" type:"path" default:"~/.config/cronctl/age.key" help:"age identity to decrypt the current secrets with."`
+	JobID    string `arg:"" name:"job-id" help:"Job ID."`
+}
+
+func (c *secretsEditCmd) Run(ctx context.Context, files *configFiles) error {
+	f, err := openSecrets(ctx, files, c.JobsDir, c.Env, c.JobID, c.Identity)
+	if err != nil {
+		return err
+	}
+	edited, err := editPrivately(ctx, f.plain)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, f.plain) {
+		log.Printf("secrets: %s: no changes", f.path)
+		return nil
+	}
+	return f.save(ctx, edited)
+}
+
+type secretsSetCmd struct {
+	JobsDir  string `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
+	Env      string `name:"env" help:"Edit the secrets file of this environment's overlays."`
+	Identity string `name:"identity" type:"path" default:"~/.config/cronctl/age.key" help:"age identity to decrypt the current secrets with."`
+	JobID    string `arg:"" name:"job-id" help:"Job ID."`
+	Key      string `arg:"" name:"key" help:"Variable name."`
+}
+
+func (c *secretsSetCmd) Run(ctx context.Context, files *configFiles) error {
+	f, err := openSecrets(ctx, files, c.JobsDir, c.Env, c.JobID, c.Identity)
+	if err != nil {
+		return err
+	}
+	// The value comes from stdin so it stays out of argv and shell history.
+	value, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("read value: %w", err)
+	}
+	v := strings.TrimSuffix(strings.TrimSuffix(string(value), "\n"), "\r")
+	plain, err := secrets.Set(f.plain, c.Key, v)
+	if err != nil {
+		return fmt.Errorf("secrets: %w", err)
+	}
+	return f.save(ctx, plain)
+}
+
+// secretsFile is the decrypted content of a job's encrypted secrets.
+type secretsFile struct {
+	path       string
+	recipients []string
+	plain      []byte
+}
+
+// openSecrets decrypts the secrets of job id. A job without secrets.encrypted
+// gets secrets.DefaultFile, which has to be added to its spec.
+func openSecrets(ctx context.Context, files *configFiles, jobsDir, env, id, identity string) (*secretsFile, error) {
+	cfg, err := files.effective("")
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Secrets.Recipients) == 0 {
+		return nil, secrets.ErrNoRecipients
+	}
+	jobOpts, err := files.jobOptions("", env)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := job.DiscoverRawWith(ctx, jobsDir, jobOpts)
+	if err != nil {
+		return nil, fmt.Errorf("discover jobs: %w", err)
+	}
+	jobs = onlyJob(jobs, id)
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%w: %s", errJobNotFound, id)
+	}
+	j := jobs[0]
+	if j.Err != nil {
+		return nil, fmt.Errorf("%s: %w", j.YAML, j.Err)
+	}
+	spec, err := tryParseSpecForTags(j.RawYAML)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", j.YAML, err)
+	}
+	enc := spec.Secrets.Encrypted
+	if enc == "" {
+		enc = secrets.DefaultFile
+		log.Printf("secrets: %s has no secrets.encrypted; add `secrets: {encrypted: %s}` to deploy %s", j.YAML, enc, enc)
+	}
+	if !filepath.IsLocal(enc) {
+		return nil, fmt.Errorf("%w: %s", errEncryptedNotLocal, enc)
+	}
+	f := &secretsFile{path: filepath.Join(j.Dir, enc), recipients: cfg.Secrets.Recipients, plain: nil}
+	if _, err := os.Stat(f.path); errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if f.plain, err = secrets.Decrypt(ctx, f.path, identity); err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	return f, nil
+}
+
+// save checks and encrypts plain to the recipients.
+func (f *secretsFile) save(ctx context.Context, plain []byte) error {
+	if _, err := runner.ParseEnvFile(plain); err != nil {
+		return fmt.Errorf("secrets: %w", err)
+	}
+	if err := secrets.Encrypt(ctx, plain, f.recipients, f.path); err != nil {
+		return fmt.Errorf("secrets: %w", err)
+	}
+	log.Printf("secrets: wrote %s for %d recipients", f.path, len(f.recipients))
+	return nil
+}
+
+// editPrivately opens plain in $VISUAL or $EDITOR (default: vi) from a private
+// temp dir outside the repository and returns the result.
+func editPrivately(ctx context.Context, plain []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "cronctl-secrets-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	file := filepath.Join(dir, "secrets.env")
+	if err := os.WriteFile(file, plain, 0o600); err != nil {
+		return nil, fmt.Errorf("write temp file: %w", err)
+	}
+
+	editor := strings.Fields(os.Getenv("VISUAL"))
+	if len(editor) == 0 {
+		editor = strings.Fields(os.Getenv("EDITOR"))
+	}
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	cmd := exec.CommandContext(ctx, editor[0], append(editor[1:], file)...) // #nosec G204 -- running the user's editor is the point.
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("editor: %w", err)
+	}
+	edited, err := os.ReadFile(file) // #nosec G304 -- the temp file written above.
+	if err != nil {
+		return nil, fmt.Errorf("read temp file: %w", err)
+	}
+	return edited, nil
+}
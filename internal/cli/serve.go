@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/yegor-usoltsev/cronctl/internal/server"
+)
+
+var errPublicNeedsToken = errors.New("--listen on a non-loopback address requires --token-file")
+
+type serveCmd struct {
+	Listen    string `name:"listen" default:"127.0.0.1:9798" help:"Address to serve the dashboard and API on."`
+	TargetDir string `name:"target-dir" default:"/opt/cronctl/jobs" help:"Target directory of deployed job payloads."`
+	CronDir   string `name:"cron-dir" default:"/etc/cron.d" help:"Cron directory with cronctl-* files."`
+	TokenFile string `name:"token-file" type:"existingfile" help:"Require this bearer token for the API."`
+}
+
+func (c *serveCmd) Run(ctx context.Context) error {
+	opts := server.Options{TargetDir: c.TargetDir, CronDir: c.CronDir, Token: nil}
+	if c.TokenFile != "" {
+		b, err := os.ReadFile(c.TokenFile)
+		if err != nil {
+			return fmt.Errorf("read token: %w", err)
+		}
+		opts.Token = bytes.TrimSpace(b)
+	}
+	if len(opts.Token) == 0 && !server.IsLoopback(c.Listen) {
+		return errPublicNeedsToken
+	}
+	if err := server.ListenAndServe(ctx, c.Listen, server.Handler(opts)); err != nil {
+		return fmt.Errorf("serve: %w", err)
+	}
+	return nil
+}
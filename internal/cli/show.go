@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+)
+
+type showCmd struct {
+	JobsDir   string `name:"jobs-dir" default:"jobs" help:"Jobs directory."`
+	Env       string `name:"env" help:"Apply the job.<env>.yaml overlays and environments.<env> blocks of this environment."`
+	Effective bool   `name:"effective" help:"Print the spec with _defaults.yaml files and cronctl.yaml job defaults merged in."`
+	Inventory string `name:"inventory" help:"Take ${vars.*} from the inventory entry of --host."`
+	Host      string `name:"host" help:"Hostname to look up in --inventory (default: this host's name)."`
+	JobID     string `arg:"" name:"job-id" help:"Job ID."`
+}
+
+func (c *showCmd) Run(ctx context.Context, files *configFiles) error {
+	jobOpts, err := files.jobOptions("", c.Env)
+	if err != nil {
+		return err
+	}
+	if jobOpts, err = inventoryOptions(jobOpts, c.Inventory, c.Host); err != nil {
+		return err
+	}
+	jobs, err := job.DiscoverRawWith(ctx, c.JobsDir, jobOpts)
+	if err != nil {
+		return fmt.Errorf("discover jobs: %w", err)
+	}
+	jobs = onlyJob(jobs, c.JobID)
+	if len(jobs) == 0 {
+		return fmt.Errorf("%w: %s", errJobNotFound, c.JobID)
+	}
+	j := jobs[0]
+
+	out, err := os.ReadFile(j.YAML)
+	if err != nil {
+		return fmt.Errorf("read job yaml: %w", err)
+	}
+	if c.Effective {
+		if j.Err != nil {
+			return fmt.Errorf("%s: %w", j.YAML, j.Err)
+		}
+		if out, err = job.Annotate(j); err != nil {
+			return fmt.Errorf("%s: %w", j.YAML, err)
+		}
+	}
+	if _, err := os.Stdout.Write(out); err != nil {
+		return fmt.Errorf("write spec: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/yegor-usoltsev/cronctl/internal/bundle"
+	"github.com/yegor-usoltsev/cronctl/internal/manifest"
+	"github.com/yegor-usoltsev/cronctl/internal/signing"
+)
+
+type signCmd struct {
+	Key  string `name:"key" required:"" type:"existingfile" help:"ed25519 private key (PKCS#8 PEM)."`
+	Path string `arg:"" name:"path" type:"path" help:"Bundle file, or payload dir written by build --output-dir."`
+}
+
+func (c *signCmd) Run(ctx context.Context) error {
+	key, err := signing.LoadPrivateKey(c.Key)
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+	info, err := os.Stat(c.Path)
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+	if !info.IsDir() {
+		if err := bundle.Sign(ctx, c.Path, key); err != nil {
+			return fmt.Errorf("sign: %w", err)
+		}
+		log.Printf("sign: signed %s", c.Path)
+		return nil
+	}
+	n, err := signPayloadDir(c.Path, key)
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+	log.Printf("sign: signed %d payload manifests in %s", n, c.Path)
+	return nil
+}
+
+// signPayloadDir signs the manifest of every payload in dir.
+func signPayloadDir(dir string, key ed25519.PrivateKey) (int, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("read dir %s: %w", dir, err)
+	}
+	n := 0
+	for _, e := range ents {
+		if !e.IsDir() {
+			continue
+		}
+		p := manifest.Path(filepath.Join(dir, e.Name()))
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		if err := signing.SignFile(p, key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%w: %s", errNothingToSign, dir)
+	}
+	return n, nil
+}
+
+// verifyKeys loads the trusted keys at path, if set.
+func verifyKeys(path string) ([]ed25519.PublicKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+	keys, err := signing.LoadPublicKeys(path)
+	if err != nil {
+		return nil, fmt.Errorf("load verify key: %w", err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+)
+
+func TestValidateHostVars(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	jobsDir := filepath.Join(dir, "jobs")
+	if err := os.MkdirAll(filepath.Join(jobsDir, "backup"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	spec := "name: backup\nuser: app\nrun: { entrypoint: run.sh }\nenv:\n  DIR: /data/${host.short}/${vars.region}\n"
+	if err := os.WriteFile(filepath.Join(jobsDir, "backup", "job.yaml"), []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withRegion := filepath.Join(dir, "inventory.yaml")
+	if err := os.WriteFile(withRegion, []byte("hosts:\n  db-01.example.com: { vars: { region: eu } }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withoutRegion := filepath.Join(dir, "other.yaml")
+	if err := os.WriteFile(withoutRegion, []byte("hosts:\n  db-01.example.com: { vars: { zone: a } }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		inventory string
+		host      string
+		onHost    bool
+		want      string
+		wantErr   error
+	}{
+		{name: "no inventory", want: "/data/${host.short}/${vars.region}"},
+		{name: "inventory var", inventory: withRegion, want: "/data/${host.short}/${vars.region}"},
+		{name: "var not in inventory", inventory: withoutRegion, wantErr: job.ErrUndefinedVar},
+		{name: "inventory host", inventory: withRegion, host: "db-01.example.com", want: "/data/db-01/eu"},
+		{name: "on host without inventory", onHost: true, wantErr: job.ErrUndefinedVar},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := &validateCmd{JobsDir: jobsDir, Inventory: tt.inventory, Host: tt.host, OnHost: tt.onHost}
+			jobs, err := c.discover(context.Background(), newConfigFiles(t, "", ""), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(jobs) != 1 {
+				t.Fatalf("discover = %d jobs, want 1", len(jobs))
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(jobs[0].Err, tt.wantErr) {
+					t.Fatalf("Err = %v, want %v", jobs[0].Err, tt.wantErr)
+				}
+				return
+			}
+			if jobs[0].Err != nil {
+				t.Fatalf("Err = %v", jobs[0].Err)
+			}
+			if !strings.Contains(string(jobs[0].RawYAML), "DIR: "+tt.want+"\n") {
+				t.Fatalf("spec =\n%s\nwant DIR: %s", jobs[0].RawYAML, tt.want)
+			}
+		})
+	}
+}
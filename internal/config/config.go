@@ -0,0 +1,148 @@
+// Package config loads cronctl.yaml settings: flag defaults and repo-wide job
+// settings. A repo config sits at the repo root; a host config in /etc/cronctl
+// overrides it.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"strings"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	RepoFile = "cronctl.yaml"
+	HostFile = "/etc/cronctl/config.yaml"
+)
+
+// Config is a parsed config file.
+type Config struct {
+	// Flags are defaults for the flag of that name (e.g. jobs-dir) of every
+	// command that has it.
+	Flags map[string]any `yaml:"flags" json:"flags,omitempty"`
+	// Commands are flag defaults of one command, keyed by its path (e.g.
+	// "sync" or "cache list"). They win over Flags.
+	Commands map[string]map[string]any `yaml:"commands" json:"commands,omitempty"`
+	// Job fills in fields job.yaml files leave out.
+	Job job.Defaults `yaml:"job" json:"job"`
+	// AllowedTags, if set, are the only tags jobs may use.
+	AllowedTags []string `yaml:"allowed_tags" json:"allowed_tags,omitempty"`
+	// Vars are the ${vars.<name>} variables of job specs.
+	Vars map[string]string `yaml:"vars" json:"vars,omitempty"`
+	// AllowedEnv are the environment variables job specs may read as
+	// ${env.<NAME>}. Only the host config may set it.
+	AllowedEnv []string `yaml:"allowed_env" json:"allowed_env,omitempty"`
+	// Secrets configures the encrypted secrets of jobs.
+	Secrets Secrets `yaml:"secrets" json:"secrets"`
+}
+
+// Secrets configures the encrypted secrets of jobs.
+type Secrets struct {
+	// Recipients are the age public keys secrets edit and set encrypt to:
+	// the hosts that decrypt them and the people who edit them.
+	Recipients []string `yaml:"recipients" json:"recipients,omitempty"`
+}
+
+// Load reads a config file. A missing file is an empty config.
+func Load(file string) (*Config, error) {
+	b, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Config{Flags: nil, Commands: nil, Job: job.Defaults{User: "", Env: nil}, AllowedTags: nil, Vars: nil, AllowedEnv: nil, Secrets: Secrets{Recipients: nil}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	c, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return c, nil
+}
+
+// Parse parses config YAML. Unknown keys are errors; flag names may be written
+// with - or _.
+func Parse(b []byte) (*Config, error) {
+	var c Config
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	c.Flags = normalize(c.Flags)
+	for cmd, flags := range c.Commands {
+		c.Commands[cmd] = normalize(flags)
+	}
+	return &c, nil
+}
+
+func normalize(flags map[string]any) map[string]any {
+	if flags == nil {
+		return nil
+	}
+	out := make(map[string]any, len(flags))
+	for k, v := range flags {
+		out[strings.ReplaceAll(k, "_", "-")] = v
+	}
+	return out
+}
+
+// Flag returns the default of flag name for the command at path.
+func (c *Config) Flag(path, name string) (any, bool) {
+	if v, ok := c.Commands[path][name]; ok {
+		return v, true
+	}
+	v, ok := c.Flags[name]
+	return v, ok
+}
+
+// Merge returns base overridden by over: flag defaults, job env entries and
+// vars one by one, the job user, allowed tags, allowed env and secrets
+// recipients if set.
+func Merge(base, over *Config) *Config {
+	out := &Config{
+		Flags:       mergeMap(base.Flags, over.Flags),
+		Commands:    make(map[string]map[string]any),
+		Job:         job.Defaults{User: base.Job.User, Env: mergeMap(base.Job.Env, over.Job.Env)},
+		AllowedTags: base.AllowedTags,
+		Vars:        mergeMap(base.Vars, over.Vars),
+		AllowedEnv:  base.AllowedEnv,
+		Secrets:     base.Secrets,
+	}
+	for cmd, flags := range base.Commands {
+		out.Commands[cmd] = mergeMap(flags, nil)
+	}
+	for cmd, flags := range over.Commands {
+		out.Commands[cmd] = mergeMap(out.Commands[cmd], flags)
+	}
+	if over.Job.User != "" {
+		out.Job.User = over.Job.User
+	}
+	if over.AllowedTags != nil {
+		out.AllowedTags = over.AllowedTags
+	}
+	if over.AllowedEnv != nil {
+		out.AllowedEnv = over.AllowedEnv
+	}
+	if over.Secrets.Recipients != nil {
+		out.Secrets.Recipients = over.Secrets.Recipients
+	}
+	return out
+}
+
+func mergeMap[V any](base, over map[string]V) map[string]V {
+	if base == nil && over == nil {
+		return nil
+	}
+	out := make(map[string]V, len(base)+len(over))
+	maps.Copy(out, base)
+	maps.Copy(out, over)
+	return out
+}
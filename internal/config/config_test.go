@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	c, err := Parse([]byte(`
+flags:
+  jobs_dir: ops/jobs
+  remove-orphans: true
+commands:
+  sync:
+    jobs-dir: deploy/jobs
+job:
+  user: app
+  env:
+    PATH: /usr/bin:/bin
+allowed_tags: [prod, db]
+allowed_env: [SLACK_TOKEN]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Flag("build", "jobs-dir"); v != "ops/jobs" {
+		t.Fatalf("build jobs-dir = %v, want the global default", v)
+	}
+	if v, _ := c.Flag("sync", "jobs-dir"); v != "deploy/jobs" {
+		t.Fatalf("sync jobs-dir = %v, want the command default", v)
+	}
+	if v, ok := c.Flag("sync", "remove-orphans"); !ok || v != true {
+		t.Fatalf("sync remove-orphans = %v, %v", v, ok)
+	}
+	if _, ok := c.Flag("sync", "dry-run"); ok {
+		t.Fatal("sync dry-run: want unset")
+	}
+	if c.Job.User != "app" || c.Job.Env["PATH"] != "/usr/bin:/bin" || !reflect.DeepEqual(c.AllowedTags, []string{"prod", "db"}) || !reflect.DeepEqual(c.AllowedEnv, []string{"SLACK_TOKEN"}) {
+		t.Fatalf("Parse() = %+v", c)
+	}
+
+	if _, err := Parse([]byte("job:\n  usr: app\n")); err == nil || !strings.Contains(err.Error(), "field usr not found") {
+		t.Fatalf("Parse(unknown key) = %v", err)
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	t.Parallel()
+	c, err := Load(filepath.Join(t.TempDir(), "nope.yaml"))
+	if err != nil || c.Flags != nil || c.Job.User != "" {
+		t.Fatalf("Load(missing) = %+v, %v", c, err)
+	}
+	file := filepath.Join(t.TempDir(), "bad.yaml")
+	if err := os.WriteFile(file, []byte("flags: [\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err == nil || !strings.Contains(err.Error(), file) {
+		t.Fatalf("Load(bad) = %v, want an error naming the file", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+	repo, err := Parse([]byte(`
+flags: {jobs-dir: jobs, parallel: 2}
+commands: {sync: {remove-orphans: true}}
+job: {user: app, env: {PATH: /usr/bin, MAILTO: dev@example.com}}
+allowed_tags: [prod]
+secrets: {recipients: [age1alice]}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := Parse([]byte(`
+flags: {parallel: 8}
+commands: {sync: {target-dir: /srv/jobs}}
+job: {env: {MAILTO: ops@example.com}}
+vars: {region: eu-west-1}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Merge(repo, host)
+	want := &Config{
+		Flags:       map[string]any{"jobs-dir": "jobs", "parallel": 8},
+		Commands:    map[string]map[string]any{"sync": {"remove-orphans": true, "target-dir": "/srv/jobs"}},
+		Job:         m.Job,
+		AllowedTags: []string{"prod"},
+		Vars:        map[string]string{"region": "eu-west-1"},
+		Secrets:     Secrets{Recipients: []string{"age1alice"}},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("Merge() =\n%+v\nwant\n%+v", m, want)
+	}
+	if m.Job.User != "app" || !reflect.DeepEqual(m.Job.Env, map[string]string{"PATH": "/usr/bin", "MAILTO": "ops@example.com"}) {
+		t.Fatalf("Merge().Job = %+v", m.Job)
+	}
+}
@@ -0,0 +1,344 @@
+// Package gitrepo inspects the git checkout that jobs are synced from.
+package gitrepo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrUnsigned   = errors.New("commit signature verification failed")
+	ErrDirty      = errors.New("uncommitted or untracked changes")
+	ErrUnknownRef = errors.New("unknown ref")
+)
+
+var shaRe = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+// safeConfig overrides repository settings that would run arbitrary programs
+// or swap the verifier: the checkout itself is what is being verified, so its
+// .git/config is not trusted. Filter drivers are disabled by name, see
+// noFilters.
+var safeConfig = []string{ //nolint:gochecknoglobals
+	"-c", "core.fsmonitor=false",
+	"-c", "core.attributesFile=/dev/null",
+	"-c", "core.hooksPath=/dev/null",
+	"-c", "gpg.program=gpg",
+	"-c", "gpg.ssh.program=ssh-keygen",
+	"-c", "gpg.x509.program=gpgsm",
+}
+
+// safeEnv keeps the system-wide git config and attributes out.
+var safeEnv = []string{"GIT_CONFIG_NOSYSTEM=1", "GIT_ATTR_NOSYSTEM=1"} //nolint:gochecknoglobals
+
+// run runs git in dir with extra environment variables and -c options.
+func run(ctx context.Context, dir string, env, config []string, args ...string) (string, error) {
+	argv := append([]string{"-C", dir}, safeConfig...)
+	argv = append(argv, config...)
+	cmd := exec.CommandContext(ctx, "git", append(argv, args...)...)
+	cmd.Env = append(append(os.Environ(), safeEnv...), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(stdout.String())
+		}
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+	}
+	return strings.TrimRight(stdout.String(), "\n"), nil
+}
+
+// Head returns the commit checked out in the repository containing dir.
+func Head(ctx context.Context, dir string) (string, error) {
+	return run(ctx, dir, nil, nil, "rev-parse", "HEAD")
+}
+
+// noFilters returns environment variables that blank every filter driver
+// configured for the repository containing dir, so git status compares the
+// files as they are instead of running the clean or process commands of an
+// untrusted config. They are passed as GIT_CONFIG_* variables because driver
+// names may contain anything, "=" included.
+func noFilters(ctx context.Context, dir string) ([]string, error) {
+	out, err := run(ctx, dir, nil, nil, "config", "--includes", "--null", "--name-only", "--get-regexp", `^filter\.`)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	seen := make(map[string]bool)
+	for key := range strings.SplitSeq(out, "\x00") {
+		i := strings.LastIndexByte(key, '.')
+		if i <= len("filter.") {
+			continue
+		}
+		driver := key[:i]
+		if seen[driver] {
+			continue
+		}
+		seen[driver] = true
+		for _, kv := range [][2]string{{"clean", ""}, {"smudge", ""}, {"process", ""}, {"required", "false"}} {
+			n := len(env) / 2
+			env = append(env,
+				fmt.Sprintf("GIT_CONFIG_KEY_%d=%s.%s", n, driver, kv[0]),
+				fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", n, kv[1]))
+		}
+	}
+	if len(env) == 0 {
+		return nil, nil
+	}
+	return append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(env)/2)), nil
+}
+
+// status runs git status on path, relative to dir, with filters disabled.
+// Submodules are not entered: git would run there with their own config.
+func status(ctx context.Context, dir, path string, args ...string) (string, error) {
+	env, err := noFilters(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	argv := append([]string{"status", "--porcelain", "--untracked-files=all", "--ignore-submodules=all"}, args...)
+	return run(ctx, dir, append(env, "GIT_LITERAL_PATHSPECS=1"), nil, append(argv, "--", path)...)
+}
+
+// Changes returns the uncommitted and untracked (but not ignored) paths
+// under dir, as reported by git status.
+func Changes(ctx context.Context, dir string) ([]string, error) {
+	out, err := status(ctx, dir, ".")
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+// CheckClean returns ErrDirty if dir has uncommitted or untracked changes,
+// or ignored files that sync would deploy. Only the .cronctl build state and
+// .DS_Store at the root of a job dir (next to its job.yaml) are left out of
+// the payload, so only those may be ignored.
+func CheckClean(ctx context.Context, dir string) error {
+	changes, err := Changes(ctx, dir)
+	if err != nil {
+		return err
+	}
+	ignored, err := deployedIgnored(ctx, dir)
+	if err != nil {
+		return err
+	}
+	changes = append(changes, ignored...)
+	submodules, err := submodules(ctx, dir)
+	if err != nil {
+		return err
+	}
+	changes = append(changes, submodules...)
+	if len(changes) == 0 {
+		return nil
+	}
+	const maxShown = 5
+	shown := changes[:min(len(changes), maxShown)]
+	msg := strings.Join(shown, "; ")
+	if len(changes) > maxShown {
+		msg += fmt.Sprintf(" (and %d more)", len(changes)-maxShown)
+	}
+	return fmt.Errorf("%w in %s: %s", ErrDirty, dir, msg)
+}
+
+// CheckFilesClean returns ErrDirty unless each of files is committed to the
+// repository containing dir and unchanged there, so that verifying HEAD
+// covers them too.
+func CheckFilesClean(ctx context.Context, dir string, files ...string) error {
+	top, err := run(ctx, dir, nil, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("abs %s: %w", file, err)
+		}
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return fmt.Errorf("resolve %s: %w", file, err)
+		}
+		rel, err := filepath.Rel(top, resolved)
+		if err != nil || !filepath.IsLocal(rel) {
+			return fmt.Errorf("%w: %s is not in %s", ErrDirty, file, top)
+		}
+		if _, err := run(ctx, top, []string{"GIT_LITERAL_PATHSPECS=1"}, nil, "ls-files", "--error-unmatch", "--", rel); err != nil {
+			return fmt.Errorf("%w: %s is not committed", ErrDirty, file)
+		}
+		out, err := status(ctx, top, rel)
+		if err != nil {
+			return err
+		}
+		if out != "" {
+			return fmt.Errorf("%w: %s", ErrDirty, out)
+		}
+	}
+	return nil
+}
+
+// submodules returns the submodules under dir, which git status does not
+// check, as status-like lines.
+func submodules(ctx context.Context, dir string) ([]string, error) {
+	out, err := run(ctx, dir, nil, nil, "ls-files", "--stage", "-z", "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	var subs []string
+	for entry := range strings.SplitSeq(out, "\x00") {
+		info, p, ok := strings.Cut(entry, "\t")
+		if ok && strings.HasPrefix(info, "160000 ") {
+			subs = append(subs, "submodule "+p+" cannot be verified")
+		}
+	}
+	return subs, nil
+}
+
+// deployedIgnored returns the ignored paths under dir that are not build
+// state or .DS_Store at the root of a job dir, as git status lines.
+func deployedIgnored(ctx context.Context, dir string) ([]string, error) {
+	top, err := run(ctx, dir, nil, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	out, err := status(ctx, dir, ".", "--ignored=traditional")
+	if err != nil {
+		return nil, err
+	}
+	var ignored []string
+	for line := range strings.SplitSeq(out, "\n") {
+		p, ok := strings.CutPrefix(line, "!! ")
+		if !ok || notDeployed(top, p) {
+			continue
+		}
+		ignored = append(ignored, line)
+	}
+	return ignored, nil
+}
+
+// notDeployed reports whether the repository path p is .cronctl state or
+// .DS_Store directly inside a job dir of the repository at top.
+func notDeployed(top, p string) bool {
+	parts := strings.Split(strings.TrimSuffix(p, "/"), "/")
+	for i, part := range parts {
+		if part != ".cronctl" && part != ".DS_Store" {
+			continue
+		}
+		jobDir := filepath.Join(append([]string{top}, parts[:i]...)...)
+		if _, err := os.Stat(filepath.Join(jobDir, "job.yaml")); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyHead checks that HEAD of the repository containing dir is signed by
+// a key in allowedSigners: either an SSH allowed signers file (see
+// ssh-keygen(1)) or ASCII-armored GPG public keys.
+func VerifyHead(ctx context.Context, dir, allowedSigners string) error {
+	b, err := os.ReadFile(allowedSigners)
+	if err != nil {
+		return fmt.Errorf("read allowed signers: %w", err)
+	}
+	abs, err := filepath.Abs(allowedSigners)
+	if err != nil {
+		return fmt.Errorf("abs %s: %w", allowedSigners, err)
+	}
+
+	// An isolated GNUPGHOME makes sure only the allowed keys are known to gpg,
+	// whatever the signature format.
+	home, err := os.MkdirTemp("", "cronctl-gnupg-")
+	if err != nil {
+		return fmt.Errorf("mkdir temp: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(home) }()
+	env := []string{"GNUPGHOME=" + home}
+
+	if bytes.Contains(b, []byte("BEGIN PGP PUBLIC KEY BLOCK")) {
+		cmd := exec.CommandContext(ctx, "gpg", "--batch", "--quiet", "--import", abs)
+		cmd.Env = append(os.Environ(), env...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("import gpg keys: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	if _, err := run(ctx, dir, env, []string{"-c", "gpg.ssh.allowedSignersFile=" + abs}, "verify-commit", "HEAD"); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnsigned, err)
+	}
+	return nil
+}
+
+// Checkout fetches ref (a branch, tag or commit SHA) from url into the managed
+// clone at dir, creating it if needed, and checks it out into a clean
+// worktree: tracked files are reset and untracked files removed. Ignored
+// files, such as build state and outputs, are kept. It returns the commit
+// checked out.
+func Checkout(ctx context.Context, url, ref, dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", fmt.Errorf("mkdir %s: %w", dir, err)
+		}
+		if _, err := run(ctx, dir, nil, nil, "init", "--quiet"); err != nil {
+			return "", err
+		}
+		if _, err := run(ctx, dir, nil, nil, "remote", "add", "origin", url); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", fmt.Errorf("stat %s: %w", dir, err)
+	} else if _, err := run(ctx, dir, nil, nil, "remote", "set-url", "origin", url); err != nil {
+		return "", err
+	}
+
+	if _, err := run(ctx, dir, nil, nil, "fetch", "--quiet", "--force", "--prune", "--no-recurse-submodules", "origin",
+		"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"); err != nil {
+		return "", err
+	}
+	commit, err := resolve(ctx, dir, ref)
+	if err != nil {
+		return "", err
+	}
+	if _, err := run(ctx, dir, nil, nil, "checkout", "--quiet", "--force", "--detach", commit); err != nil {
+		return "", err
+	}
+	if _, err := run(ctx, dir, nil, nil, "clean", "--quiet", "-ffd"); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// resolve returns the commit ref names in the managed clone at dir. Branches
+// are looked up on origin, so a stale local branch is never used. A commit
+// that is not reachable from a branch or tag is fetched by SHA.
+func resolve(ctx context.Context, dir, ref string) (string, error) {
+	candidates := []string{"refs/remotes/origin/" + ref, "refs/tags/" + ref}
+	isSHA := shaRe.MatchString(ref)
+	if isSHA {
+		candidates = append(candidates, ref)
+	}
+	for _, c := range candidates {
+		if commit, err := run(ctx, dir, nil, nil, "rev-parse", "--verify", "--quiet", c+"^{commit}"); err == nil {
+			return commit, nil
+		}
+	}
+	if isSHA {
+		if _, err := run(ctx, dir, nil, nil, "fetch", "--quiet", "--no-recurse-submodules", "origin", ref); err == nil {
+			if commit, err := run(ctx, dir, nil, nil, "rev-parse", "--verify", "--quiet", "FETCH_HEAD^{commit}"); err == nil {
+				return commit, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownRef, ref)
+}
@@ -0,0 +1,349 @@
+package gitrepo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func git(t *testing.T, dir string, env []string, args ...string) string {
+	t.Helper()
+	argv := append([]string{"-C", dir, "-c", "user.name=CI", "-c", "user.email=ci@example.com", "-c", "init.defaultBranch=main"}, args...)
+	cmd := exec.Command("git", argv...)
+	cmd.Env = append(os.Environ(), append([]string{"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1"}, env...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func runCmd(t *testing.T, env []string, name string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s %s: %v: %s", name, strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// newRepo creates a repository with a staged jobs dir and returns the
+// repository and jobs dir paths.
+func newRepo(t *testing.T) (string, string) {
+	t.Helper()
+	repo := t.TempDir()
+	git(t, repo, nil, "init", "-q")
+	jobsDir := filepath.Join(repo, "jobs")
+	if err := os.MkdirAll(filepath.Join(jobsDir, "a"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(jobsDir, "a", "job.yaml"), []byte("version: 0\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	git(t, repo, nil, "add", "-A")
+	return repo, jobsDir
+}
+
+// sshKey generates a throwaway SSH key and returns its private key path and
+// an allowed signers line for it.
+func sshKey(t *testing.T) (string, string) {
+	t.Helper()
+	key := filepath.Join(t.TempDir(), "id_ed25519")
+	runCmd(t, nil, "ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "ci", "-f", key)
+	pub, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatalf("read pub: %v", err)
+	}
+	return key, "ci@example.com " + strings.TrimSpace(string(pub)) + "\n"
+}
+
+func writeSigners(t *testing.T, data string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "allowed_signers")
+	if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+		t.Fatalf("write signers: %v", err)
+	}
+	return p
+}
+
+func requireTools(t *testing.T, tools ...string) {
+	t.Helper()
+	for _, tool := range append([]string{"git"}, tools...) {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+}
+
+func TestVerifyHead_SSH(t *testing.T) {
+	t.Parallel()
+	requireTools(t, "ssh-keygen")
+
+	key, line := sshKey(t)
+	_, otherLine := sshKey(t)
+
+	repo, jobsDir := newRepo(t)
+	git(t, repo, nil, "-c", "gpg.format=ssh", "-c", "user.signingkey="+key, "commit", "-q", "-S", "-m", "signed")
+
+	if err := VerifyHead(context.Background(), jobsDir, writeSigners(t, line)); err != nil {
+		t.Fatalf("VerifyHead: %v", err)
+	}
+	err := VerifyHead(context.Background(), jobsDir, writeSigners(t, otherLine))
+	if !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("VerifyHead with other key: want ErrUnsigned, got %v", err)
+	}
+	// A verifier configured in the checkout itself must not be used.
+	git(t, repo, nil, "config", "gpg.ssh.program", "true")
+	if err := VerifyHead(context.Background(), jobsDir, writeSigners(t, otherLine)); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("VerifyHead with repo gpg.ssh.program: want ErrUnsigned, got %v", err)
+	}
+}
+
+func TestVerifyHead_Unsigned(t *testing.T) {
+	t.Parallel()
+	requireTools(t, "ssh-keygen")
+
+	_, line := sshKey(t)
+	repo, jobsDir := newRepo(t)
+	git(t, repo, nil, "commit", "-q", "-m", "unsigned")
+
+	err := VerifyHead(context.Background(), jobsDir, writeSigners(t, line))
+	if !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("want ErrUnsigned, got %v", err)
+	}
+}
+
+func TestVerifyHead_GPG(t *testing.T) {
+	t.Parallel()
+	requireTools(t, "gpg")
+
+	home := t.TempDir()
+	env := []string{"GNUPGHOME=" + home}
+	runCmd(t, env, "gpg", "--batch", "--passphrase", "", "--quick-gen-key", "CI <ci@example.com>", "ed25519", "sign", "never")
+	armored := runCmd(t, env, "gpg", "--batch", "--armor", "--export", "ci@example.com")
+
+	repo, jobsDir := newRepo(t)
+	git(t, repo, env, "-c", "user.signingkey=ci@example.com", "commit", "-q", "-S", "-m", "signed")
+
+	if err := VerifyHead(context.Background(), jobsDir, writeSigners(t, armored)); err != nil {
+		t.Fatalf("VerifyHead: %v", err)
+	}
+	// Without the key in the allowed signers file the signature is unknown.
+	_, line := sshKey(t)
+	if err := VerifyHead(context.Background(), jobsDir, writeSigners(t, line)); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("VerifyHead without key: want ErrUnsigned, got %v", err)
+	}
+}
+
+func TestCheckClean(t *testing.T) {
+	t.Parallel()
+	requireTools(t)
+
+	repo, jobsDir := newRepo(t)
+	git(t, repo, nil, "commit", "-q", "-m", "init")
+
+	if err := CheckClean(context.Background(), jobsDir); err != nil {
+		t.Fatalf("CheckClean on clean tree: %v", err)
+	}
+	// Changes outside the jobs dir do not matter.
+	if err := os.WriteFile(filepath.Join(repo, "README"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := CheckClean(context.Background(), jobsDir); err != nil {
+		t.Fatalf("CheckClean with change outside jobs dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(jobsDir, "a", "run.sh"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err := CheckClean(context.Background(), jobsDir)
+	if !errors.Is(err, ErrDirty) || !strings.Contains(err.Error(), "run.sh") {
+		t.Fatalf("want ErrDirty mentioning run.sh, got %v", err)
+	}
+}
+
+func TestCheckFilesClean(t *testing.T) {
+	t.Parallel()
+	requireTools(t)
+
+	repo, jobsDir := newRepo(t)
+	cfg := filepath.Join(repo, "cronctl.yaml")
+	if err := os.WriteFile(cfg, []byte("jobs_dir: jobs\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	git(t, repo, nil, "add", "-A")
+	git(t, repo, nil, "commit", "-q", "-m", "init")
+
+	ctx := context.Background()
+	if err := CheckFilesClean(ctx, jobsDir, cfg); err != nil {
+		t.Fatalf("CheckFilesClean(committed) = %v", err)
+	}
+	inv := filepath.Join(repo, "inventory.yaml")
+	if err := os.WriteFile(inv, []byte("hosts: {}\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := CheckFilesClean(ctx, jobsDir, cfg, inv); !errors.Is(err, ErrDirty) || !strings.Contains(err.Error(), "not committed") {
+		t.Fatalf("CheckFilesClean(untracked) = %v, want ErrDirty", err)
+	}
+	if err := os.WriteFile(cfg, []byte("jobs_dir: other\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := CheckFilesClean(ctx, jobsDir, cfg); !errors.Is(err, ErrDirty) || !strings.Contains(err.Error(), "cronctl.yaml") {
+		t.Fatalf("CheckFilesClean(modified) = %v, want ErrDirty", err)
+	}
+	outside := filepath.Join(t.TempDir(), "cronctl.yaml")
+	if err := os.WriteFile(outside, []byte("jobs_dir: jobs\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := CheckFilesClean(ctx, jobsDir, outside); !errors.Is(err, ErrDirty) || !strings.Contains(err.Error(), "is not in") {
+		t.Fatalf("CheckFilesClean(outside) = %v, want ErrDirty", err)
+	}
+}
+
+func TestCheckCleanIgnored(t *testing.T) {
+	t.Parallel()
+	requireTools(t)
+
+	repo, jobsDir := newRepo(t)
+	jobDir := filepath.Join(jobsDir, "a")
+	if err := os.WriteFile(filepath.Join(jobDir, ".gitignore"), []byte(".cronctl/\n.DS_Store\nbin/\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	git(t, repo, nil, "add", "-A")
+	git(t, repo, nil, "commit", "-q", "-m", "init")
+
+	// Build state and Finder metadata at the job root are never deployed.
+	for _, p := range []string{".cronctl/state.json", ".DS_Store"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(jobDir, p)), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(jobDir, p), []byte("x"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := CheckClean(context.Background(), jobsDir); err != nil {
+		t.Fatalf("CheckClean with build state: %v", err)
+	}
+	// Other ignored files end up in the payload.
+	if err := os.MkdirAll(filepath.Join(jobDir, "bin"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(jobDir, "bin", "tool"), []byte("x"), 0o755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err := CheckClean(context.Background(), jobsDir)
+	if !errors.Is(err, ErrDirty) || !strings.Contains(err.Error(), "bin/tool") {
+		t.Fatalf("want ErrDirty mentioning bin/tool, got %v", err)
+	}
+}
+
+func TestCheckCleanRunsNoRepoCommands(t *testing.T) {
+	t.Parallel()
+	requireTools(t)
+
+	repo, jobsDir := newRepo(t)
+	git(t, repo, nil, "commit", "-q", "-m", "init")
+	marker := filepath.Join(t.TempDir(), "ran")
+	for _, driver := range []string{"x", "a=b.c"} {
+		git(t, repo, nil, "config", "filter."+driver+".clean", "touch "+marker+"; cat")
+		git(t, repo, nil, "config", "filter."+driver+".process", "touch "+marker)
+		git(t, repo, nil, "config", "filter."+driver+".required", "true")
+	}
+	if err := os.WriteFile(filepath.Join(repo, ".git", "info", "attributes"), []byte("* filter=x\njob.yaml filter=a=b.c\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	// A changed file makes git status hash it, which runs the clean filter.
+	if err := os.WriteFile(filepath.Join(jobsDir, "a", "job.yaml"), []byte("version: 1\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := CheckClean(context.Background(), jobsDir); !errors.Is(err, ErrDirty) {
+		t.Fatalf("want ErrDirty, got %v", err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("a filter of the repo config ran, stat: %v", err)
+	}
+}
+
+func TestCheckout(t *testing.T) {
+	t.Parallel()
+	requireTools(t)
+
+	repo, _ := newRepo(t)
+	if err := os.WriteFile(filepath.Join(repo, ".gitignore"), []byte("out/\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	git(t, repo, nil, "add", "-A")
+	git(t, repo, nil, "commit", "-q", "-m", "v1")
+	first := git(t, repo, nil, "rev-parse", "HEAD")
+	git(t, repo, nil, "tag", "v1")
+	if err := os.WriteFile(filepath.Join(repo, "jobs", "a", "run.sh"), []byte("v2"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	git(t, repo, nil, "add", "-A")
+	git(t, repo, nil, "commit", "-q", "-m", "v2")
+	second := git(t, repo, nil, "rev-parse", "HEAD")
+
+	bare := filepath.Join(t.TempDir(), "jobs.git")
+	git(t, repo, nil, "clone", "-q", "--bare", repo, bare)
+	clone := filepath.Join(t.TempDir(), "clone")
+	ctx := context.Background()
+
+	commit, err := Checkout(ctx, bare, "main", clone)
+	if err != nil {
+		t.Fatalf("Checkout main: %v", err)
+	}
+	if commit != second {
+		t.Fatalf("Checkout main = %s, want %s", commit, second)
+	}
+
+	// Local edits are discarded, ignored files are kept.
+	runSh := filepath.Join(clone, "jobs", "a", "run.sh")
+	if err := os.WriteFile(runSh, []byte("edited"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	stray := filepath.Join(clone, "jobs", "a", "stray")
+	if err := os.WriteFile(stray, []byte("x"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	kept := filepath.Join(clone, "out", "cache")
+	if err := os.MkdirAll(filepath.Dir(kept), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(kept, []byte("x"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := Checkout(ctx, bare, "main", clone); err != nil {
+		t.Fatalf("Checkout main again: %v", err)
+	}
+	if b, err := os.ReadFile(runSh); err != nil || string(b) != "v2" {
+		t.Fatalf("run.sh = %q, %v; want v2", b, err)
+	}
+	if _, err := os.Stat(stray); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("untracked file not removed: %v", err)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Fatalf("ignored file removed: %v", err)
+	}
+
+	for _, ref := range []string{"v1", first, first[:12]} {
+		commit, err := Checkout(ctx, bare, ref, clone)
+		if err != nil {
+			t.Fatalf("Checkout %s: %v", ref, err)
+		}
+		if commit != first {
+			t.Fatalf("Checkout %s = %s, want %s", ref, commit, first)
+		}
+		if _, err := os.Stat(runSh); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Checkout %s: run.sh from v2 still present", ref)
+		}
+	}
+
+	if _, err := Checkout(ctx, bare, "no-such-branch", clone); !errors.Is(err, ErrUnknownRef) {
+		t.Fatalf("Checkout unknown ref: want ErrUnknownRef, got %v", err)
+	}
+}
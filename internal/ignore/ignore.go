@@ -0,0 +1,161 @@
+// Package ignore implements the subset of gitignore pattern syntax used for
+// job-local ignore files.
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type rule struct {
+	negate   bool
+	dirOnly  bool
+	anchored bool
+	re       *regexp.Regexp
+}
+
+// Matcher matches slash-separated relative paths against gitignore-style
+// patterns. The zero value matches nothing.
+type Matcher struct {
+	rules []rule
+}
+
+// Match reports whether rel is ignored. As with git, callers walking a tree
+// should not descend into ignored directories.
+func (m Matcher) Match(rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	if rel == "" || rel == "." {
+		return false
+	}
+
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.re == nil {
+			continue
+		}
+		if !r.anchored {
+			// Gitignore patterns without a slash can match basenames anywhere.
+			// We approximate by matching both full rel and basename.
+			if !r.re.MatchString(rel) && !r.re.MatchString(filepath.Base(rel)) {
+				continue
+			}
+		} else {
+			if !r.re.MatchString(rel) {
+				continue
+			}
+		}
+		if r.negate {
+			ignored = false
+		} else {
+			ignored = true
+		}
+	}
+	return ignored
+}
+
+// Compile builds a Matcher from gitignore lines. Blank lines, comments and
+// unsupported patterns are skipped.
+func Compile(lines []string) Matcher {
+	rules := make([]rule, 0, len(lines))
+	for _, raw := range lines {
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := rule{negate: false, dirOnly: false, anchored: false, re: nil}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = strings.TrimSpace(strings.TrimPrefix(line, "!"))
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.HasPrefix(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		re, ok := gitignorePatternToRegexp(line, r.anchored)
+		if !ok {
+			continue
+		}
+		r.re = re
+		rules = append(rules, r)
+	}
+	return Matcher{rules: rules}
+}
+
+func gitignorePatternToRegexp(pat string, anchored bool) (*regexp.Regexp, bool) {
+	// Minimal gitignore -> regexp conversion.
+	// Supported: '*', '?', character classes, and '**' spanning directories.
+	// For anchored patterns, match from start. Otherwise, match anywhere.
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("(?:^|.*/)")
+	}
+
+	for i := 0; i < len(pat); i++ {
+		c := pat[i]
+		switch c {
+		case '*':
+			// '**' => any chars including '/'
+			if i+1 < len(pat) && pat[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '.', '+', '(', ')', '|', '^', '$', '{', '}', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, false
+	}
+	return re, true
+}
+
+// ReadFile returns the lines of the ignore file at path, or nil if it does not
+// exist.
+func ReadFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	var lines []string
+	s := bufio.NewScanner(strings.NewReader(string(b)))
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("scan %s: %w", path, err)
+	}
+	return lines, nil
+}
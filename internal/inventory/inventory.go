@@ -0,0 +1,224 @@
+// Package inventory maps hosts to the jobs they run. An inventory.yaml at the
+// repo root assigns tags, a selection expression and variables to hostnames,
+// hostname globs and groups of hosts.
+package inventory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/selector"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnknownHost  = errors.New("host not in inventory")
+	ErrUnknownGroup = errors.New("unknown group")
+)
+
+// Inventory is a parsed inventory.yaml.
+type Inventory struct {
+	Groups map[string]Group `yaml:"groups"`
+	// Hosts is keyed by hostname or hostname glob (db-*.example.com).
+	Hosts map[string]Entry `yaml:"hosts"`
+}
+
+// Entry is what a host gets.
+type Entry struct {
+	Groups []string `yaml:"groups"`
+	// Tags and SkipTags work like sync --tags and --skip-tags.
+	Tags     []string          `yaml:"tags"`
+	SkipTags []string          `yaml:"skip_tags"`
+	Select   string            `yaml:"select"`
+	Vars     map[string]string `yaml:"vars"`
+}
+
+// Group is an Entry shared by the hosts listed in it or naming it.
+type Group struct {
+	Entry `yaml:",inline"`
+	// Hosts are hostnames or hostname globs.
+	Hosts []string `yaml:"hosts"`
+}
+
+// Host is the resolved selection and variables of one host.
+type Host struct {
+	Name string `json:"name"`
+	// Groups and Patterns are the groups and hosts keys that matched.
+	Groups   []string          `json:"groups"`
+	Patterns []string          `json:"patterns"`
+	Tags     []string          `json:"tags"`
+	SkipTags []string          `json:"skip_tags"`
+	Select   string            `json:"select,omitempty"`
+	Vars     map[string]string `json:"vars"`
+
+	sel selector.Selector
+}
+
+// Load reads and checks an inventory file.
+func Load(file string) (*Inventory, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read inventory: %w", err)
+	}
+	inv, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return inv, nil
+}
+
+// Parse parses and checks inventory YAML. Unknown keys are errors.
+func Parse(b []byte) (*Inventory, error) {
+	var inv Inventory
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&inv); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse inventory: %w", err)
+	}
+	for name, g := range inv.Groups {
+		if len(g.Groups) > 0 {
+			return nil, fmt.Errorf("group %s: groups cannot contain groups", name)
+		}
+		if err := checkEntry(&inv, g.Entry); err != nil {
+			return nil, fmt.Errorf("group %s: %w", name, err)
+		}
+		for _, h := range g.Hosts {
+			if _, err := path.Match(h, ""); err != nil {
+				return nil, fmt.Errorf("group %s: host %q: %w", name, h, err)
+			}
+		}
+	}
+	for pattern, e := range inv.Hosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("host %q: %w", pattern, err)
+		}
+		if err := checkEntry(&inv, e); err != nil {
+			return nil, fmt.Errorf("host %s: %w", pattern, err)
+		}
+	}
+	return &inv, nil
+}
+
+func checkEntry(inv *Inventory, e Entry) error {
+	for _, g := range e.Groups {
+		if _, ok := inv.Groups[g]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownGroup, g)
+		}
+	}
+	if _, err := selector.Parse(e.Select); err != nil {
+		return fmt.Errorf("select: %w", err)
+	}
+	return nil
+}
+
+// Resolve returns what host gets. Groups apply first, in name order, then the
+// matching hosts entries: globs in key order, then the exact hostname. Tags
+// and skip tags accumulate, select expressions are ANDed and later vars
+// override earlier ones. A host no entry matches is an error rather than
+// getting every job.
+func (inv *Inventory) Resolve(host string) (Host, error) {
+	patterns := []string{}
+	for pattern := range inv.Hosts {
+		if pattern != host && matches(pattern, host) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	slices.Sort(patterns)
+	if _, ok := inv.Hosts[host]; ok {
+		patterns = append(patterns, host)
+	}
+
+	groups := []string{}
+	for name, g := range inv.Groups {
+		if slices.ContainsFunc(g.Hosts, func(p string) bool { return matches(p, host) }) {
+			groups = append(groups, name)
+		}
+	}
+	for _, p := range patterns {
+		groups = append(groups, inv.Hosts[p].Groups...)
+	}
+	slices.Sort(groups)
+	groups = slices.Compact(groups)
+
+	if len(patterns) == 0 && len(groups) == 0 {
+		return Host{}, fmt.Errorf("%w: %s", ErrUnknownHost, host)
+	}
+	h := Host{
+		Name: host, Groups: groups, Patterns: patterns,
+		Tags: []string{}, SkipTags: []string{}, Select: "", Vars: map[string]string{},
+		sel: selector.Selector{},
+	}
+	var selects []string
+	apply := func(e Entry) {
+		h.Tags = appendNew(h.Tags, e.Tags)
+		h.SkipTags = appendNew(h.SkipTags, e.SkipTags)
+		if e.Select != "" {
+			selects = append(selects, e.Select)
+		}
+		maps.Copy(h.Vars, e.Vars)
+	}
+	for _, g := range groups {
+		apply(inv.Groups[g].Entry)
+	}
+	for _, p := range patterns {
+		apply(inv.Hosts[p])
+	}
+	if len(selects) == 1 {
+		h.Select = selects[0]
+	} else if len(selects) > 1 {
+		h.Select = "(" + strings.Join(selects, ") AND (") + ")"
+	}
+	sel, err := selector.Parse(h.Select)
+	if err != nil {
+		return Host{}, fmt.Errorf("select: %w", err)
+	}
+	h.sel = sel
+	return h, nil
+}
+
+// Match reports whether the host gets the job id with spec.
+func (h Host) Match(id string, spec job.Spec) bool {
+	if slices.ContainsFunc(spec.Tags, func(t string) bool { return slices.Contains(h.SkipTags, t) }) {
+		return false
+	}
+	if len(h.Tags) > 0 && !slices.ContainsFunc(spec.Tags, func(t string) bool { return slices.Contains(h.Tags, t) }) {
+		return false
+	}
+	return h.sel.Match(id, spec)
+}
+
+// VarNames returns the sorted names of the vars any group or host sets.
+func (inv *Inventory) VarNames() []string {
+	var names []string
+	for _, g := range inv.Groups {
+		names = slices.AppendSeq(names, maps.Keys(g.Vars))
+	}
+	for _, e := range inv.Hosts {
+		names = slices.AppendSeq(names, maps.Keys(e.Vars))
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+func matches(pattern, host string) bool {
+	ok, _ := path.Match(pattern, host)
+	return ok
+}
+
+func appendNew(list, items []string) []string {
+	for _, it := range items {
+		if !slices.Contains(list, it) {
+			list = append(list, it)
+		}
+	}
+	return list
+}
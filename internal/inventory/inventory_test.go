@@ -0,0 +1,116 @@
+package inventory
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/selector"
+)
+
+const testInventory = `
+groups:
+  eu:
+    hosts: ["*.eu.example.com"]
+    tags: [eu]
+    vars:
+      region: eu-west-1
+      backup_dir: /data/backups
+  db:
+    tags: [db, backup]
+    select: NOT legacy
+hosts:
+  "db-*.eu.example.com":
+    groups: [db]
+    skip_tags: [reports]
+  db-01.eu.example.com:
+    select: user=postgres
+    vars:
+      backup_dir: /srv/backups
+  web-01.us.example.com:
+    tags: [web]
+`
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+	inv, err := Parse([]byte(testInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := inv.Resolve("db-01.eu.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Host{
+		Name:     "db-01.eu.example.com",
+		Groups:   []string{"db", "eu"},
+		Patterns: []string{"db-*.eu.example.com", "db-01.eu.example.com"},
+		Tags:     []string{"db", "backup", "eu"},
+		SkipTags: []string{"reports"},
+		Select:   "(NOT legacy) AND (user=postgres)",
+		Vars:     map[string]string{"region": "eu-west-1", "backup_dir": "/srv/backups"},
+		sel:      h.sel,
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Fatalf("Resolve() =\n%+v\nwant\n%+v", h, want)
+	}
+
+	for _, tt := range []struct {
+		id   string
+		spec job.Spec
+		want bool
+	}{
+		{"pg-backup", job.Spec{User: "postgres", Tags: []string{"db"}}, true},
+		{"pg-legacy", job.Spec{User: "postgres", Tags: []string{"db", "legacy"}}, false},
+		{"pg-report", job.Spec{User: "postgres", Tags: []string{"db", "reports"}}, false},
+		{"app-backup", job.Spec{User: "app", Tags: []string{"backup"}}, false},
+		{"web-cleanup", job.Spec{User: "postgres", Tags: []string{"web"}}, false},
+	} {
+		if got := h.Match(tt.id, tt.spec); got != tt.want {
+			t.Errorf("Match(%s) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+
+	// Group membership through the group's hosts list alone.
+	h, err = inv.Resolve("app-01.eu.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h.Groups, []string{"eu"}) || !reflect.DeepEqual(h.Tags, []string{"eu"}) || len(h.Patterns) != 0 {
+		t.Fatalf("Resolve(app-01) = %+v", h)
+	}
+
+	if _, err := inv.Resolve("mail-01.example.com"); !errors.Is(err, ErrUnknownHost) {
+		t.Fatalf("Resolve(unknown): want ErrUnknownHost, got %v", err)
+	}
+}
+
+func TestVarNames(t *testing.T) {
+	t.Parallel()
+	inv, err := Parse([]byte(testInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inv.VarNames(), []string{"backup_dir", "region"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("VarNames() = %v, want %v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+	for yml, want := range map[string]string{
+		"hosts:\n  a:\n    groups: [nope]\n":        "unknown group: nope",
+		"hosts:\n  a:\n    tag: [x]\n":              "field tag not found",
+		"hosts:\n  a:\n    select: \"prod AND\"\n":  selector.ErrSyntax.Error(),
+		"hosts:\n  \"[a\":\n    tags: [x]\n":        "syntax error in pattern",
+		"groups:\n  a:\n    groups: [b]\n  b: {}\n": "groups cannot contain groups",
+		"groups:\n  a:\n    hosts: [\"[a\"]\n":      "syntax error in pattern",
+	} {
+		if _, err := Parse([]byte(yml)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%q) = %v, want error containing %q", yml, err, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+var errNotMapping = errors.New("job.yaml is not a mapping")
+
+// Defaults are repo-wide values for fields a job.yaml leaves out.
+type Defaults struct {
+	User string `yaml:"user,omitempty" json:"user,omitempty"`
+	// Env entries are added to each job's env unless the job sets them.
+	Env map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+}
+
+// IsZero reports whether d changes nothing.
+func (d Defaults) IsZero() bool {
+	return d.User == "" && len(d.Env) == 0
+}
+
+// WithDefaults returns raw with d filled in: user if the job sets none and
+// the env entries it does not set. The job's own values always win.
+func WithDefaults(raw []byte, d Defaults) ([]byte, error) {
+	if d.IsZero() {
+		return raw, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("parse yaml: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		// Leave empty files for validate to report.
+		return raw, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errNotMapping
+	}
+	if !fill(root, d, func(*yaml.Node) {}) {
+		return raw, nil
+	}
+	return encode(&doc)
+}
+
+// fill adds d to the job mapping root and reports whether it changed
+// anything. mark is called with every added value.
+func fill(root *yaml.Node, d Defaults, mark func(*yaml.Node)) bool {
+	changed := false
+	if d.User != "" {
+		if user := mapValue(root, "user"); user == nil {
+			user = scalar(d.User)
+			appendKey(root, "user", user)
+			mark(user)
+			changed = true
+		} else if user.Kind == yaml.ScalarNode && user.Value == "" {
+			*user = *scalar(d.User)
+			mark(user)
+			changed = true
+		}
+	}
+	if len(d.Env) > 0 {
+		env := mapValue(root, "env")
+		switch {
+		case env == nil:
+			env = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			appendKey(root, "env", env)
+		case isNull(env):
+			*env = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		if env.Kind == yaml.MappingNode {
+			for _, k := range slices.Sorted(maps.Keys(d.Env)) {
+				if mapValue(env, k) == nil {
+					v := scalar(d.Env[k])
+					appendKey(env, k, v)
+					mark(v)
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
+func mapValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func appendKey(m *yaml.Node, key string, v *yaml.Node) {
+	m.Content = append(m.Content, scalar(key), v)
+}
+
+func scalar(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
@@ -0,0 +1,46 @@
+package job
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestWithDefaults(t *testing.T) {
+	t.Parallel()
+	d := Defaults{User: "app", Env: map[string]string{"PATH": "/usr/bin:/bin", "MAILTO": "ops@example.com"}}
+
+	tests := []struct {
+		name, raw string
+		user      string
+		env       map[string]string
+	}{
+		{"unset", "enabled: true\n", "app", map[string]string{"PATH": "/usr/bin:/bin", "MAILTO": "ops@example.com"}},
+		{"empty user, null env", "user: \"\"\nenv:\n", "app", map[string]string{"PATH": "/usr/bin:/bin", "MAILTO": "ops@example.com"}},
+		{"job wins", "user: root\nenv:\n  PATH: /opt/bin\n", "root", map[string]string{"PATH": "/opt/bin", "MAILTO": "ops@example.com"}},
+		{"flow env", "user: root\nenv: {MAILTO: \"\"}\n", "root", map[string]string{"PATH": "/usr/bin:/bin", "MAILTO": ""}},
+	}
+	for _, tt := range tests {
+		out, err := WithDefaults([]byte(tt.raw), d)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var spec Spec
+		if err := yaml.Unmarshal(out, &spec); err != nil {
+			t.Fatalf("%s: decode %q: %v", tt.name, out, err)
+		}
+		if spec.User != tt.user || !reflect.DeepEqual(spec.Env, tt.env) {
+			t.Errorf("%s: user = %q, env = %v; want %q, %v", tt.name, spec.User, spec.Env, tt.user, tt.env)
+		}
+	}
+
+	// Nothing to fill in: the file is returned as is, comments and all.
+	raw := "# keep\nuser: root\nenv: {PATH: /bin, MAILTO: x}\n"
+	if out, err := WithDefaults([]byte(raw), d); err != nil || string(out) != raw {
+		t.Fatalf("WithDefaults() = %q, %v; want unchanged", out, err)
+	}
+	if _, err := WithDefaults([]byte("- a\n"), d); err == nil {
+		t.Fatal("WithDefaults(list): want error")
+	}
+}
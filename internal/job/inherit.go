@@ -0,0 +1,481 @@
+package job
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultsFile is the name of the file, in the jobs dir or any group dir
+// below it, whose values are inherited by the jobs under that dir.
+const DefaultsFile = "_defaults.yaml"
+
+const (
+	appendTag  = "!append"
+	replaceTag = "!replace"
+	envsKey    = "environments"
+)
+
+// ErrInvalidEnv is returned for environment names that are not kebab-case.
+var ErrInvalidEnv = errors.New("invalid environment name, expected kebab-case ([a-z0-9][a-z0-9-]*)")
+
+var envRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+var (
+	errNotInheritable = errors.New("cannot be inherited")
+	errNestedEnvs     = errors.New("environments cannot be nested")
+	errAppendTag      = errors.New(appendTag + " only applies to lists")
+	errReplaceTag     = errors.New(replaceTag + " only applies to maps and lists")
+)
+
+// layer is a parsed defaults file.
+type layer struct {
+	file string
+	root *yaml.Node
+}
+
+// loadLayer reads the defaults file of dir, if any.
+func loadLayer(dir string) (*layer, error) {
+	file := filepath.Join(dir, DefaultsFile)
+	l, err := readLayer(file)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	for _, key := range []string{"name", envsKey} {
+		if mapValue(l.root, key) != nil {
+			return nil, fmt.Errorf("%s: %w: %s", file, errNotInheritable, key)
+		}
+	}
+	return l, nil
+}
+
+// loadOverlay reads a job.<env>.yaml file.
+func loadOverlay(file string) (*layer, error) {
+	l, err := readLayer(file)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		return &layer{file: file, root: &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}}, nil
+	}
+	if mapValue(l.root, envsKey) != nil {
+		return nil, fmt.Errorf("%s: %w", file, errNestedEnvs)
+	}
+	return l, nil
+}
+
+// readLayer reads a mapping YAML file. A missing or empty file is nil.
+func readLayer(file string) (*layer, error) {
+	b, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil //nolint:nilnil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", filepath.Base(file), err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("parse %s: %w", file, errNotMapping)
+	}
+	if err := checkTags(root); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return &layer{file: file, root: root}, nil
+}
+
+// environments removes the environments block from the job mapping root and
+// returns it.
+func environments(root *yaml.Node) (*yaml.Node, error) {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != envsKey {
+			continue
+		}
+		block := root.Content[i+1]
+		root.Content = slices.Delete(root.Content, i, i+2)
+		if isNull(block) {
+			return nil, nil //nolint:nilnil
+		}
+		if block.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s: %w", envsKey, errNotMapping)
+		}
+		for k := 0; k+1 < len(block.Content); k += 2 {
+			name, v := block.Content[k].Value, block.Content[k+1]
+			if !envRe.MatchString(name) {
+				return nil, fmt.Errorf("%s: %w: %q", envsKey, ErrInvalidEnv, name)
+			}
+			if v.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("%s.%s: %w", envsKey, name, errNotMapping)
+			}
+			if mapValue(v, envsKey) != nil {
+				return nil, fmt.Errorf("%s.%s: %w", envsKey, name, errNestedEnvs)
+			}
+		}
+		return block, nil
+	}
+	return nil, nil //nolint:nilnil
+}
+
+// overlayFiles returns the job.<env>.yaml files of the job dir by environment.
+func overlayFiles(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read job dir: %w", err)
+	}
+	files := make(map[string]string)
+	for _, ent := range entries {
+		env, ok := strings.CutPrefix(ent.Name(), "job.")
+		if !ok || ent.IsDir() {
+			continue
+		}
+		if env, ok = strings.CutSuffix(env, ".yaml"); ok && envRe.MatchString(env) {
+			files[env] = filepath.Join(dir, ent.Name())
+		}
+	}
+	return files, nil
+}
+
+func envNames(block *yaml.Node, files map[string]string) []string {
+	names := slices.Collect(maps.Keys(files))
+	if block != nil {
+		for i := 0; i+1 < len(block.Content); i += 2 {
+			names = append(names, block.Content[i].Value)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// inherit merges layers, outermost first, the last one winning:
+//
+//   - maps are merged key by key, unless tagged !replace
+//   - lists and scalars replace inherited ones; a list tagged !append is
+//     added to the inherited list instead, skipping values already in it
+//   - an explicit null drops the inherited value
+//
+// It returns the merged mapping and the file each of its nodes comes from.
+func inherit(layers []*layer) (*yaml.Node, map[*yaml.Node]string) {
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	m := merger{origin: make(map[*yaml.Node]string)}
+	for i := len(layers) - 1; i >= 0; i-- {
+		m.merge(root, layers[i].root, layers[i].file)
+	}
+	dropNulls(root)
+	stripTags(root)
+	return root, m.origin
+}
+
+// effective sets j.RawYAML to its effective spec: layers, then job.yaml, then
+// the environments.<env> block and job.<env>.yaml overlay of opts.Env merged
+// (see inherit), opts.Defaults filled in and opts.Vars interpolated. It also
+// sets j.Sources and j.Envs. Empty files are left as is.
+func effective(j *Job, layers []*layer, opts Options) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(j.RawYAML, &doc); err != nil {
+		return fmt.Errorf("parse yaml: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return errNotMapping
+	}
+	if err := checkTags(root); err != nil {
+		return err
+	}
+	block, err := environments(root)
+	if err != nil {
+		return err
+	}
+	files, err := overlayFiles(j.Dir)
+	if err != nil {
+		return err
+	}
+	j.Envs = envNames(block, files)
+
+	changed := len(layers) > 0 || block != nil || hasTags(root)
+	layers = append(slices.Clip(layers), &layer{file: j.YAML, root: root})
+	if env := opts.Env; env != "" {
+		if block != nil {
+			if v := mapValue(block, env); v != nil {
+				layers = append(layers, &layer{file: j.YAML, root: v})
+				changed = true
+			}
+		}
+		if file, ok := files[env]; ok {
+			l, err := loadOverlay(file)
+			if err != nil {
+				return err
+			}
+			layers = append(layers, l)
+			changed = true
+		}
+	}
+	merged, origin := inherit(layers)
+	orderLike(merged, root)
+	if fill(merged, opts.Defaults, func(n *yaml.Node) { origin[n] = opts.DefaultsSource }) {
+		changed = true
+	}
+	srcs := sources(merged, origin, j.YAML)
+	if opts.Vars != nil {
+		j.Sources = srcs
+		c, err := interpolate(j, merged, "", lookup(j, opts))
+		j.Sources = nil
+		if err != nil {
+			return err
+		}
+		changed = changed || c
+	}
+	if !changed {
+		return nil
+	}
+	doc.Content[0] = merged
+	b, err := encode(&doc)
+	if err != nil {
+		return err
+	}
+	j.RawYAML, j.Sources = b, srcs
+	return nil
+}
+
+// orderLike puts the keys of mapping m that ref has in the order of ref,
+// before the others.
+func orderLike(m, ref *yaml.Node) {
+	pos := func(key string) int {
+		for i := 0; i+1 < len(ref.Content); i += 2 {
+			if ref.Content[i].Value == key {
+				return i
+			}
+		}
+		return len(ref.Content)
+	}
+	pairs := make([][2]*yaml.Node, 0, len(m.Content)/2)
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		pairs = append(pairs, [2]*yaml.Node{m.Content[i], m.Content[i+1]})
+	}
+	slices.SortStableFunc(pairs, func(a, b [2]*yaml.Node) int { return pos(a[0].Value) - pos(b[0].Value) })
+	m.Content = m.Content[:0]
+	for _, p := range pairs {
+		m.Content = append(m.Content, p[0], p[1])
+	}
+}
+
+type merger struct {
+	origin map[*yaml.Node]string
+}
+
+// merge fills mapping dst with the values of mapping src that it does not
+// override.
+func (m merger) merge(dst, src *yaml.Node, file string) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, sv := src.Content[i], src.Content[i+1]
+		dv := mapValue(dst, key.Value)
+		switch {
+		case dv == nil:
+			dst.Content = append(dst.Content, m.clone(key, file), m.clone(sv, file))
+		case isNull(dv):
+		case dv.Tag == replaceTag:
+		case dv.Kind == yaml.MappingNode && sv.Kind == yaml.MappingNode:
+			m.merge(dv, sv, file)
+		case dv.Tag == appendTag && sv.Kind == yaml.SequenceNode:
+			items := make([]*yaml.Node, 0, len(sv.Content)+len(dv.Content))
+			for _, it := range sv.Content {
+				items = append(items, m.clone(it, file))
+			}
+			for _, it := range dv.Content {
+				if !containsScalar(items, it) {
+					items = append(items, it)
+				}
+			}
+			// Keep the tag: an outer layer may have more to add.
+			dv.Content = items
+		}
+	}
+}
+
+// dropNulls removes the keys whose value is null: nothing to inherit, or an
+// inherited value dropped.
+func dropNulls(n *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	out := n.Content[:0]
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, v := n.Content[i], n.Content[i+1]
+		if isNull(v) {
+			continue
+		}
+		dropNulls(v)
+		out = append(out, key, v)
+	}
+	n.Content = out
+}
+
+// clone deep-copies n, recording file as the origin of every copied node.
+func (m merger) clone(n *yaml.Node, file string) *yaml.Node {
+	c := *n
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, e := range n.Content {
+		c.Content[i] = m.clone(e, file)
+	}
+	m.origin[&c] = file
+	return &c
+}
+
+func isNull(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+func stripTags(n *yaml.Node) {
+	if n.Tag == appendTag || n.Tag == replaceTag {
+		n.Tag = ""
+	}
+	for _, c := range n.Content {
+		stripTags(c)
+	}
+}
+
+// checkTags rejects !append and !replace where they mean nothing.
+func checkTags(n *yaml.Node) error {
+	switch {
+	case n.Tag == appendTag && n.Kind != yaml.SequenceNode:
+		return fmt.Errorf("line %d: %w", n.Line, errAppendTag)
+	case n.Tag == replaceTag && n.Kind != yaml.MappingNode && n.Kind != yaml.SequenceNode:
+		return fmt.Errorf("line %d: %w", n.Line, errReplaceTag)
+	}
+	for _, c := range n.Content {
+		if err := checkTags(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasTags(n *yaml.Node) bool {
+	if n.Tag == appendTag || n.Tag == replaceTag {
+		return true
+	}
+	for _, c := range n.Content {
+		if hasTags(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsScalar(items []*yaml.Node, n *yaml.Node) bool {
+	if n.Kind != yaml.ScalarNode {
+		return false
+	}
+	for _, it := range items {
+		if it.Kind == yaml.ScalarNode && it.Value == n.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// sources returns the JSON pointers of the values of root where the origin
+// changes from that of the parent value, root itself coming from base.
+func sources(root *yaml.Node, origin map[*yaml.Node]string, base string) map[string]string {
+	out := make(map[string]string)
+	var walk func(n *yaml.Node, ptr, parent string)
+	walk = func(n *yaml.Node, ptr, parent string) {
+		from := origin[n]
+		if from != parent && from != "" {
+			out[ptr] = from
+		}
+		if from == "" {
+			from = parent
+		}
+		switch n.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				walk(n.Content[i+1], ptr+"/"+escapePointer(n.Content[i].Value), from)
+			}
+		case yaml.SequenceNode:
+			for i, e := range n.Content {
+				walk(e, ptr+"/"+strconv.Itoa(i), from)
+			}
+		}
+	}
+	origin[root] = base
+	walk(root, "", base)
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func escapePointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
+
+func encode(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("encode yaml: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Annotate returns j.RawYAML with a "# from <file>" comment on every value
+// that j.Sources lists.
+func Annotate(j Job) ([]byte, error) {
+	if len(j.Sources) == 0 {
+		return j.RawYAML, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(j.RawYAML, &doc); err != nil {
+		return nil, fmt.Errorf("parse yaml: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return j.RawYAML, nil
+	}
+	var walk func(key, n *yaml.Node, ptr string)
+	walk = func(key, n *yaml.Node, ptr string) {
+		// Flow style has no room for comments.
+		n.Style &^= yaml.FlowStyle
+		if file, ok := j.Sources[ptr]; ok {
+			switch {
+			case n.Kind == yaml.ScalarNode:
+				n.LineComment = "from " + file
+			case key != nil:
+				key.LineComment = "from " + file
+			default:
+				n.HeadComment = "from " + file
+			}
+		}
+		switch n.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				walk(n.Content[i], n.Content[i+1], ptr+"/"+escapePointer(n.Content[i].Value))
+			}
+		case yaml.SequenceNode:
+			for i, e := range n.Content {
+				walk(nil, e, ptr+"/"+strconv.Itoa(i))
+			}
+		}
+	}
+	walk(nil, doc.Content[0], "")
+	return encode(&doc)
+}
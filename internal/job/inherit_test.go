@@ -0,0 +1,165 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+}
+
+func TestDiscoverInherits(t *testing.T) {
+	t.Parallel()
+	jobsDir := t.TempDir()
+	writeFiles(t, jobsDir, map[string]string{
+		"_defaults.yaml":        "user: app\nenabled: true\ntags: [prod]\nenv:\n  PATH: /usr/bin\n  MAILTO: ops@example.com\n",
+		"db/_defaults.yaml":     "tags: !append [db]\nenv:\n  MAILTO: dba@example.com\n",
+		"db/backup/job.yaml":    "name: backup\ntags: !append [backup, prod]\nenv:\n  MAILTO: ~\n",
+		"db/tools/lib/run.sh":   "",
+		"web/job.yaml":          "user: www\ntags: [web]\nenv: !replace\n  TZ: UTC\n",
+		"plain/job.yaml":        "user: root\ntags: []\nenv: {}\n",
+		"db/_defaults.yaml.bak": "ignored: true\n",
+	})
+
+	jobs, err := DiscoverWith(context.Background(), jobsDir, Options{
+		Defaults:       Defaults{User: "", Env: map[string]string{"LANG": "C"}},
+		DefaultsSource: "cronctl.yaml",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := make(map[string]Job)
+	for _, j := range jobs {
+		byID[j.ID] = j
+	}
+	if len(jobs) != 3 {
+		t.Fatalf("got %d jobs, want backup, plain and web", len(jobs))
+	}
+
+	backup := byID["backup"]
+	if backup.Dir != filepath.Join(jobsDir, "db", "backup") {
+		t.Fatalf("backup dir = %s", backup.Dir)
+	}
+	if s := backup.Spec; s.User != "app" || !s.Enabled ||
+		!reflect.DeepEqual(s.Tags, []string{"prod", "db", "backup"}) ||
+		!reflect.DeepEqual(s.Env, map[string]string{"PATH": "/usr/bin", "LANG": "C"}) {
+		t.Fatalf("backup spec = %+v", s)
+	}
+	root, db := filepath.Join(jobsDir, DefaultsFile), filepath.Join(jobsDir, "db", DefaultsFile)
+	for ptr, want := range map[string]string{
+		"/user":     root,
+		"/tags/0":   root,
+		"/tags/1":   db,
+		"/tags/2":   backup.YAML,
+		"/env/PATH": root,
+		"/env/LANG": "cronctl.yaml",
+		"/name":     backup.YAML,
+		"/env":      backup.YAML,
+	} {
+		if got := backup.Source(ptr); got != want {
+			t.Errorf("backup.Source(%s) = %s, want %s", ptr, got, want)
+		}
+	}
+
+	if s := byID["web"].Spec; s.User != "www" || !reflect.DeepEqual(s.Tags, []string{"web"}) ||
+		!reflect.DeepEqual(s.Env, map[string]string{"TZ": "UTC", "LANG": "C"}) {
+		t.Fatalf("web spec = %+v", s)
+	}
+	if strings.Contains(string(byID["web"].RawYAML), "!replace") {
+		t.Fatalf("web RawYAML keeps the merge tag:\n%s", byID["web"].RawYAML)
+	}
+}
+
+func TestDiscoverInheritErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"name in defaults", map[string]string{"_defaults.yaml": "name: x\n", "a/job.yaml": "user: root\n"}, "_defaults.yaml: cannot be inherited: name"},
+		{"bad defaults", map[string]string{"g/_defaults.yaml": "tags: [\n", "g/a/job.yaml": "user: root\n"}, "g/_defaults.yaml: yaml: line 1"},
+		{"append scalar", map[string]string{"_defaults.yaml": "user: !append root\n", "a/job.yaml": "user: root\n"}, "!append only applies to lists"},
+		{"duplicate id", map[string]string{"x/a/job.yaml": "user: root\n", "y/a/job.yaml": "user: root\n"}, "duplicate job id: a"},
+	}
+	for _, tt := range tests {
+		jobsDir := t.TempDir()
+		writeFiles(t, jobsDir, tt.files)
+		_, err := DiscoverRaw(context.Background(), jobsDir)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: DiscoverRaw() = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestDiscoverEnv(t *testing.T) {
+	t.Parallel()
+	jobsDir := t.TempDir()
+	writeFiles(t, jobsDir, map[string]string{
+		"_defaults.yaml":         "user: app\nenv: {MAILTO: ops@example.com}\n",
+		"report/job.yaml":        "name: report\nschedule: [{cron: \"0 6 * * *\"}]\nenvironments:\n  staging:\n    user: stage\n    env: {MODE: test}\n  dev: {enabled: false}\n",
+		"report/job.prod.yaml":   "env:\n  MAILTO: oncall@example.com\nschedule: [{cron: \"*/5 * * * *\"}]\n",
+		"report/job.staging.yml": "ignored: true\n",
+		"plain/job.yaml":         "name: plain\nuser: root\n",
+	})
+
+	base, err := Discover(context.Background(), jobsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := base[1]; r.ID != "report" || r.Spec.User != "app" || r.Spec.Schedule[0].Cron != "0 6 * * *" ||
+		!reflect.DeepEqual(r.Envs, []string{"dev", "prod", "staging"}) || strings.Contains(string(r.RawYAML), "environments") {
+		t.Fatalf("base report = %+v\n%s", r, r.RawYAML)
+	}
+	if base[0].Envs != nil {
+		t.Fatalf("plain Envs = %v", base[0].Envs)
+	}
+
+	prod, err := DiscoverWith(context.Background(), jobsDir, Options{Defaults: Defaults{User: "", Env: nil}, DefaultsSource: "", Env: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := prod[1]
+	if r.Spec.User != "app" || r.Spec.Env["MAILTO"] != "oncall@example.com" || len(r.Spec.Schedule) != 1 || r.Spec.Schedule[0].Cron != "*/5 * * * *" {
+		t.Fatalf("prod report = %+v", r.Spec)
+	}
+	if got, want := r.Source("/env/MAILTO"), filepath.Join(jobsDir, "report", "job.prod.yaml"); got != want {
+		t.Fatalf("Source(/env/MAILTO) = %s, want %s", got, want)
+	}
+	if got := r.Source("/name"); got != r.YAML {
+		t.Fatalf("Source(/name) = %s, want job.yaml", got)
+	}
+
+	staging, err := DiscoverWith(context.Background(), jobsDir, Options{Defaults: Defaults{User: "", Env: nil}, DefaultsSource: "", Env: "staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := staging[1].Spec; s.User != "stage" || !reflect.DeepEqual(s.Env, map[string]string{"MAILTO": "ops@example.com", "MODE": "test"}) {
+		t.Fatalf("staging report = %+v", s)
+	}
+
+	if _, err := DiscoverWith(context.Background(), jobsDir, Options{Defaults: Defaults{User: "", Env: nil}, DefaultsSource: "", Env: "Prod"}); !errors.Is(err, ErrInvalidEnv) {
+		t.Fatalf("DiscoverWith(Env: Prod) = %v, want ErrInvalidEnv", err)
+	}
+
+	writeFiles(t, jobsDir, map[string]string{"report/job.prod.yaml": "env: [\n"})
+	raw, err := DiscoverRawWith(context.Background(), jobsDir, Options{Defaults: Defaults{User: "", Env: nil}, DefaultsSource: "", Env: "prod"})
+	if err != nil || raw[1].Err == nil || !strings.Contains(raw[1].Err.Error(), "job.prod.yaml") {
+		t.Fatalf("DiscoverRawWith(broken overlay) = %v, %v; want the job's Err naming the overlay", raw[1].Err, err)
+	}
+}
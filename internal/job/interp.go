@@ -0,0 +1,131 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrUndefinedVar is returned for ${name} references to unknown variables.
+	ErrUndefinedVar = errors.New("undefined variable")
+
+	errUnterminatedVar = errors.New("unterminated ${")
+	errEmptyVar        = errors.New("empty ${}")
+)
+
+// SpecError is an error in the value at Pointer of a job's effective spec,
+// which comes from File.
+type SpecError struct {
+	File    string
+	Pointer string
+	Err     error
+}
+
+func (e *SpecError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.File, e.Pointer, e.Err)
+}
+
+func (e *SpecError) Unwrap() error { return e.Err }
+
+// Interpolate replaces each ${name} in s with lookup(name). $${ stands for a
+// literal ${.
+func Interpolate(s string, lookup func(name string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", errUnterminatedVar
+		}
+		name := strings.TrimSpace(s[i+2 : i+end])
+		if name == "" {
+			return "", errEmptyVar
+		}
+		v, ok := lookup(name)
+		if !ok {
+			return "", fmt.Errorf("%w: %s", ErrUndefinedVar, name)
+		}
+		b.WriteString(s[:i])
+		b.WriteString(v)
+		s = s[i+end+1:]
+	}
+}
+
+// lookup returns the variables of j: opts.Vars, job.id and job.environment,
+// and opts.Placeholder ones as themselves. The process environment is only
+// read through env.<NAME> vars the caller put in opts.Vars.
+func lookup(j *Job, opts Options) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		switch name {
+		case "job.id":
+			return j.ID, true
+		case "job.environment":
+			return opts.Env, true
+		}
+		if v, ok := opts.Vars[name]; ok {
+			return v, true
+		}
+		if opts.Placeholder != nil && opts.Placeholder(name) {
+			return "${" + name + "}", true
+		}
+		return "", false
+	}
+}
+
+// interpolate interpolates the scalar values below n, the value at ptr of j,
+// and reports whether anything changed.
+func interpolate(j *Job, n *yaml.Node, ptr string, lookup func(string) (string, bool)) (bool, error) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		v, err := Interpolate(n.Value, lookup)
+		if err != nil {
+			return false, &SpecError{File: j.Source(ptr), Pointer: ptr, Err: err}
+		}
+		if v == n.Value {
+			return false, nil
+		}
+		n.Value = v
+		if n.Style == 0 {
+			// Resolve plain values like literals, e.g. enabled: ${vars.on}.
+			n.Tag = ""
+		}
+		return true, nil
+	case yaml.MappingNode:
+		changed := false
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			c, err := interpolate(j, n.Content[i+1], ptr+"/"+escapePointer(n.Content[i].Value), lookup)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+		return changed, nil
+	case yaml.SequenceNode:
+		changed := false
+		for i, e := range n.Content {
+			c, err := interpolate(j, e, ptr+"/"+strconv.Itoa(i), lookup)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+		return changed, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,124 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Parallel()
+	vars := map[string]string{"host.name": "db-01", "vars.dir": "/data"}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	tests := []struct {
+		in, want string
+		err      error
+	}{
+		{"plain $HOME and $(date)", "plain $HOME and $(date)", nil},
+		{"${vars.dir}/${host.name}/backups", "/data/db-01/backups", nil},
+		{"${ host.name }", "db-01", nil},
+		{"$${host.name} costs $$", "${host.name} costs $$", nil},
+		{"${vars.nope}", "", ErrUndefinedVar},
+		{"${host.name", "", errUnterminatedVar},
+		{"${}", "", errEmptyVar},
+	}
+	for _, tt := range tests {
+		got, err := Interpolate(tt.in, lookup)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("Interpolate(%q) = %q, %v; want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestDiscoverInterpolates(t *testing.T) {
+	t.Parallel()
+	jobsDir := t.TempDir()
+	writeFiles(t, jobsDir, map[string]string{
+		"_defaults.yaml": "user: app\nenabled: ${vars.on}\nenv:\n  BACKUP_DIR: /data/${host.name}/${job.id}\n",
+		"a/job.yaml":     "name: a\nschedule:\n  - cron: \"0 * * * *\"\n    args: [--env, \"${job.environment}\", \"$${literal}\"]\n",
+	})
+	opts := Options{Defaults: Defaults{User: "", Env: nil}, DefaultsSource: "", Env: "", Vars: map[string]string{"host.name": "db-01", "vars.on": "true"}, Placeholder: nil}
+	jobs, err := DiscoverWith(context.Background(), jobsDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := jobs[0].Spec
+	if !s.Enabled || s.Env["BACKUP_DIR"] != "/data/db-01/a" || s.Schedule[0].Args[1] != "" || s.Schedule[0].Args[2] != "${literal}" {
+		t.Fatalf("spec = %+v", s)
+	}
+
+	// Without Vars, specs are taken as is, e.g. deployed ones.
+	jobs, err = Discover(context.Background(), jobsDir)
+	if err == nil {
+		t.Fatalf("Discover() = %+v, want enabled: ${vars.on} to fail to decode", jobs[0].Spec)
+	}
+
+	delete(opts.Vars, "host.name")
+	raw, err := DiscoverRawWith(context.Background(), jobsDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var se *SpecError
+	if !errors.As(raw[0].Err, &se) || se.File != filepath.Join(jobsDir, DefaultsFile) || se.Pointer != "/env/BACKUP_DIR" || !errors.Is(se, ErrUndefinedVar) {
+		t.Fatalf("Err = %v, want an undefined variable error against _defaults.yaml", raw[0].Err)
+	}
+}
+
+func TestDiscoverReadsOnlyGivenEnv(t *testing.T) {
+	t.Parallel()
+	jobsDir := t.TempDir()
+	writeFiles(t, jobsDir, map[string]string{
+		"a/job.yaml": "name: a\nenv:\n  P: ${env.PATH}\n",
+	})
+	// PATH is set in the process, but not passed in Vars.
+	opts := Options{Defaults: Defaults{User: "", Env: nil}, DefaultsSource: "", Env: "", Vars: map[string]string{}, Placeholder: nil}
+	raw, err := DiscoverRawWith(context.Background(), jobsDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(raw[0].Err, ErrUndefinedVar) {
+		t.Fatalf("Err = %v, want ${env.PATH} to be undefined", raw[0].Err)
+	}
+
+	opts.Vars["env.PATH"] = "/bin"
+	jobs, err := DiscoverWith(context.Background(), jobsDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := jobs[0].Spec.Env["P"]; got != "/bin" {
+		t.Fatalf("env P = %q, want /bin", got)
+	}
+}
+
+func TestDiscoverKeepsPlaceholders(t *testing.T) {
+	t.Parallel()
+	jobsDir := t.TempDir()
+	writeFiles(t, jobsDir, map[string]string{
+		"a/job.yaml": "name: a\nenv:\n  DIR: /data/${host.short}/${vars.region}\n  BAD: ${vars.typo}\n",
+	})
+	opts := Options{
+		Defaults: Defaults{User: "", Env: nil}, DefaultsSource: "", Env: "", Vars: map[string]string{},
+		Placeholder: func(name string) bool { return name == "host.short" || name == "vars.region" },
+	}
+	raw, err := DiscoverRawWith(context.Background(), jobsDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var se *SpecError
+	if !errors.As(raw[0].Err, &se) || se.Pointer != "/env/BAD" || !errors.Is(se, ErrUndefinedVar) {
+		t.Fatalf("Err = %v, want only ${vars.typo} to be undefined", raw[0].Err)
+	}
+
+	writeFiles(t, jobsDir, map[string]string{"a/job.yaml": "name: a\nenv:\n  DIR: /data/${host.short}/${vars.region}\n"})
+	jobs, err := DiscoverWith(context.Background(), jobsDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := jobs[0].Spec.Env["DIR"]; got != "/data/${host.short}/${vars.region}" {
+		t.Fatalf("env DIR = %q, want the placeholders kept", got)
+	}
+}
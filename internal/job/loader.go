@@ -7,31 +7,70 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
+var errDuplicateID = errors.New("duplicate job id")
+
 // DiscoverRaw walks jobsDir and returns jobs found as jobs/<id>/job.yaml.
 //
 // It returns the raw YAML bytes without decoding. This is useful for validate,
 // where we want to report YAML and schema errors per-job without failing fast
 // on the first parse error.
 func DiscoverRaw(ctx context.Context, jobsDir string) ([]Job, error) {
-	return discover(ctx, jobsDir, false)
+	return discover(ctx, jobsDir, false, Options{})
 }
 
 // Discover walks jobsDir and returns jobs found as jobs/<id>/job.yaml and
 // decodes YAML into the job spec.
 func Discover(ctx context.Context, jobsDir string) ([]Job, error) {
-	return discover(ctx, jobsDir, true)
+	return discover(ctx, jobsDir, true, Options{})
+}
+
+// Options change what discovery returns.
+type Options struct {
+	// Defaults are filled into RawYAML and Spec (see WithDefaults), after the
+	// _defaults.yaml files.
+	Defaults Defaults
+	// DefaultsSource names where Defaults come from in Job.Sources.
+	DefaultsSource string
+	// Env, if set, applies the job.<env>.yaml overlays and environments.<env>
+	// blocks of that environment.
+	Env string
+	// Vars, if not nil, are the variables ${name} references in specs resolve
+	// to, besides job.id and job.environment (see Interpolate), including any
+	// env.<NAME> a spec may read.
+	Vars map[string]string
+	// Placeholder, if set, reports whether the undefined variable name stands
+	// for a value not known here, such as a host fact when validating in CI.
+	// Its ${name} references are then kept instead of failing.
+	Placeholder func(name string) bool
+}
+
+// DiscoverWith is Discover with options.
+func DiscoverWith(ctx context.Context, jobsDir string, opts Options) ([]Job, error) {
+	return discover(ctx, jobsDir, true, opts)
+}
+
+// DiscoverRawWith is DiscoverRaw with options. Jobs whose effective spec
+// cannot be built are returned unchanged with Err set, for validate to report.
+func DiscoverRawWith(ctx context.Context, jobsDir string, opts Options) ([]Job, error) {
+	return discover(ctx, jobsDir, false, opts)
 }
 
-func discover(ctx context.Context, jobsDir string, parse bool) ([]Job, error) {
+// discover finds the jobs of jobsDir. A dir without a job.yaml is a group dir:
+// the jobs below it are discovered too and inherit its _defaults.yaml.
+func discover(ctx context.Context, jobsDir string, parse bool, opts Options) ([]Job, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, fmt.Errorf("discover jobs: %w", err)
 	}
+	if opts.Env != "" && !envRe.MatchString(opts.Env) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidEnv, opts.Env)
+	}
 
 	entries, err := os.ReadDir(jobsDir)
 	if err != nil {
@@ -41,10 +80,33 @@ func discover(ctx context.Context, jobsDir string, parse bool) ([]Job, error) {
 		return nil, fmt.Errorf("read jobs dir: %s: %w", jobsDir, err)
 	}
 
-	jobs := make([]Job, 0, len(entries))
+	d := discovery{parse: parse, opts: opts, jobs: nil, seen: make(map[string]string)}
+	if err := d.walk(ctx, jobsDir, entries, nil); err != nil {
+		return nil, err
+	}
+	sort.Slice(d.jobs, func(i, j int) bool { return d.jobs[i].ID < d.jobs[j].ID })
+	return d.jobs, nil
+}
+
+type discovery struct {
+	parse bool
+	opts  Options
+	jobs  []Job
+	// seen maps job IDs to their dir.
+	seen map[string]string
+}
+
+func (d *discovery) walk(ctx context.Context, dir string, entries []os.DirEntry, layers []*layer) error {
+	l, err := loadLayer(dir)
+	if err != nil {
+		return err
+	}
+	if l != nil {
+		layers = append(slices.Clip(layers), l)
+	}
 	for _, ent := range entries {
 		if err := ctx.Err(); err != nil {
-			return nil, fmt.Errorf("discover jobs: %w", err)
+			return fmt.Errorf("discover jobs: %w", err)
 		}
 		if !ent.IsDir() {
 			continue
@@ -53,27 +115,48 @@ func discover(ctx context.Context, jobsDir string, parse bool) ([]Job, error) {
 		if strings.HasPrefix(id, ".") {
 			continue
 		}
-		jobDir := filepath.Join(jobsDir, id)
+		jobDir := filepath.Join(dir, id)
 		yamlPath := filepath.Join(jobDir, "job.yaml")
 		raw, err := os.ReadFile(yamlPath)
-		if err != nil {
-			if errors.Is(err, fs.ErrNotExist) {
-				continue
+		if errors.Is(err, fs.ErrNotExist) {
+			sub, err := os.ReadDir(jobDir)
+			if err != nil {
+				return fmt.Errorf("read group dir: %s: %w", jobDir, err)
+			}
+			if err := d.walk(ctx, jobDir, sub, layers); err != nil {
+				return err
 			}
-			return nil, fmt.Errorf("read job yaml: %s: %w", yamlPath, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read job yaml: %s: %w", yamlPath, err)
 		}
+		if prev, ok := d.seen[id]; ok {
+			return fmt.Errorf("%w: %s: %s and %s", errDuplicateID, id, prev, jobDir)
+		}
+		d.seen[id] = jobDir
+		if err := d.add(New(id, jobDir, yamlPath, raw), layers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		j := New(id, jobDir, yamlPath, raw)
-		if parse {
-			var spec Spec
-			if err := yaml.Unmarshal(raw, &spec); err != nil {
-				return nil, fmt.Errorf("parse yaml: %s: %w", yamlPath, err)
-			}
-			j.Spec = spec
+func (d *discovery) add(j Job, layers []*layer) error {
+	if err := effective(&j, layers, d.opts); err != nil {
+		if d.parse {
+			return fmt.Errorf("load job: %s: %w", j.YAML, err)
 		}
-		jobs = append(jobs, j)
+		j.Err = err
 	}
 
-	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
-	return jobs, nil
+	if d.parse {
+		var spec Spec
+		if err := yaml.Unmarshal(j.RawYAML, &spec); err != nil {
+			return fmt.Errorf("parse yaml: %s: %w", j.YAML, err)
+		}
+		j.Spec = spec
+	}
+	d.jobs = append(d.jobs, j)
+	return nil
 }
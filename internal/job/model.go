@@ -1,5 +1,14 @@
 package job
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var errNegativeTimeout = errors.New("negative timeout")
+
 const (
 	DefaultBuildEntrypoint = "build.sh"
 	DefaultRunEntrypoint   = "run.sh"
@@ -13,12 +22,36 @@ type Job struct {
 
 	RawYAML []byte
 	Spec    Spec
+
+	// Sources maps JSON pointers (e.g. /env/PATH) of the values of RawYAML
+	// that were inherited to the file they come from. A value not listed
+	// comes from the same file as its parent, the root from YAML.
+	Sources map[string]string
+	// Envs are the environments the job has overlays for.
+	Envs []string
+	// Err is why the effective spec could not be built (raw discovery only);
+	// RawYAML is then job.yaml as is.
+	Err error
 }
 
 func New(id, dir, yamlPath string, raw []byte) Job {
 	// Spec fields get filled by YAML decode; initialize with zero-values.
 	var zero Spec
-	return Job{ID: id, Dir: dir, YAML: yamlPath, RawYAML: raw, Spec: zero}
+	return Job{ID: id, Dir: dir, YAML: yamlPath, RawYAML: raw, Spec: zero, Sources: nil, Envs: nil, Err: nil}
+}
+
+// Source returns the file the value at JSON pointer ptr comes from.
+func (j Job) Source(ptr string) string {
+	for {
+		if file, ok := j.Sources[ptr]; ok {
+			return file
+		}
+		i := strings.LastIndexByte(ptr, '/')
+		if i < 0 {
+			return j.YAML
+		}
+		ptr = ptr[:i]
+	}
 }
 
 // Spec is the on-disk job.yaml structure.
@@ -33,8 +66,10 @@ type Spec struct {
 	Tags    []string          `yaml:"tags"`
 	Env     map[string]string `yaml:"env,omitempty"`
 
-	Build BuildSpec `yaml:"build"`
-	Run   RunSpec   `yaml:"run"`
+	Build   BuildSpec   `yaml:"build"`
+	Run     RunSpec     `yaml:"run"`
+	Payload PayloadSpec `yaml:"payload,omitempty"`
+	Secrets SecretsSpec `yaml:"secrets,omitempty"`
 
 	Schedule []ScheduleItem `yaml:"schedule"`
 }
@@ -42,12 +77,53 @@ type Spec struct {
 type BuildSpec struct {
 	Enabled    bool   `yaml:"enabled"`
 	Entrypoint string `yaml:"entrypoint,omitempty"`
+	// Sandbox runs the build in isolated Linux namespaces (see internal/sandbox).
+	Sandbox bool `yaml:"sandbox,omitempty"`
+	// Network allows network access from a sandboxed build.
+	Network bool `yaml:"network,omitempty"`
+	// Timeout is a Go duration (e.g. "10m") after which the build is killed.
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// TimeoutDuration parses Timeout. Zero means no timeout.
+func (b BuildSpec) TimeoutDuration() (time.Duration, error) {
+	if strings.TrimSpace(b.Timeout) == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(b.Timeout))
+	if err != nil {
+		return 0, fmt.Errorf("parse duration: %w", err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%w: %s", errNegativeTimeout, b.Timeout)
+	}
+	return d, nil
 }
 
 type RunSpec struct {
 	Entrypoint string `yaml:"entrypoint,omitempty"`
 }
 
+// PayloadSpec selects which files of the built job directory get deployed.
+// Patterns use gitignore syntax and are matched against paths relative to the
+// job directory.
+type PayloadSpec struct {
+	// Include, if non-empty, limits the payload to matching files.
+	Include []string `yaml:"include,omitempty"`
+	// Exclude drops matching files, after Include.
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+// SecretsSpec points at host-local secrets of the job.
+type SecretsSpec struct {
+	// EnvFiles are KEY=VALUE files loaded into the job's environment at run
+	// time by cronctl exec, so their values never reach the cron file.
+	EnvFiles []string `yaml:"env_files,omitempty"`
+	// Encrypted is an age-encrypted env file in the job dir that sync
+	// decrypts on the host and cronctl exec loads like EnvFiles.
+	Encrypted string `yaml:"encrypted,omitempty"`
+}
+
 type ScheduleItem struct {
 	Cron   string            `yaml:"cron"`
 	Args   []string          `yaml:"args,omitempty"`
@@ -0,0 +1,218 @@
+// Package manifest describes a prebuilt job payload: the inputs it was built
+// from and the digests of the files it contains.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yegor-usoltsev/cronctl/internal/version"
+)
+
+// Version is the current manifest format version.
+const Version = 1
+
+var (
+	ErrMismatch           = errors.New("payload does not match manifest")
+	errUnsupportedVersion = errors.New("unsupported manifest version")
+)
+
+// Manifest is stored in <payload>/.cronctl/manifest.json.
+type Manifest struct {
+	Version int    `json:"version"`
+	JobID   string `json:"job_id"`
+	// InputHash is the job inputs hash (see build.InputsHash) of the sources
+	// the payload was built from.
+	InputHash      string          `json:"input_hash"`
+	Files          map[string]File `json:"files"`
+	OS             string          `json:"os"`
+	Arch           string          `json:"arch"`
+	CronctlVersion string          `json:"cronctl_version,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	// Deploy records what the payload is deployed with besides its files.
+	// Manifests written by older versions have none.
+	Deploy *Deploy `json:"deploy,omitempty"`
+}
+
+// Deploy is the effective job spec and cron file a payload is deployed with.
+type Deploy struct {
+	// SpecSHA256 is the digest of the effective job spec.
+	SpecSHA256 string `json:"spec_sha256"`
+	// TargetDir is the payload directory the cron file was rendered for.
+	TargetDir string `json:"target_dir"`
+	// CronSHA256 is the digest of the rendered cron file without the source
+	// commit header; empty for jobs without a schedule.
+	CronSHA256 string `json:"cron_sha256,omitempty"`
+}
+
+// File is a payload entry. Regular files have a SHA256, symlinks a Link.
+type File struct {
+	Mode   string `json:"mode"`
+	SHA256 string `json:"sha256,omitempty"`
+	Link   string `json:"link,omitempty"`
+}
+
+// Path returns the manifest location inside a payload directory.
+func Path(payloadDir string) string {
+	return filepath.Join(payloadDir, ".cronctl", "manifest.json")
+}
+
+// New scans payloadDir and returns its manifest.
+func New(jobID, inputHash, payloadDir string) (Manifest, error) {
+	files, err := Scan(payloadDir)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return Manifest{
+		Version:        Version,
+		JobID:          jobID,
+		InputHash:      inputHash,
+		Files:          files,
+		OS:             runtime.GOOS,
+		Arch:           runtime.GOARCH,
+		CronctlVersion: version.Version,
+		CreatedAt:      time.Now().UTC(),
+		Deploy:         nil,
+	}, nil
+}
+
+// SHA256 returns the hex digest of b.
+func SHA256(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Scan returns the entries of dir, excluding .cronctl, keyed by
+// slash-separated relative path.
+func Scan(dir string) (map[string]File, error) {
+	files := make(map[string]File)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("walk %s: %w", path, err)
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return fmt.Errorf("rel %s: %w", path, err)
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ".cronctl" && d.IsDir() {
+			return filepath.SkipDir
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("info %s: %w", path, err)
+		}
+		mode := info.Mode()
+		switch {
+		case mode.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("readlink %s: %w", path, err)
+			}
+			files[rel] = File{Mode: "symlink", SHA256: "", Link: link}
+		case mode.IsRegular():
+			sum, err := fileSHA256(path)
+			if err != nil {
+				return err
+			}
+			files[rel] = File{Mode: fmt.Sprintf("%04o", mode.Perm()), SHA256: sum, Link: ""}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("read %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Read reads the manifest at path.
+func Read(path string) (Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("read manifest: %w", err)
+	}
+	return Parse(path, b)
+}
+
+// Parse parses the manifest data read from path.
+func Parse(path string, data []byte) (Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("parse manifest %s: %w", path, err)
+	}
+	if m.Version < 1 || m.Version > Version {
+		return Manifest{}, fmt.Errorf("%w: %d (%s)", errUnsupportedVersion, m.Version, path)
+	}
+	return m, nil
+}
+
+// Write writes m to path.
+func Write(path string, m Manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(path), err)
+	}
+	// #nosec G306 -- the manifest holds digests, not secrets.
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// Verify checks that the files in payloadDir are exactly those recorded in m.
+func (m Manifest) Verify(payloadDir string) error {
+	got, err := Scan(payloadDir)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for rel, want := range m.Files {
+		f, ok := got[rel]
+		switch {
+		case !ok:
+			problems = append(problems, "missing "+rel)
+		case f != want:
+			problems = append(problems, "modified "+rel)
+		}
+	}
+	for rel := range got {
+		if _, ok := m.Files[rel]; !ok {
+			problems = append(problems, "unexpected "+rel)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("%w: %s", ErrMismatch, strings.Join(problems, ", "))
+}
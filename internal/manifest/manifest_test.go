@@ -0,0 +1,57 @@
+package manifest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config"), []byte("a=1\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.Symlink("run.sh", filepath.Join(dir, "current")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	m, err := New("a-job", "abc", dir)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := Write(Path(dir), m); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, err := Read(Path(dir))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if err := got.Verify(dir); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	if err := os.Chmod(filepath.Join(dir, "run.sh"), 0o644); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "config")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "extra"), nil, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = got.Verify(dir)
+	if !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected ErrMismatch, got %v", err)
+	}
+	for _, want := range []string{"missing config", "modified run.sh", "unexpected extra"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q in %v", want, err)
+		}
+	}
+}
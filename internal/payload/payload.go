@@ -0,0 +1,127 @@
+// Package payload selects the files of a built job directory that get
+// deployed.
+package payload
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yegor-usoltsev/cronctl/internal/ignore"
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+)
+
+// IgnoreFile is the job-local file (gitignore syntax) listing paths that are
+// never deployed.
+const IgnoreFile = ".cronctlignore"
+
+// Filter decides which paths of a job directory are deployed. The zero value
+// keeps everything.
+type Filter struct {
+	include    ignore.Matcher
+	hasInclude bool
+	exclude    ignore.Matcher
+}
+
+// Load builds the filter for the job directory jobDir from spec and the
+// job's .cronctlignore.
+func Load(jobDir string, spec job.PayloadSpec) (Filter, error) {
+	lines, err := ignore.ReadFile(filepath.Join(jobDir, IgnoreFile))
+	if err != nil {
+		return Filter{}, err
+	}
+	exclude := make([]string, 0, len(spec.Exclude)+len(lines))
+	exclude = append(exclude, spec.Exclude...)
+	exclude = append(exclude, lines...)
+	return Filter{
+		include:    ignore.Compile(spec.Include),
+		hasInclude: len(spec.Include) > 0,
+		exclude:    ignore.Compile(exclude),
+	}, nil
+}
+
+// Keep reports whether the file at rel (relative to the job directory) is
+// deployed. job.yaml and the .cronctl state directory are always kept.
+func (f Filter) Keep(rel string) bool {
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	if rel == "job.yaml" || rel == ".cronctl" || strings.HasPrefix(rel, ".cronctl/") {
+		return true
+	}
+	if f.hasInclude && !matchPath(f.include, rel) {
+		return false
+	}
+	return !matchPath(f.exclude, rel)
+}
+
+// matchPath reports whether rel or one of its parent directories matches m.
+func matchPath(m ignore.Matcher, rel string) bool {
+	if m.Match(rel, false) {
+		return true
+	}
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if m.Match(dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prune removes the files of dir that f does not keep, then empty directories
+// that f does not keep either.
+func Prune(dir string, f Filter) error {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("walk %s: %w", p, err)
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return fmt.Errorf("rel %s: %w", p, err)
+		}
+		if rel == "." {
+			return nil
+		}
+		if d.IsDir() {
+			if filepath.ToSlash(rel) == ".cronctl" {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, rel)
+			return nil
+		}
+		if f.Keep(rel) {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			return fmt.Errorf("remove %s: %w", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Deepest first, so parents see their children removed.
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, rel := range dirs {
+		if f.Keep(rel) {
+			continue
+		}
+		p := filepath.Join(dir, rel)
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return fmt.Errorf("read dir %s: %w", p, err)
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove %s: %w", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package payload
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+)
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, rel := range []string{"job.yaml", "run.sh", "build.sh", "bin/app", "bin/app.debug", "src/main.go", "docs/README.md", ".cronctl/state.json"} {
+		p := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(rel), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, IgnoreFile), []byte("# debug symbols\n*.debug\n"), 0o644); err != nil {
+		t.Fatalf("write ignore file: %v", err)
+	}
+
+	f, err := Load(dir, job.PayloadSpec{Include: []string{"run.sh", "bin/", "docs/"}, Exclude: []string{"docs/"}})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := Prune(dir, f); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+
+	var got []string
+	_ = filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		got = append(got, filepath.ToSlash(rel))
+		return nil
+	})
+	want := []string{".cronctl/state.json", "bin/app", "job.yaml", "run.sh"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	for _, rel := range []string{"src", "docs"} {
+		if _, err := os.Stat(filepath.Join(dir, rel)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, stat err = %v", rel, err)
+		}
+	}
+}
+
+func TestFilter_ZeroKeepsEverything(t *testing.T) {
+	t.Parallel()
+
+	var f Filter
+	if !f.Keep("src/main.go") {
+		t.Fatalf("zero filter should keep everything")
+	}
+}
@@ -0,0 +1,324 @@
+// Package runner starts deployed jobs from cron: it loads the job's root-owned
+// secret env files, switches to the job user, loads the job user's own ones
+// and execs the entrypoint.
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Path is where cron files expect the cronctl binary that runs jobs with
+// secrets.
+const Path = "/usr/local/bin/cronctl"
+
+// SecretsDir holds the root-owned secret env files of jobs, in a dir per job
+// ID that only root can write, e.g. /etc/cronctl/secrets/report/db.env.
+const SecretsDir = "/etc/cronctl/secrets"
+
+var (
+	ErrUnsafeEnvFile = errors.New("unsafe secret env file")
+
+	errRelativeEnvFile = errors.New("path is not absolute")
+	errEnvLine         = errors.New("want KEY=VALUE")
+	errNoCommand       = errors.New("no command")
+	errInvalidJobID    = errors.New("invalid job ID")
+
+	envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Options are what Exec runs.
+type Options struct {
+	// User is the job user. The runner must be root to run as anyone else.
+	User string
+	// JobID selects the dir under SecretsDir that root-owned env files are
+	// read from. Without it, every env file must belong to User.
+	JobID string
+	// EnvFiles are loaded in order, later files overriding earlier ones and
+	// all of them overriding the inherited environment.
+	EnvFiles []string
+	Command  []string
+}
+
+// JobSecretsDir returns the dir of the root-owned secret env files of job id.
+func JobSecretsDir(id string) string {
+	return filepath.Join(SecretsDir, id)
+}
+
+// CheckEnvFile returns an error unless path is an absolute path of a regular
+// file, not a symlink, that only its owner can access. Files directly in
+// JobSecretsDir(jobID) must be owned by root and that dir must be writable
+// only by root; any other file must be owned by uid, the job user.
+func CheckEnvFile(path, jobID string, uid int) error {
+	rootDir := ""
+	if jobID != "" {
+		rootDir = JobSecretsDir(jobID)
+	}
+	return checkEnvFile(path, rootDir, uid)
+}
+
+// CheckSecretsDir returns an error unless dir, e.g. JobSecretsDir(jobID), and
+// its parent are directories, not symlinks, that only root can write.
+func CheckSecretsDir(dir string) error {
+	return checkRootDir(dir)
+}
+
+func checkEnvFile(path, rootDir string, uid int) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("%s: %w", path, errRelativeEnvFile)
+	}
+	owner := uid
+	if inDir(path, rootDir) {
+		if err := checkRootDir(rootDir); err != nil {
+			return err
+		}
+		owner = 0
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("secret env file: %w", err)
+	}
+	return checkEnvInfo(path, fi, owner)
+}
+
+// inDir reports whether path is a file directly in dir.
+func inDir(path, dir string) bool {
+	return dir != "" && filepath.Dir(filepath.Clean(path)) == filepath.Clean(dir)
+}
+
+func checkEnvInfo(path string, fi fs.FileInfo, owner int) error {
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s: not a regular file", ErrUnsafeEnvFile, path)
+	}
+	if perm := fi.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("%w: %s: mode %#o, want 0600", ErrUnsafeEnvFile, path, perm)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("%w: %s: unknown owner", ErrUnsafeEnvFile, path)
+	}
+	if got := int(st.Uid); got != owner {
+		return fmt.Errorf("%w: %s: owned by uid %d, want %d", ErrUnsafeEnvFile, path, got, owner)
+	}
+	return nil
+}
+
+// checkRootDir returns an error unless dir and its parent are directories,
+// not symlinks, that only root can write.
+func checkRootDir(dir string) error {
+	for _, d := range []string{dir, filepath.Dir(dir)} {
+		fi, err := os.Lstat(d)
+		if err != nil {
+			return fmt.Errorf("secrets dir: %w", err)
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		switch {
+		case !fi.IsDir():
+			return fmt.Errorf("%w: %s: not a directory", ErrUnsafeEnvFile, d)
+		case fi.Mode().Perm()&0o022 != 0:
+			return fmt.Errorf("%w: %s: mode %#o, writable by others than root", ErrUnsafeEnvFile, d, fi.Mode().Perm())
+		case !ok || st.Uid != 0:
+			return fmt.Errorf("%w: %s: not owned by root", ErrUnsafeEnvFile, d)
+		}
+	}
+	return nil
+}
+
+// ParseEnvFile parses KEY=VALUE lines. Blank lines and # comments are
+// skipped, an "export " prefix is allowed and a value wrapped in single or
+// double quotes is taken as is, without the quotes.
+func ParseEnvFile(data []byte) ([]string, error) {
+	var env []string
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envKeyRe.MatchString(key) {
+			return nil, fmt.Errorf("line %d: %w", n, errEnvLine)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env = append(env, key+"="+value)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+	return env, nil
+}
+
+// loadEnvFiles parses the env files of a job run by uid into vars, by index:
+// with root set the files directly in rootDir, which must be owned by root,
+// and otherwise all other files, which must be owned by uid. Files are opened
+// without following symlinks and checked through the open descriptor.
+func loadEnvFiles(vars [][]string, files []string, rootDir string, uid int, root bool) error {
+	for i, file := range files {
+		if !filepath.IsAbs(file) {
+			return fmt.Errorf("%s: %w", file, errRelativeEnvFile)
+		}
+		inRoot := inDir(file, rootDir)
+		if inRoot != root {
+			continue
+		}
+		owner := uid
+		if inRoot {
+			if err := checkRootDir(rootDir); err != nil {
+				return err
+			}
+			owner = 0
+		}
+		env, err := readEnvFile(file, owner)
+		if err != nil {
+			return err
+		}
+		vars[i] = env
+	}
+	return nil
+}
+
+// readEnvFile opens path without following a symlink, checks the open file
+// like CheckEnvFile and parses it.
+func readEnvFile(path string, owner int) ([]string, error) {
+	// O_NONBLOCK keeps a FIFO planted at path from blocking the open.
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0) // #nosec G304 -- checked below.
+	if errors.Is(err, syscall.ELOOP) {
+		return nil, fmt.Errorf("%w: %s: not a regular file", ErrUnsafeEnvFile, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("secret env file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("secret env file: %w", err)
+	}
+	if err := checkEnvInfo(path, fi, owner); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("secret env file: %w", err)
+	}
+	env, err := ParseEnvFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return env, nil
+}
+
+// Exec replaces the process with opts.Command run as opts.User, with the
+// secret env files loaded. It only returns on error.
+func Exec(opts Options) error {
+	if len(opts.Command) == 0 {
+		return errNoCommand
+	}
+	u, err := user.Lookup(opts.User)
+	if err != nil {
+		return fmt.Errorf("lookup user: %w", err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("parse uid of %s: %w", u.Username, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("parse gid of %s: %w", u.Username, err)
+	}
+	rootDir := ""
+	if opts.JobID != "" {
+		if !filepath.IsLocal(opts.JobID) || strings.ContainsRune(opts.JobID, '/') {
+			return fmt.Errorf("%w: %q", errInvalidJobID, opts.JobID)
+		}
+		rootDir = JobSecretsDir(opts.JobID)
+	}
+	// Root-owned files are read before dropping privileges, the job user's
+	// own files only after, so that they cannot point root at anything the
+	// job user could not read itself.
+	vars := make([][]string, len(opts.EnvFiles))
+	if err := loadEnvFiles(vars, opts.EnvFiles, rootDir, uid, true); err != nil {
+		return fmt.Errorf("secrets: %w", err)
+	}
+
+	env := os.Environ()
+	if uid != os.Getuid() {
+		if err := switchUser(u, uid, gid); err != nil {
+			return err
+		}
+		env = append(env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+	}
+	if err := loadEnvFiles(vars, opts.EnvFiles, rootDir, uid, false); err != nil {
+		return fmt.Errorf("secrets: %w", err)
+	}
+	for _, v := range vars {
+		env = append(env, v...)
+	}
+
+	path := opts.Command[0]
+	if !strings.ContainsRune(path, '/') {
+		// Cron's PATH, not the secrets', picks the command.
+		if path, err = exec.LookPath(path); err != nil {
+			return fmt.Errorf("look up command: %w", err)
+		}
+	}
+	if err := syscall.Exec(path, opts.Command, dedupEnv(env)); err != nil { // #nosec G204 -- running the job is the point.
+		return fmt.Errorf("exec %s: %w", path, err)
+	}
+	return nil
+}
+
+// switchUser drops the process to uid and gid with the supplementary groups
+// of u, like cron does for the user column.
+func switchUser(u *user.User, uid, gid int) error {
+	groups := []int{gid}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.Atoi(id); err == nil && g != gid {
+				groups = append(groups, g)
+			}
+		}
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid: %w", err)
+	}
+	return nil
+}
+
+// dedupEnv keeps the last value of each variable.
+func dedupEnv(env []string) []string {
+	last := make(map[string]int, len(env))
+	for i, kv := range env {
+		k, _, _ := strings.Cut(kv, "=")
+		last[k] = i
+	}
+	out := make([]string, 0, len(last))
+	for i, kv := range env {
+		k, _, _ := strings.Cut(kv, "=")
+		if last[k] == i {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
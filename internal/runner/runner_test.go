@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	t.Parallel()
+	env, err := ParseEnvFile([]byte("# db\nDB_PASSWORD=s3cr=t\n\nexport TOKEN='a b'\nQUOTED=\"x\"\nEMPTY=\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"DB_PASSWORD=s3cr=t", "TOKEN=a b", "QUOTED=x", "EMPTY="}
+	if !reflect.DeepEqual(env, want) {
+		t.Fatalf("ParseEnvFile() = %q, want %q", env, want)
+	}
+	for _, bad := range []string{"NOVALUE\n", "1X=y\n", "A B=c\n"} {
+		if _, err := ParseEnvFile([]byte(bad)); !errors.Is(err, errEnvLine) {
+			t.Fatalf("ParseEnvFile(%q) = %v, want %v", bad, err, errEnvLine)
+		}
+	}
+}
+
+func TestCheckEnvFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "job.env")
+	if err := os.WriteFile(file, []byte("A=1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEnvFile(file, "", os.Getuid()); err != nil {
+		t.Fatalf("checkEnvFile(0600) = %v", err)
+	}
+	if err := checkEnvFile(file, "", os.Getuid()+1); !errors.Is(err, ErrUnsafeEnvFile) {
+		t.Fatalf("checkEnvFile(other owner) = %v, want %v", err, ErrUnsafeEnvFile)
+	}
+	link := filepath.Join(dir, "link.env")
+	if err := os.Symlink(file, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEnvFile(link, "", os.Getuid()); !errors.Is(err, ErrUnsafeEnvFile) {
+		t.Fatalf("checkEnvFile(symlink) = %v, want %v", err, ErrUnsafeEnvFile)
+	}
+	if err := os.Chmod(file, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEnvFile(file, "", os.Getuid()); !errors.Is(err, ErrUnsafeEnvFile) {
+		t.Fatalf("checkEnvFile(0640) = %v, want %v", err, ErrUnsafeEnvFile)
+	}
+	if err := checkEnvFile(dir, "", os.Getuid()); !errors.Is(err, ErrUnsafeEnvFile) {
+		t.Fatalf("checkEnvFile(dir) = %v, want %v", err, ErrUnsafeEnvFile)
+	}
+	if err := checkEnvFile("job.env", "", os.Getuid()); !errors.Is(err, errRelativeEnvFile) {
+		t.Fatalf("checkEnvFile(relative) = %v, want %v", err, errRelativeEnvFile)
+	}
+	if err := checkEnvFile(filepath.Join(dir, "missing.env"), "", os.Getuid()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("checkEnvFile(missing) = %v, want not exist", err)
+	}
+}
+
+func TestCheckEnvFileRootDir(t *testing.T) {
+	t.Parallel()
+	rootDir := filepath.Join(t.TempDir(), "report")
+	if err := os.Mkdir(rootDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(rootDir, "db.env")
+	if err := os.WriteFile(file, []byte("A=1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Files in the job's secrets dir must be root's, in a dir only root
+	// can write.
+	err := checkEnvFile(file, rootDir, 12345)
+	if os.Getuid() != 0 {
+		if !errors.Is(err, ErrUnsafeEnvFile) {
+			t.Fatalf("checkEnvFile(dir not owned by root) = %v, want %v", err, ErrUnsafeEnvFile)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("checkEnvFile(root-owned) = %v", err)
+	}
+	// Root-owned files elsewhere are not the job user's.
+	other := filepath.Join(filepath.Dir(rootDir), "other.env")
+	if err := os.WriteFile(other, []byte("A=1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEnvFile(other, rootDir, 12345); !errors.Is(err, ErrUnsafeEnvFile) {
+		t.Fatalf("checkEnvFile(root-owned outside the secrets dir) = %v, want %v", err, ErrUnsafeEnvFile)
+	}
+	if err := os.Chmod(rootDir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEnvFile(file, rootDir, 12345); !errors.Is(err, ErrUnsafeEnvFile) {
+		t.Fatalf("checkEnvFile(writable secrets dir) = %v, want %v", err, ErrUnsafeEnvFile)
+	}
+}
+
+func TestLoadEnvFiles(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.env"), filepath.Join(dir, "b.env")
+	for file, data := range map[string]string{a: "X=a\nY=a\n", b: "Y=b\n"} {
+		if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := []string{a, b}
+	vars := make([][]string, len(files))
+	if err := loadEnvFiles(vars, files, "", os.Getuid(), true); err != nil || vars[0] != nil || vars[1] != nil {
+		t.Fatalf("root pass = %q, %v; want nothing read", vars, err)
+	}
+	if err := loadEnvFiles(vars, files, "", os.Getuid(), false); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"X=a", "Y=a"}, {"Y=b"}}
+	if !reflect.DeepEqual(vars, want) {
+		t.Fatalf("vars = %q, want %q", vars, want)
+	}
+
+	// A symlink swapped in after any check is not followed.
+	link := filepath.Join(dir, "link.env")
+	if err := os.Symlink(a, link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readEnvFile(link, os.Getuid()); !errors.Is(err, ErrUnsafeEnvFile) {
+		t.Fatalf("readEnvFile(symlink) = %v, want %v", err, ErrUnsafeEnvFile)
+	}
+}
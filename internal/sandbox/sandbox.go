@@ -0,0 +1,81 @@
+// Package sandbox runs build scripts in isolated Linux namespaces.
+//
+// The build runs in new mount, PID, IPC and UTS namespaces (and a new network
+// namespace unless networking is allowed). Inside, the whole filesystem is
+// remounted read-only except the job dir, which stays writable, and /tmp and
+// /dev/shm, which are private tmpfs mounts. A mount that cannot be made
+// read-only fails the build. When cronctl is not root, a user namespace maps
+// the caller to root inside the sandbox so the mounts can be set up.
+//
+// Setup happens in a re-executed cronctl process (see InitArg and Main)
+// before it execs the build entrypoint.
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"syscall"
+)
+
+// InitArg is the hidden first argument that makes cronctl act as the sandbox
+// init process. It is not a user-facing command.
+const InitArg = "__cronctl-sandbox-init"
+
+var (
+	// ErrUnavailable is returned when the kernel or platform cannot provide
+	// the namespaces required for a sandboxed build.
+	ErrUnavailable = errors.New("sandbox unavailable")
+
+	errInitUsage = errors.New("usage: " + InitArg + " <dir> <uid> <gid> -- <path>")
+)
+
+// Options configures a sandboxed command.
+type Options struct {
+	// Dir is the job dir: the working directory and the only writable path
+	// besides /tmp.
+	Dir string
+	// Network keeps the host network namespace.
+	Network bool
+	// Credential, if set, is the user the build runs as. Requires root.
+	Credential *syscall.Credential
+}
+
+// Main is the entry point of the sandbox init process. It never returns on
+// success because it replaces itself with the build entrypoint.
+func Main(args []string) int {
+	dir, uid, gid, path, err := parseInitArgs(args)
+	if err != nil {
+		log.Printf("sandbox: %v", err)
+		return 2
+	}
+	if err := initAndExec(dir, uid, gid, path); err != nil {
+		log.Printf("sandbox: %v", err)
+		return 1
+	}
+	return 0
+}
+
+func initArgs(o Options, path string) []string {
+	uid, gid := -1, -1
+	if o.Credential != nil {
+		uid, gid = int(o.Credential.Uid), int(o.Credential.Gid)
+	}
+	return []string{InitArg, o.Dir, strconv.Itoa(uid), strconv.Itoa(gid), "--", path}
+}
+
+func parseInitArgs(args []string) (dir string, uid, gid int, path string, _ error) {
+	if len(args) != 5 || args[3] != "--" {
+		return "", 0, 0, "", errInitUsage
+	}
+	uid, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", 0, 0, "", fmt.Errorf("uid: %w", err)
+	}
+	gid, err = strconv.Atoi(args[2])
+	if err != nil {
+		return "", 0, 0, "", fmt.Errorf("gid: %w", err)
+	}
+	return args[0], uid, gid, args[4], nil
+}
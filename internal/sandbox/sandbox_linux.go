@@ -0,0 +1,216 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// oPath is O_PATH, which the syscall package does not export.
+const oPath = 0x200000
+
+// Command returns a command that runs path inside a sandbox. The returned
+// command must not get its own Credential; use Options.Credential instead.
+func Command(ctx context.Context, path string, o Options) (*exec.Cmd, error) {
+	dir, err := filepath.Abs(o.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("abs %s: %w", o.Dir, err)
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", o.Dir, err)
+	}
+	o.Dir = dir
+
+	flags := uintptr(syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS)
+	if !o.Network {
+		flags |= syscall.CLONE_NEWNET
+	}
+	attr := &syscall.SysProcAttr{Cloneflags: flags, Pdeathsig: syscall.SIGKILL} //nolint:exhaustruct
+	if euid := os.Geteuid(); euid != 0 {
+		if o.Credential != nil {
+			return nil, fmt.Errorf("%w: switching users requires root", ErrUnavailable)
+		}
+		// Become root inside a user namespace so the init can mount.
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: euid, Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	}
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", initArgs(o, path)...)
+	cmd.Dir = dir
+	cmd.SysProcAttr = attr
+	return cmd, nil
+}
+
+// Explain turns a failure to start a sandboxed command into an error that
+// says why namespaces could not be created.
+func Explain(err error) error {
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EUSERS) {
+		return fmt.Errorf("%w: cannot create namespaces (user namespaces may be disabled: check kernel.unprivileged_userns_clone and user.max_user_namespaces, or run as root): %w", ErrUnavailable, err)
+	}
+	return err
+}
+
+func initAndExec(dir string, uid, gid int, path string) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+
+	// Keep a handle on the job dir: a tmpfs on /tmp may hide it.
+	fd, err := syscall.Open(dir, oPath|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", dir, err)
+	}
+	if err := syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("mount /tmp: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+	if err := syscall.Mount("/proc/self/fd/"+strconv.Itoa(fd), dir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind %s: %w", dir, err)
+	}
+	_ = syscall.Close(fd)
+
+	// Shared memory is a tmpfs of the host: give the build its own.
+	if err := syscall.Mount("tmpfs", "/dev/shm", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=1777"); err != nil && !errors.Is(err, syscall.ENOENT) {
+		return fmt.Errorf("mount /dev/shm: %w", err)
+	}
+	if err := remountReadOnly(dir); err != nil {
+		return err
+	}
+	if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mount /proc: %w", err)
+	}
+
+	if gid >= 0 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("setgroups: %w", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid: %w", err)
+		}
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid: %w", err)
+		}
+	}
+	if err := syscall.Chdir(dir); err != nil {
+		return fmt.Errorf("chdir %s: %w", dir, err)
+	}
+	env := append(os.Environ(), "TMPDIR=/tmp")
+	if err := syscall.Exec(path, []string{path}, env); err != nil { // #nosec G204 -- the build entrypoint is the point.
+		return fmt.Errorf("exec %s: %w", path, err)
+	}
+	return nil
+}
+
+// remountReadOnly makes every mount read-only except the writable job dir,
+// the private /tmp and /dev/shm, and /proc and /sys. Device nodes under /dev
+// stay usable: a read-only mount does not stop writes to devices.
+func remountReadOnly(dir string) error {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		if under(m.point, dir) || under(m.point, "/tmp") || under(m.point, "/dev/shm") || under(m.point, "/proc") || under(m.point, "/sys") {
+			continue
+		}
+		flags := uintptr(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY) | m.flags
+		if err := syscall.Mount("", m.point, "", flags, ""); err != nil {
+			if errors.Is(err, syscall.ENOENT) && m.point != "/" {
+				// A mount over a parent hides the mount point; the build
+				// cannot reach it either.
+				if _, err := os.Lstat(m.point); errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+			}
+			return fmt.Errorf("remount %s read-only: %w", m.point, err)
+		}
+	}
+	return nil
+}
+
+type mountInfo struct {
+	point string
+	flags uintptr
+}
+
+func readMountInfo() ([]mountInfo, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("open mountinfo: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var out []mountInfo
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		out = append(out, mountInfo{point: unescapeMountPath(fields[4]), flags: lockedFlags(fields[5])})
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read mountinfo: %w", err)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].point < out[j].point })
+	return out, nil
+}
+
+// lockedFlags returns per-mount flags that must be preserved on remount
+// (the kernel refuses to clear them inside a user namespace).
+func lockedFlags(opts string) uintptr {
+	var flags uintptr
+	for _, o := range strings.Split(opts, ",") {
+		switch o {
+		case "nosuid":
+			flags |= syscall.MS_NOSUID
+		case "nodev":
+			flags |= syscall.MS_NODEV
+		case "noexec":
+			flags |= syscall.MS_NOEXEC
+		case "noatime":
+			flags |= syscall.MS_NOATIME
+		case "nodiratime":
+			flags |= syscall.MS_NODIRATIME
+		case "relatime":
+			flags |= syscall.MS_RELATIME
+		}
+	}
+	return flags
+}
+
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func under(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
@@ -0,0 +1,81 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The test binary doubles as the sandbox init, like cronctl does.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == InitArg {
+		os.Exit(Main(os.Args[2:]))
+	}
+	os.Exit(m.Run())
+}
+
+func TestCommand_IsolatesBuild(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	jobDir := filepath.Join(root, "job")
+	outside := filepath.Join(root, "outside")
+	if err := os.MkdirAll(jobDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	script := `#!/bin/sh
+set -eu
+echo built > out
+if touch /.cronctl-sandbox-probe 2>/dev/null; then echo "root fs writable"; exit 1; fi
+if touch /dev/.cronctl-sandbox-probe 2>/dev/null; then echo "/dev writable"; exit 1; fi
+if [ -d /dev/shm ]; then touch /dev/shm/.cronctl-sandbox-probe; fi
+# /tmp is a private tmpfs: this must not reach the host.
+mkdir -p "$(dirname ` + outside + `)" && touch ` + outside + `
+echo "pid=$$"
+grep -c : /proc/net/dev
+`
+	entry := filepath.Join(jobDir, "build.sh")
+	if err := os.WriteFile(entry, []byte(script), 0o755); err != nil {
+		t.Fatalf("write build.sh: %v", err)
+	}
+
+	cmd, err := Command(context.Background(), entry, Options{Dir: jobDir})
+	if err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Start(); err != nil {
+		if err := Explain(err); errors.Is(err, ErrUnavailable) {
+			t.Skipf("namespaces unavailable: %v", err)
+		}
+		t.Fatalf("Start: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Wait: %v\nstdout: %s\nstderr: %s", err, stdout.String(), stderr.String())
+	}
+
+	if b, err := os.ReadFile(filepath.Join(jobDir, "out")); err != nil || string(b) != "built\n" {
+		t.Fatalf("job dir output = %q, %v", b, err)
+	}
+	if _, err := os.Stat(outside); !os.IsNotExist(err) {
+		t.Fatalf("expected private /tmp, stat: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove("/.cronctl-sandbox-probe") })
+	t.Cleanup(func() { _ = os.Remove("/dev/.cronctl-sandbox-probe") })
+	if _, err := os.Stat("/dev/shm/.cronctl-sandbox-probe"); !os.IsNotExist(err) {
+		_ = os.Remove("/dev/shm/.cronctl-sandbox-probe")
+		t.Fatalf("expected private /dev/shm, stat: %v", err)
+	}
+	lines := strings.Fields(stdout.String())
+	if len(lines) != 2 || lines[0] != "pid=1" {
+		t.Fatalf("unexpected output: %q", stdout.String())
+	}
+	// Only the loopback interface exists in a fresh network namespace.
+	if lines[1] != "1" {
+		t.Fatalf("expected only loopback, got %s interfaces", lines[1])
+	}
+}
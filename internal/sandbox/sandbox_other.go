@@ -0,0 +1,24 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// Command is only supported on Linux.
+func Command(_ context.Context, _ string, _ Options) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("%w: namespaces are not supported on %s", ErrUnavailable, runtime.GOOS)
+}
+
+func initAndExec(_ string, _, _ int, _ string) error {
+	return fmt.Errorf("%w: namespaces are not supported on %s", ErrUnavailable, runtime.GOOS)
+}
+
+// Explain returns err unchanged on platforms without sandbox support.
+func Explain(err error) error {
+	return err
+}
@@ -0,0 +1,145 @@
+// Package secrets handles age-encrypted env files committed next to jobs. It
+// uses the external age binary; nothing leaves the machine.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Identity is the age identity hosts decrypt job secrets with.
+	Identity = "/etc/cronctl/age.key"
+	// DefaultFile is the encrypted file secrets edit creates in a job dir.
+	DefaultFile = "secrets.env.age"
+)
+
+var (
+	ErrNoAge        = errors.New("age not found in PATH (needed for encrypted secrets)")
+	ErrNoRecipients = errors.New("no secrets.recipients in cronctl.yaml")
+
+	errInvalidKey   = errors.New("invalid env key")
+	errInvalidValue = errors.New("value must be a single line")
+
+	keyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// DeployedPath returns where sync puts the decrypted secrets of a job whose
+// root-owned secrets dir is dir, outside the payload the job user owns.
+func DeployedPath(dir string) string {
+	return filepath.Join(dir, "decrypted.env")
+}
+
+// Decrypt decrypts the age file at path with the identity file.
+func Decrypt(ctx context.Context, path, identity string) ([]byte, error) {
+	f, err := os.Open(path) // #nosec G304 -- the caller picks the file to decrypt.
+	if err != nil {
+		return nil, fmt.Errorf("age: decrypt: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	plain, err := DecryptReader(ctx, f, identity)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plain, nil
+}
+
+// DecryptReader decrypts the age file read from r with the identity file.
+func DecryptReader(ctx context.Context, r io.Reader, identity string) ([]byte, error) {
+	if _, err := exec.LookPath("age"); err != nil {
+		return nil, ErrNoAge
+	}
+	// #nosec G204 -- the identity is an argument, not a shell word.
+	cmd := exec.CommandContext(ctx, "age", "--decrypt", "--identity", identity)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = r, &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("age: decrypt: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// Encrypt encrypts plaintext to recipients into the ASCII-armored age file at
+// path, replacing it atomically.
+func Encrypt(ctx context.Context, plaintext []byte, recipients []string, path string) error {
+	if len(recipients) == 0 {
+		return ErrNoRecipients
+	}
+	if _, err := exec.LookPath("age"); err != nil {
+		return ErrNoAge
+	}
+	args := []string{"--encrypt", "--armor"}
+	for _, r := range recipients {
+		args = append(args, "--recipient", r)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cronctl-secrets-")
+	if err != nil {
+		return fmt.Errorf("create temp in %s: %w", filepath.Dir(path), err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	defer func() { _ = tmp.Close() }()
+
+	cmd := exec.CommandContext(ctx, "age", args...) // #nosec G204 -- recipients are arguments, not shell words.
+	cmd.Stdin = bytes.NewReader(plaintext)
+	var stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = tmp, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("age: encrypt: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		return fmt.Errorf("chmod %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename %s -> %s: %w", tmp.Name(), path, err)
+	}
+	return nil
+}
+
+// Set returns env file content with key set to value: the last line
+// assigning key is replaced, or a line is appended.
+func Set(env []byte, key, value string) ([]byte, error) {
+	if !keyRe.MatchString(key) {
+		return nil, fmt.Errorf("%w: %q", errInvalidKey, key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return nil, errInvalidValue
+	}
+	line := key + "=" + quote(value)
+	lines := strings.Split(strings.TrimSuffix(string(env), "\n"), "\n")
+	if len(env) == 0 {
+		lines = nil
+	}
+	for i := len(lines) - 1; i >= 0; i-- {
+		k, _, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(lines[i]), "export "), "=")
+		if ok && strings.TrimSpace(k) == key {
+			lines[i] = line
+			return []byte(strings.Join(lines, "\n") + "\n"), nil
+		}
+	}
+	return []byte(strings.Join(append(lines, line), "\n") + "\n"), nil
+}
+
+// quote quotes value if reading it back (see runner.ParseEnvFile) would
+// otherwise change it.
+func quote(value string) string {
+	trimmed := strings.TrimSpace(value) != value
+	quoted := len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0]
+	if !trimmed && !quoted {
+		return value
+	}
+	if !strings.Contains(value, "'") {
+		return "'" + value + "'"
+	}
+	return `"` + value + `"`
+}
@@ -0,0 +1,69 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		env, key, value, want string
+	}{
+		{"", "TOKEN", "abc", "TOKEN=abc\n"},
+		{"# db\nDB_USER=app\n", "DB_PASSWORD", "s3cr=t", "# db\nDB_USER=app\nDB_PASSWORD=s3cr=t\n"},
+		{"A=1\nexport B=2\nC=3", "B", "x", "A=1\nB=x\nC=3\n"},
+		{"A=1\n", "A", " padded ", "A=' padded '\n"},
+		{"A=1\n", "A", "'it's'", "A=\"'it's'\"\n"},
+	}
+	for _, tt := range tests {
+		got, err := Set([]byte(tt.env), tt.key, tt.value)
+		if err != nil || string(got) != tt.want {
+			t.Fatalf("Set(%q, %s, %q) = %q, %v; want %q", tt.env, tt.key, tt.value, got, err, tt.want)
+		}
+	}
+	if _, err := Set(nil, "1BAD", "x"); !errors.Is(err, errInvalidKey) {
+		t.Fatalf("Set(bad key) = %v, want %v", err, errInvalidKey)
+	}
+	if _, err := Set(nil, "A", "two\nlines"); !errors.Is(err, errInvalidValue) {
+		t.Fatalf("Set(multi-line) = %v, want %v", err, errInvalidValue)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("age-keygen"); err != nil {
+		t.Skip("age not installed")
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	identity := filepath.Join(dir, "age.key")
+	out, err := exec.CommandContext(ctx, "age-keygen", "-o", identity).CombinedOutput()
+	if err != nil {
+		t.Fatalf("age-keygen: %v: %s", err, out)
+	}
+	_, recipient, ok := strings.Cut(strings.TrimSpace(string(out)), "Public key: ")
+	if !ok {
+		t.Fatalf("age-keygen output %q has no public key", out)
+	}
+
+	file := filepath.Join(dir, DefaultFile)
+	if err := Encrypt(ctx, []byte("TOKEN=abc\n"), []string{recipient}, file); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(file); err != nil || strings.Contains(string(b), "abc") {
+		t.Fatalf("encrypted file = %q, %v", b, err)
+	}
+	plain, err := Decrypt(ctx, file, identity)
+	if err != nil || string(plain) != "TOKEN=abc\n" {
+		t.Fatalf("Decrypt() = %q, %v", plain, err)
+	}
+	if err := Encrypt(ctx, nil, nil, file); !errors.Is(err, ErrNoRecipients) {
+		t.Fatalf("Encrypt(no recipients) = %v, want %v", err, ErrNoRecipients)
+	}
+}
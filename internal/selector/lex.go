@@ -0,0 +1,111 @@
+package selector
+
+import (
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+	tokEq
+	tokNeq
+)
+
+type token struct {
+	kind tokenKind
+	// pos is the byte offset of the token in the expression.
+	pos  int
+	text string
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokWord:
+		return strconv.Quote(t.text)
+	case tokAnd, tokOr, tokNot, tokLParen, tokRParen, tokEq, tokNeq:
+	}
+	return t.text
+}
+
+// isSpecial reports whether c ends an unquoted word.
+func isSpecial(c byte) bool {
+	return strings.IndexByte(" \t\r\n()=!&|\"'", c) >= 0
+}
+
+func lex(expr string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		two := ""
+		if i+1 < len(expr) {
+			two = expr[i : i+2]
+		}
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			toks = append(toks, token{kind: tokLParen, pos: i, text: "("})
+			i++
+		case c == ')':
+			toks = append(toks, token{kind: tokRParen, pos: i, text: ")"})
+			i++
+		case two == "&&":
+			toks = append(toks, token{kind: tokAnd, pos: i, text: "&&"})
+			i += 2
+		case two == "||":
+			toks = append(toks, token{kind: tokOr, pos: i, text: "||"})
+			i += 2
+		case two == "!=":
+			toks = append(toks, token{kind: tokNeq, pos: i, text: "!="})
+			i += 2
+		case c == '!':
+			toks = append(toks, token{kind: tokNot, pos: i, text: "!"})
+			i++
+		case c == '=':
+			toks = append(toks, token{kind: tokEq, pos: i, text: "="})
+			i++
+		case c == '&':
+			return nil, &SyntaxError{Expr: expr, Pos: i, Msg: "use && or AND"}
+		case c == '|':
+			return nil, &SyntaxError{Expr: expr, Pos: i, Msg: "use || or OR"}
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, &SyntaxError{Expr: expr, Pos: i, Msg: "unterminated quote"}
+			}
+			toks = append(toks, token{kind: tokWord, pos: i, text: expr[i+1 : i+1+end]})
+			i += end + 2
+		default:
+			start := i
+			for i < len(expr) && !isSpecial(expr[i]) {
+				i++
+			}
+			toks = append(toks, keyword(start, expr[start:i]))
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(expr), text: ""}), nil
+}
+
+// keyword turns the unquoted words AND, OR and NOT (in any case) into
+// operators. Quote them to match a tag with that name.
+func keyword(pos int, word string) token {
+	switch strings.ToUpper(word) {
+	case "AND":
+		return token{kind: tokAnd, pos: pos, text: word}
+	case "OR":
+		return token{kind: tokOr, pos: pos, text: word}
+	case "NOT":
+		return token{kind: tokNot, pos: pos, text: word}
+	}
+	return token{kind: tokWord, pos: pos, text: word}
+}
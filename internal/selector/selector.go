@@ -0,0 +1,258 @@
+// Package selector parses and evaluates job selection expressions such as
+// "prod AND db AND NOT legacy" or "(eu OR us) AND user=postgres".
+//
+// A bare word matches a job tag and may be a glob (team-*). A field=glob term
+// matches the tag, id, name, user or enabled field, and field!=glob negates it.
+// Terms combine with AND, OR and NOT (also &&, || and !) and parentheses; NOT
+// binds tightest and AND binds tighter than OR. Values with spaces can be
+// quoted.
+package selector
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+)
+
+var ErrSyntax = errors.New("syntax error")
+
+// SyntaxError describes where an expression fails to parse. Its message
+// repeats the expression with a caret under the offending position.
+type SyntaxError struct {
+	Expr string
+	// Pos is the byte offset of the error in Expr.
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	col := utf8.RuneCountInString(e.Expr[:e.Pos])
+	return fmt.Sprintf("%s at column %d: %s\n  %s\n  %s^", ErrSyntax, col+1, e.Msg, e.Expr, strings.Repeat(" ", col))
+}
+
+func (e *SyntaxError) Unwrap() error { return ErrSyntax }
+
+// Selector is a parsed expression. The zero Selector matches every job.
+type Selector struct {
+	expr string
+	root node
+}
+
+// Parse parses expr. An empty expression matches every job.
+func Parse(expr string) (Selector, error) {
+	toks, err := lex(expr)
+	if err != nil {
+		return Selector{}, err
+	}
+	if toks[0].kind == tokEOF {
+		return Selector{expr: "", root: nil}, nil
+	}
+	p := &parser{expr: expr, toks: toks, pos: 0}
+	root, err := p.parseOr()
+	if err != nil {
+		return Selector{}, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		if t.kind == tokRParen {
+			return Selector{}, p.errorf(t, "unbalanced )")
+		}
+		return Selector{}, p.errorf(t, "expected AND or OR before %s", t)
+	}
+	return Selector{expr: expr, root: root}, nil
+}
+
+// UnmarshalText parses a command-line flag value.
+func (s *Selector) UnmarshalText(text []byte) error {
+	sel, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*s = sel
+	return nil
+}
+
+func (s Selector) String() string { return s.expr }
+
+// IsZero reports whether s matches every job.
+func (s Selector) IsZero() bool { return s.root == nil }
+
+// Match reports whether the job id with spec is selected.
+func (s Selector) Match(id string, spec job.Spec) bool {
+	if s.root == nil {
+		return true
+	}
+	return s.root.match(id, spec)
+}
+
+type node interface {
+	match(id string, spec job.Spec) bool
+}
+
+type andNode struct{ l, r node }
+
+func (n andNode) match(id string, spec job.Spec) bool {
+	return n.l.match(id, spec) && n.r.match(id, spec)
+}
+
+type orNode struct{ l, r node }
+
+func (n orNode) match(id string, spec job.Spec) bool {
+	return n.l.match(id, spec) || n.r.match(id, spec)
+}
+
+type notNode struct{ n node }
+
+func (n notNode) match(id string, spec job.Spec) bool { return !n.n.match(id, spec) }
+
+// fieldNode matches field against a glob. For tags, any tag may match.
+type fieldNode struct {
+	field, glob string
+}
+
+func (n fieldNode) match(id string, spec job.Spec) bool {
+	switch n.field {
+	case "tag":
+		return slices.ContainsFunc(spec.Tags, n.matches)
+	case "id":
+		return n.matches(id)
+	case "name":
+		return n.matches(spec.Name)
+	case "user":
+		return n.matches(spec.User)
+	case "enabled":
+		return n.matches(strconv.FormatBool(spec.Enabled))
+	}
+	return false
+}
+
+func (n fieldNode) matches(s string) bool {
+	ok, _ := path.Match(n.glob, s)
+	return ok
+}
+
+var fields = []string{"tag", "id", "name", "user", "enabled"} //nolint:gochecknoglobals
+
+type parser struct {
+	expr string
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return &SyntaxError{Expr: p.expr, Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parseOr parses: and { OR and }.
+func (p *parser) parseOr() (node, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l, r}
+	}
+	return l, nil
+}
+
+// parseAnd parses: not { AND not }.
+func (p *parser) parseAnd() (node, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.next()
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l, r}
+	}
+	return l, nil
+}
+
+// parseNot parses: NOT not | ( or ) | term.
+func (p *parser) parseNot() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNot:
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.kind != tokRParen {
+			if c.kind == tokEOF {
+				return nil, p.errorf(t, "unclosed (")
+			}
+			return nil, p.errorf(c, "expected ) before %s", c)
+		}
+		return n, nil
+	case tokWord:
+		return p.parseTerm(t)
+	case tokEOF:
+		return nil, p.errorf(t, "unexpected end of expression, expected a tag, field=value, NOT or (")
+	case tokAnd, tokOr, tokRParen, tokEq, tokNeq:
+	}
+	return nil, p.errorf(t, "unexpected %s, expected a tag, field=value, NOT or (", t)
+}
+
+// parseTerm parses: glob | field = glob | field != glob, after its first word.
+func (p *parser) parseTerm(word token) (node, error) {
+	op := p.peek()
+	if op.kind != tokEq && op.kind != tokNeq {
+		return p.fieldNode("tag", word)
+	}
+	p.next()
+	if !slices.Contains(fields, word.text) {
+		return nil, p.errorf(word, "unknown field %q (want one of %s)", word.text, strings.Join(fields, ", "))
+	}
+	val := p.next()
+	if val.kind != tokWord {
+		return nil, p.errorf(val, "expected a value after %s%s", word.text, op.text)
+	}
+	n, err := p.fieldNode(word.text, val)
+	if err != nil {
+		return nil, err
+	}
+	if op.kind == tokNeq {
+		return notNode{n}, nil
+	}
+	return n, nil
+}
+
+func (p *parser) fieldNode(field string, val token) (node, error) {
+	if _, err := path.Match(val.text, ""); err != nil {
+		return nil, p.errorf(val, "bad pattern %q", val.text)
+	}
+	if field == "enabled" && val.text != "true" && val.text != "false" {
+		return nil, p.errorf(val, "enabled must be true or false, got %q", val.text)
+	}
+	return fieldNode{field: field, glob: val.text}, nil
+}
@@ -0,0 +1,106 @@
+package selector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+)
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+	jobs := map[string]job.Spec{
+		"pg-backup":  {Name: "Nightly backup", Enabled: true, User: "postgres", Tags: []string{"prod", "db", "eu", "backup"}},
+		"old-backup": {Name: "old", Enabled: false, User: "root", Tags: []string{"prod", "db", "legacy", "backup"}},
+		"us-report":  {Name: "report", Enabled: true, User: "app", Tags: []string{"us", "team-bi"}},
+		"untagged":   {Name: "untagged", Enabled: true, User: "root"},
+	}
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"", []string{"old-backup", "pg-backup", "untagged", "us-report"}},
+		{"prod AND db AND NOT legacy", []string{"pg-backup"}},
+		{"prod && db && !legacy", []string{"pg-backup"}},
+		{"(eu OR us) AND backup", []string{"pg-backup"}},
+		{"eu OR us AND backup", []string{"pg-backup"}},
+		{"(eu || us) and not backup", []string{"us-report"}},
+		{"team-*", []string{"us-report"}},
+		{"tag=team-*", []string{"us-report"}},
+		{"user=postgres", []string{"pg-backup"}},
+		{"user!=root", []string{"pg-backup", "us-report"}},
+		{"enabled=false", []string{"old-backup"}},
+		{"id=*-backup", []string{"old-backup", "pg-backup"}},
+		{`name="Nightly *"`, []string{"pg-backup"}},
+		{"NOT tag=*", []string{"untagged"}},
+		{"NOT NOT db", []string{"old-backup", "pg-backup"}},
+		{"'and' OR us", []string{"us-report"}},
+	}
+	for _, tt := range tests {
+		sel, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		var got []string
+		for _, id := range []string{"old-backup", "pg-backup", "untagged", "us-report"} {
+			if sel.Match(id, jobs[id]) {
+				got = append(got, id)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%q selected %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		expr, msg string
+		col       int
+	}{
+		{"prod AND", "unexpected end of expression", 9},
+		{"prod db", `expected AND or OR before "db"`, 6},
+		{"(eu OR us", "unclosed (", 1},
+		{"eu OR us)", "unbalanced )", 9},
+		{"prod AND OR db", "unexpected OR", 10},
+		{"owner=bob", `unknown field "owner"`, 1},
+		{"user=", "expected a value after user=", 6},
+		{"enabled=yes", "enabled must be true or false", 9},
+		{"prod & db", "use && or AND", 6},
+		{"[a-", "bad pattern", 1},
+		{`name="x`, "unterminated quote", 6},
+		{"()", "unexpected )", 2},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		var serr *SyntaxError
+		if !errors.As(err, &serr) || !errors.Is(err, ErrSyntax) {
+			t.Fatalf("Parse(%q): want a SyntaxError, got %v", tt.expr, err)
+		}
+		if !strings.Contains(serr.Msg, tt.msg) || serr.Pos+1 != tt.col {
+			t.Errorf("Parse(%q) = %q at column %d, want %q at column %d", tt.expr, serr.Msg, serr.Pos+1, tt.msg, tt.col)
+		}
+	}
+}
+
+func TestSyntaxErrorCaret(t *testing.T) {
+	t.Parallel()
+	_, err := Parse("prod AND (db OR")
+	want := "syntax error at column 16: unexpected end of expression, expected a tag, field=value, NOT or (\n  prod AND (db OR\n                 ^"
+	if err == nil || err.Error() != want {
+		t.Fatalf("error = %q, want %q", err, want)
+	}
+}
+
+func TestUnmarshalText(t *testing.T) {
+	t.Parallel()
+	var sel Selector
+	if err := sel.UnmarshalText([]byte("prod")); err != nil || sel.String() != "prod" || sel.IsZero() {
+		t.Fatalf("UnmarshalText(prod) = %v, %q", err, sel)
+	}
+	if err := sel.UnmarshalText([]byte("prod AND")); !errors.Is(err, ErrSyntax) {
+		t.Fatalf("UnmarshalText(prod AND) = %v, want ErrSyntax", err)
+	}
+}
@@ -0,0 +1,210 @@
+// Package server serves a read-only view of the jobs deployed on a host: a
+// JSON API and an embedded HTML dashboard.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yegor-usoltsev/cronctl/internal/build"
+	"github.com/yegor-usoltsev/cronctl/internal/cronexpr"
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/syncer"
+)
+
+//go:embed static
+var staticFS embed.FS
+
+// Options configures Collect and Handler.
+type Options struct {
+	// TargetDir holds the deployed payloads, CronDir the cron files.
+	TargetDir string
+	CronDir   string
+	// Token, if set, is required as a bearer token by the API.
+	Token []byte
+}
+
+// Job is the deployed state of a job.
+type Job struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Enabled   bool       `json:"enabled"`
+	Tags      []string   `json:"tags"`
+	User      string     `json:"user"`
+	Schedules []Schedule `json:"schedules"`
+	// Commit is the source commit recorded in the cron file, if any.
+	Commit string `json:"commit,omitempty"`
+	Build  *Build `json:"build,omitempty"`
+	// Cron is the drift state of the cron file.
+	Cron  syncer.CronState `json:"cron,omitempty"`
+	Error string           `json:"error,omitempty"`
+}
+
+// Schedule is a schedule entry and when it fires next.
+type Schedule struct {
+	Cron    string    `json:"cron"`
+	Args    []string  `json:"args,omitempty"`
+	NextRun time.Time `json:"next_run,omitzero"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Build is the result of the last build of the deployed payload.
+type Build struct {
+	OK         bool      `json:"ok"`
+	ExitCode   int       `json:"exit_code"`
+	BuiltAt    time.Time `json:"built_at"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// Collect returns the jobs deployed in opts.TargetDir.
+func Collect(ctx context.Context, opts Options, now time.Time) ([]Job, error) {
+	jobs, err := job.Discover(ctx, opts.TargetDir)
+	if err != nil {
+		return nil, fmt.Errorf("discover jobs: %w", err)
+	}
+	out := make([]Job, 0, len(jobs))
+	for _, j := range jobs {
+		out = append(out, collectJob(opts, j, now))
+	}
+	return out, nil
+}
+
+func collectJob(opts Options, j job.Job, now time.Time) Job {
+	tags := j.Spec.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	res := Job{
+		ID: j.ID, Name: j.Spec.Name, Enabled: j.Spec.Enabled, Tags: tags, User: j.Spec.User,
+		Schedules: make([]Schedule, 0, len(j.Spec.Schedule)),
+		Commit:    "", Build: nil, Cron: "", Error: "",
+	}
+	for _, item := range j.Spec.Schedule {
+		s := Schedule{Cron: item.Cron, Args: item.Args, NextRun: time.Time{}, Error: ""}
+		if expr, err := cronexpr.Parse(item.Cron); err != nil {
+			s.Error = err.Error()
+		} else if j.Spec.Enabled {
+			s.NextRun = expr.Next(now)
+		}
+		res.Schedules = append(res.Schedules, s)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(opts.CronDir, "cronctl-"+j.ID)); err == nil {
+		res.Commit = syncer.SourceCommit(data)
+	}
+	var syncOpts syncer.Options
+	syncOpts.CronDir, syncOpts.TargetDir, syncOpts.SourceCommit = opts.CronDir, opts.TargetDir, res.Commit
+	state, err := syncer.CheckCron(syncOpts, j)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	res.Cron = state
+
+	if j.Spec.Build.Enabled {
+		if st, ok := build.ReadState(build.StateFilePath(j.Dir)); ok {
+			res.Build = &Build{OK: st.OK(), ExitCode: st.ExitCode, BuiltAt: st.BuiltAt, DurationMS: st.DurationMS}
+		}
+	}
+	return res
+}
+
+// Handler serves the dashboard at / and the API at /api/jobs and
+// /api/jobs/<id>.
+func Handler(opts Options) http.Handler {
+	mux := http.NewServeMux()
+	static, _ := fs.Sub(staticFS, "static")
+	mux.Handle("GET /", http.FileServerFS(static))
+	mux.Handle("GET /api/jobs", requireToken(opts.Token, func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := Collect(r.Context(), opts, time.Now())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, jobs)
+	}))
+	mux.Handle("GET /api/jobs/{id}", requireToken(opts.Token, func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := Collect(r.Context(), opts, time.Now())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		for _, j := range jobs {
+			if j.ID == r.PathValue("id") {
+				writeJSON(w, http.StatusOK, j)
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+	}))
+	return mux
+}
+
+func requireToken(token []byte, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(token) > 0 {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), token) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cronctl"`)
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+				return
+			}
+		}
+		next(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// IsLoopback reports whether the listen address addr only accepts local
+// connections.
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ListenAndServe serves h on addr until ctx is canceled.
+func ListenAndServe(ctx context.Context, addr string, h http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	log.Printf("serve: listening on http://%s", ln.Addr())
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("serve: %w", err)
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
+}
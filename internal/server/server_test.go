@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yegor-usoltsev/cronctl/internal/build"
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/syncer"
+
+	"gopkg.in/yaml.v3"
+)
+
+const commit = "0123456789abcdef0123456789abcdef01234567"
+
+// deploy lays out a deployed job like syncer.Sync would.
+func deploy(t *testing.T, opts Options, id, spec string) {
+	t.Helper()
+	dir := filepath.Join(opts.TargetDir, id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "job.yaml"), []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	j := job.New(id, dir, filepath.Join(dir, "job.yaml"), []byte(spec))
+	if err := yaml.Unmarshal([]byte(spec), &j.Spec); err != nil {
+		t.Fatal(err)
+	}
+	if !j.Spec.Enabled || len(j.Spec.Schedule) == 0 {
+		return
+	}
+	data, err := syncer.RenderCron(j, dir, commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(opts.CronDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(opts.CronDir, "cronctl-"+id), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(t *testing.T, h http.Handler, path, token string, v any) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s: decode %q: %v", path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+	tmp := t.TempDir()
+	opts := Options{TargetDir: filepath.Join(tmp, "opt"), CronDir: filepath.Join(tmp, "cron.d"), Token: []byte("t0ken")}
+
+	deploy(t, opts, "backup", "name: Backup\nenabled: true\nuser: root\ntags: [prod]\nbuild:\n  enabled: true\nrun:\n  entrypoint: run.sh\nschedule:\n  - cron: \"0 3 * * *\"\n")
+	deploy(t, opts, "report", "name: report\nenabled: true\nuser: app\nrun:\n  entrypoint: run.sh\nschedule:\n  - cron: \"*/5 * * * *\"\n")
+	deploy(t, opts, "old", "name: old\nenabled: false\nuser: app\nrun:\n  entrypoint: run.sh\nschedule:\n  - cron: \"0 0 * * *\"\n")
+	st := build.State{Version: build.StateVersion, Hash: "h", ExitCode: 0, DurationMS: 1500, BuiltAt: time.Now().UTC()}
+	if err := build.WriteState(build.StateFilePath(filepath.Join(opts.TargetDir, "backup")), st); err != nil {
+		t.Fatal(err)
+	}
+	// report's cron file was edited by hand.
+	if err := os.WriteFile(filepath.Join(opts.CronDir, "cronctl-report"), []byte("* * * * * root true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := Handler(opts)
+	if code := get(t, h, "/api/jobs", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("GET /api/jobs without token: code = %d, want 401", code)
+	}
+	if code := get(t, h, "/api/jobs", "wrong", nil); code != http.StatusUnauthorized {
+		t.Fatalf("GET /api/jobs with wrong token: code = %d, want 401", code)
+	}
+
+	var jobs []Job
+	if code := get(t, h, "/api/jobs", "t0ken", &jobs); code != http.StatusOK {
+		t.Fatalf("GET /api/jobs: code = %d", code)
+	}
+	if len(jobs) != 3 {
+		t.Fatalf("GET /api/jobs returned %d jobs, want 3", len(jobs))
+	}
+	byID := make(map[string]Job)
+	for _, j := range jobs {
+		byID[j.ID] = j
+	}
+
+	backup := byID["backup"]
+	if backup.Commit != commit || backup.Cron != syncer.CronOK || backup.Build == nil || !backup.Build.OK {
+		t.Fatalf("backup = %+v, want commit, cron ok and a successful build", backup)
+	}
+	if len(backup.Schedules) != 1 || backup.Schedules[0].NextRun.IsZero() || backup.Schedules[0].NextRun.Minute() != 0 {
+		t.Fatalf("backup schedules = %+v, want a next run on the hour", backup.Schedules)
+	}
+	if got := byID["report"].Cron; got != syncer.CronModified {
+		t.Fatalf("report cron = %q, want modified", got)
+	}
+	if old := byID["old"]; old.Cron != syncer.CronOK || !old.Schedules[0].NextRun.IsZero() {
+		t.Fatalf("old = %+v, want cron ok and no next run", old)
+	}
+
+	var one Job
+	if code := get(t, h, "/api/jobs/backup", "t0ken", &one); code != http.StatusOK || one.Name != "Backup" {
+		t.Fatalf("GET /api/jobs/backup: code = %d, job = %+v", code, one)
+	}
+	if code := get(t, h, "/api/jobs/nope", "t0ken", nil); code != http.StatusNotFound {
+		t.Fatalf("GET /api/jobs/nope: code = %d, want 404", code)
+	}
+
+	// The dashboard itself holds no data and needs no token.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<title>cronctl</title>") {
+		t.Fatalf("GET /: code = %d", rec.Code)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	t.Parallel()
+	for addr, want := range map[string]bool{
+		"127.0.0.1:9798": true,
+		"[::1]:9798":     true,
+		"localhost:9798": true,
+		":9798":          false,
+		"0.0.0.0:9798":   false,
+		"10.0.0.5:9798":  false,
+	} {
+		if got := IsLoopback(addr); got != want {
+			t.Errorf("IsLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
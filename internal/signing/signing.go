@@ -0,0 +1,137 @@
+// Package signing signs and verifies deploy manifests with ed25519 keys.
+//
+// Keys are PEM files as written by
+//
+//	openssl genpkey -algorithm ed25519 -out ci.key
+//	openssl pkey -in ci.key -pubout -out trusted.pub
+//
+// A signature is stored next to the signed file as <file>.sig, base64
+// encoded.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrBadSignature = errors.New("signature verification failed")
+	ErrNoSignature  = errors.New("missing signature")
+	errNotEd25519   = errors.New("not an ed25519 key")
+	errNoKeys       = errors.New("no public keys found")
+)
+
+// SigPath returns the signature path for path.
+func SigPath(path string) string {
+	return path + ".sig"
+}
+
+// LoadPrivateKey reads a PKCS#8 PEM ed25519 private key.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: %w", path, errNotEd25519)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse key %s: %w", path, err)
+	}
+	priv, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", path, errNotEd25519)
+	}
+	return priv, nil
+}
+
+// LoadPublicKeys reads one or more PKIX PEM ed25519 public keys from path.
+// Any of them is accepted when verifying, which allows key rotation.
+func LoadPublicKeys(path string) ([]ed25519.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	var keys []ed25519.PublicKey
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse key %s: %w", path, err)
+		}
+		pub, ok := k.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", path, errNotEd25519)
+		}
+		keys = append(keys, pub)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: %w", path, errNoKeys)
+	}
+	return keys, nil
+}
+
+// SignFile signs the contents of path and writes the signature to
+// SigPath(path).
+func SignFile(path string, key ed25519.PrivateKey) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, b))
+	// #nosec G306 -- signatures are public.
+	if err := os.WriteFile(SigPath(path), []byte(sig+"\n"), 0o644); err != nil {
+		return fmt.Errorf("write signature: %w", err)
+	}
+	return nil
+}
+
+// VerifyFile checks SigPath(path) against the contents of path using any of
+// keys.
+func VerifyFile(path string, keys []ed25519.PublicKey) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	return VerifyData(path, b, keys)
+}
+
+// VerifyData checks SigPath(path) against data, the contents of path read by
+// the caller, using any of keys.
+func VerifyData(path string, data []byte, keys []ed25519.PublicKey) error {
+	s, err := os.ReadFile(SigPath(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrNoSignature, SigPath(path))
+		}
+		return fmt.Errorf("read signature: %w", err)
+	}
+	return Verify(path, data, s, keys)
+}
+
+// Verify checks the base64 signature sig of data, named name in errors,
+// using any of keys.
+func Verify(name string, data, sig []byte, keys []ed25519.PublicKey) error {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrBadSignature, SigPath(name), err)
+	}
+	for _, k := range keys {
+		if ed25519.Verify(k, data, raw) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrBadSignature, name)
+}
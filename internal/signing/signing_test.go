@@ -0,0 +1,96 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeKeys(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("marshal private key: %v", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	privPath := filepath.Join(dir, name+".key")
+	pubPath := filepath.Join(dir, name+".pub")
+	if err := os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	if err := os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o644); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return privPath, pubPath
+}
+
+func TestSignVerifyFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	privPath, pubPath := writeKeys(t, dir, "ci")
+	_, otherPub := writeKeys(t, dir, "other")
+	file := filepath.Join(dir, "manifest.json")
+	if err := os.WriteFile(file, []byte(`{"version":1}`), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	priv, err := LoadPrivateKey(privPath)
+	if err != nil {
+		t.Fatalf("LoadPrivateKey: %v", err)
+	}
+	keys, err := LoadPublicKeys(pubPath)
+	if err != nil {
+		t.Fatalf("LoadPublicKeys: %v", err)
+	}
+	if err := VerifyFile(file, keys); !errors.Is(err, ErrNoSignature) {
+		t.Fatalf("expected ErrNoSignature, got %v", err)
+	}
+	if err := SignFile(file, priv); err != nil {
+		t.Fatalf("SignFile: %v", err)
+	}
+	if err := VerifyFile(file, keys); err != nil {
+		t.Fatalf("VerifyFile: %v", err)
+	}
+
+	// Rotation: a file with several keys accepts any of them.
+	a, _ := os.ReadFile(otherPub)
+	b, _ := os.ReadFile(pubPath)
+	both := filepath.Join(dir, "trusted.pub")
+	if err := os.WriteFile(both, append(a, b...), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	trusted, err := LoadPublicKeys(both)
+	if err != nil || len(trusted) != 2 {
+		t.Fatalf("LoadPublicKeys: %d keys, %v", len(trusted), err)
+	}
+	if err := VerifyFile(file, trusted); err != nil {
+		t.Fatalf("VerifyFile with rotated keys: %v", err)
+	}
+
+	other, err := LoadPublicKeys(otherPub)
+	if err != nil {
+		t.Fatalf("LoadPublicKeys: %v", err)
+	}
+	if err := VerifyFile(file, other); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature for untrusted key, got %v", err)
+	}
+	if err := os.WriteFile(file, []byte(`{"version":2}`), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := VerifyFile(file, keys); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature for tampered file, got %v", err)
+	}
+}
@@ -4,41 +4,52 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
 	"syscall"
+	"time"
 
 	"github.com/yegor-usoltsev/cronctl/internal/build"
 	"github.com/yegor-usoltsev/cronctl/internal/job"
 )
 
-func runBuildIfNeeded(ctx context.Context, dryRun bool, jobID, jobDir, entrypoint string, force bool, runAsUser bool, uid, gid int) error {
+// runBuildIfNeeded builds the payload of j staged in jobDir unless cached. On
+// failure, the build log is kept in deployedDir (see preserveFailedBuild).
+func runBuildIfNeeded(ctx context.Context, opts Options, j job.Job, jobDir, deployedDir string, uid, gid int) error {
+	jobID, spec := j.ID, j.Spec.Build
+	entrypoint := spec.Entrypoint
 	if entrypoint == "" {
 		entrypoint = job.DefaultBuildEntrypoint
 	}
-	if dryRun {
+	runAsUser := opts.RunBuildAsJobUser
+	if opts.DryRun {
 		log.Printf("dry-run: build: %s: run %s", jobID, entrypoint)
 		return nil
 	}
-	statePath := filepath.Join(jobDir, ".cronctl", "filehash")
+	statePath := build.StateFilePath(jobDir)
 
-	curHash, err := build.InputsHash(ctx, jobDir)
+	cur, err := build.HashInputs(ctx, jobDir, j.RawYAML)
 	if err != nil {
 		return fmt.Errorf("hash inputs: %w", err)
 	}
 	prev, ok := build.ReadHash(statePath)
-	if !force && ok && prev == curHash {
+	if !opts.ForceBuild && ok && prev == cur.Hash {
 		log.Printf("build: %s: skipped (cache)", jobID)
+		if err := carryOverOutputs(deployedDir, jobDir); err != nil {
+			return fmt.Errorf("carry over outputs: %w", err)
+		}
 		return nil
 	}
+	if !opts.ForceBuild {
+		if st, ok := build.RestoreArtifact(ctx, opts.Artifacts, jobID, jobDir, j.RawYAML, cur); ok {
+			return writeBuildState(statePath, st, runAsUser, uid, gid)
+		}
+	}
 
-	cmdPath := filepath.Join(jobDir, entrypoint)
-	cmd := exec.CommandContext(ctx, cmdPath)
-	cmd.Dir = jobDir
+	var cred *syscall.Credential
 	if runAsUser {
 		if os.Geteuid() != 0 {
 			return errBuildNeedsRoot
@@ -51,34 +62,49 @@ func runBuildIfNeeded(ctx context.Context, dryRun bool, jobID, jobDir, entrypoin
 		if err != nil {
 			return fmt.Errorf("gid: %w", err)
 		}
-		cred := &syscall.Credential{Uid: uid32, Gid: gid32}      //nolint:exhaustruct
-		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred} //nolint:exhaustruct
+		cred = &syscall.Credential{Uid: uid32, Gid: gid32} //nolint:exhaustruct
+	}
+	var stream io.Writer
+	if opts.Verbose {
+		stream = os.Stderr
+	}
+	log.Printf("build: %s: running %s", jobID, entrypoint)
+	started := time.Now()
+	err = build.Exec(ctx, build.ExecOptions{JobID: jobID, Dir: jobDir, Entrypoint: entrypoint, Spec: spec, Credential: cred, Stream: stream})
+	if runAsUser {
+		_ = os.Chown(build.LogFilePath(jobDir), uid, gid)
 	}
-	out, err := cmd.CombinedOutput()
 	if err != nil {
-		msg := strings.TrimSpace(string(out))
-		if msg != "" {
-			return fmt.Errorf("run build: %s: %w: %s", cmdPath, err, msg)
-		}
-		var ee *exec.ExitError
+		var ee *build.ExecError
 		if errors.As(err, &ee) {
-			return fmt.Errorf("run build: %s: %w (exit %d)", cmdPath, err, ee.ExitCode())
+			if werr := writeBuildState(statePath, build.NewState(cur.Hash, cur, started, ee.Code), runAsUser, uid, gid); werr != nil {
+				log.Printf("build: %s: %v", jobID, werr)
+			}
+			preserveFailedBuild(ee, jobDir, deployedDir)
 		}
-		return fmt.Errorf("run build: %s: %w", cmdPath, err)
+		return fmt.Errorf("run build: %w", err)
 	}
 
-	newHash, err := build.InputsHash(ctx, jobDir)
+	after, err := build.HashInputs(ctx, jobDir, j.RawYAML)
 	if err != nil {
 		return fmt.Errorf("hash inputs after build: %w", err)
 	}
-	if err := build.WriteHash(statePath, newHash); err != nil {
-		return fmt.Errorf("write filehash: %w", err)
+	if err := writeBuildState(statePath, build.NewState(cur.Hash, after, started, 0), runAsUser, uid, gid); err != nil {
+		return err
 	}
-	if runAsUser {
+	build.StoreArtifact(ctx, opts.Artifacts, jobID, jobDir, cur)
+	log.Printf("build: %s: ok", jobID)
+	return nil
+}
+
+func writeBuildState(statePath string, st build.State, chown bool, uid, gid int) error {
+	if err := build.WriteState(statePath, st); err != nil {
+		return fmt.Errorf("write build state: %w", err)
+	}
+	if chown {
 		_ = os.Chown(filepath.Dir(statePath), uid, gid)
 		_ = os.Chown(statePath, uid, gid)
 	}
-	log.Printf("build: %s: ok", jobID)
 	return nil
 }
 
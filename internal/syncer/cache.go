@@ -1,34 +1,124 @@
 package syncer
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
-)
 
-func carryOverFilehash(dryRun bool, deployedDir, stagingDir string) error {
-	from := filepath.Join(deployedDir, ".cronctl", "filehash")
-	toDir := filepath.Join(stagingDir, ".cronctl")
-	to := filepath.Join(toDir, "filehash")
+	"github.com/yegor-usoltsev/cronctl/internal/build"
+	"github.com/yegor-usoltsev/cronctl/internal/payload"
+)
 
+// carryOverState copies the build state and log of the deployed payload into
+// the staging dir so unchanged jobs hit the build cache. Legacy filehash files
+// are carried over too and get migrated on the next build.
+func carryOverState(dryRun bool, deployedDir, stagingDir string) error {
+	fromDir := build.StateDir(deployedDir)
+	toDir := build.StateDir(stagingDir)
 	if dryRun {
-		log.Printf("dry-run: carry over cache %s -> %s", from, to)
+		log.Printf("dry-run: carry over cache %s -> %s", fromDir, toDir)
 		return nil
 	}
-	b, err := os.ReadFile(from)
-	if err != nil {
-		if os.IsNotExist(err) {
+	for _, name := range []string{filepath.Base(build.StateFilePath(deployedDir)), filepath.Base(build.LogFilePath(deployedDir)), "filehash"} {
+		from := filepath.Join(fromDir, name)
+		to := filepath.Join(toDir, name)
+		b, err := os.ReadFile(from)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("read %s: %w", from, err)
+		}
+		if err := os.MkdirAll(toDir, 0o755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", toDir, err)
+		}
+		// #nosec G306 -- this is non-secret cache metadata.
+		if err := os.WriteFile(to, b, 0o644); err != nil {
+			return fmt.Errorf("write %s: %w", to, err)
+		}
+	}
+	return nil
+}
+
+// carryOverOutputs copies files that exist in the deployed payload but not in
+// the staging dir. On a cache hit these are the outputs of the previous build
+// that do not count as inputs, e.g. binaries listed in the job's .gitignore.
+func carryOverOutputs(deployedDir, stagingDir string) error {
+	if _, err := os.Stat(deployedDir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err := filepath.WalkDir(deployedDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("walk %s: %w", path, err)
+		}
+		rel, err := filepath.Rel(deployedDir, path)
+		if err != nil {
+			return fmt.Errorf("rel %s: %w", path, err)
+		}
+		if rel == "." {
 			return nil
 		}
-		return fmt.Errorf("read %s: %w", from, err)
+		if filepath.ToSlash(rel) == ".cronctl" {
+			return filepath.SkipDir
+		}
+		outPath := filepath.Join(stagingDir, rel)
+		if _, err := os.Lstat(outPath); err == nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("info %s: %w", path, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", filepath.Dir(outPath), err)
+		}
+		switch mode := info.Mode(); {
+		case mode.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("readlink %s: %w", path, err)
+			}
+			if err := os.Symlink(link, outPath); err != nil {
+				return fmt.Errorf("symlink %s: %w", outPath, err)
+			}
+			return nil
+		case mode.IsRegular():
+			return payload.CopyFile(path, outPath, mode.Perm())
+		default:
+			return nil
+		}
+	}); err != nil {
+		return fmt.Errorf("walk %s: %w", deployedDir, err)
 	}
-	if err := os.MkdirAll(toDir, 0o755); err != nil {
-		return fmt.Errorf("mkdir %s: %w", toDir, err)
+	return nil
+}
+
+// preserveFailedBuild keeps the build log of a staging dir that is about to
+// be discarded as <target>/<id>/.cronctl/last-failed-build.log, next to the
+// payload that stays deployed; its build state is left alone. On a first
+// deploy there is no payload to keep it in. The error is updated to point at
+// the kept log.
+func preserveFailedBuild(ee *build.ExecError, stagingDir, targetPath string) {
+	ee.LogPath = ""
+	if _, err := os.Stat(targetPath); err != nil {
+		return
 	}
-	// #nosec G306 -- this is non-secret cache metadata.
+	b, err := os.ReadFile(build.LogFilePath(stagingDir))
+	if err != nil {
+		return
+	}
+	to := build.FailedLogFilePath(targetPath)
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		log.Printf("build: keep log: %v", err)
+		return
+	}
+	// #nosec G306 -- build logs are non-secret.
 	if err := os.WriteFile(to, b, 0o644); err != nil {
-		return fmt.Errorf("write %s: %w", to, err)
+		log.Printf("build: keep log: %v", err)
+		return
 	}
-	return nil
+	ee.LogPath = to
 }
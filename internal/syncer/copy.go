@@ -1,104 +1,105 @@
 package syncer
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
-	"io"
 	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
-	"strings"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/payload"
 )
 
 func copyJobDir(dryRun bool, src, dst string) error {
 	if dryRun {
 		return nil
 	}
-	if err := os.MkdirAll(dst, 0o755); err != nil {
-		return fmt.Errorf("mkdir %s: %w", dst, err)
-	}
-
-	if err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return fmt.Errorf("walk %s: %w", path, err)
-		}
-		rel, err := filepath.Rel(src, path)
-		if err != nil {
-			return fmt.Errorf("rel %s: %w", path, err)
-		}
-		if rel == "." {
-			return nil
-		}
-		// Security: Prevent path traversal attacks
-		if strings.Contains(rel, "..") {
-			return fmt.Errorf("%w: %s", errPathTraversal, rel)
-		}
-		relSlash := filepath.ToSlash(rel)
-		if relSlash == ".cronctl" || relSlash == ".git" || relSlash == ".DS_Store" {
-			if d.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-		if strings.HasPrefix(relSlash, ".cronctl/") || strings.HasPrefix(relSlash, ".git/") {
-			if d.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
+	return payload.Copy(src, dst, payload.Filter{})
+}
 
-		outPath := filepath.Join(dst, rel)
-		info, err := d.Info()
-		if err != nil {
-			return fmt.Errorf("info %s: %w", path, err)
-		}
+// filterPayload removes the files of the staged (and built) job dir that are
+// not part of the deployed payload.
+func filterPayload(dryRun bool, j job.Job, stagingDir string) error {
+	f, err := payload.Load(j.Dir, j.Spec.Payload)
+	if err != nil {
+		return fmt.Errorf("load payload filter: %w", err)
+	}
+	if dryRun {
+		log.Printf("dry-run: filter payload %s", stagingDir)
+		return nil
+	}
+	return payload.Prune(stagingDir, f)
+}
 
-		mode := info.Mode()
-		switch {
-		case mode.IsDir():
-			return os.MkdirAll(outPath, mode.Perm())
-		case mode.Type()&fs.ModeSymlink != 0:
-			link, err := os.Readlink(path)
-			if err != nil {
-				return fmt.Errorf("readlink %s: %w", path, err)
-			}
-			if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
-				return fmt.Errorf("mkdir %s: %w", filepath.Dir(outPath), err)
-			}
-			return os.Symlink(link, outPath)
-		case mode.IsRegular():
-			if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
-				return fmt.Errorf("mkdir %s: %w", filepath.Dir(outPath), err)
-			}
-			return copyFile(path, outPath, mode.Perm())
-		default:
-			// Skip devices, sockets, etc.
-			return nil
-		}
-	}); err != nil {
-		return fmt.Errorf("walk %s: %w", src, err)
+// writeSpec replaces the staged job.yaml with j.RawYAML if they differ, so the
+// deployed spec includes repo-wide defaults.
+func writeSpec(dryRun bool, j job.Job, stagingDir string) error {
+	if dryRun || len(j.RawYAML) == 0 {
+		return nil
+	}
+	path := filepath.Join(stagingDir, "job.yaml")
+	cur, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read staged spec: %w", err)
+	}
+	if bytes.Equal(cur, j.RawYAML) {
+		return nil
+	}
+	// #nosec G306 -- job.yaml is deployed world-readable.
+	if err := os.WriteFile(path, j.RawYAML, 0o644); err != nil {
+		return fmt.Errorf("write staged spec: %w", err)
 	}
 	return nil
 }
 
-func copyFile(src, dst string, perm fs.FileMode) error {
-	in, err := os.Open(src)
+// writeDisabledSpec replaces job.yaml in the kept payload of the disabled job
+// j with j.RawYAML, so the deployed spec shows it as disabled. The payload dir
+// belongs to the job user: the spec is written to a new file and renamed into
+// place, which never follows a symlink planted there.
+func writeDisabledSpec(dryRun bool, j job.Job, targetPath string) error {
+	if len(j.RawYAML) == 0 {
+		return nil
+	}
+	fi, err := os.Lstat(targetPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("open %s: %w", src, err)
+		return fmt.Errorf("stat deployed payload: %w", err)
 	}
-	defer func() { _ = in.Close() }()
-
-	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if !fi.IsDir() {
+		return nil
+	}
+	path := filepath.Join(targetPath, "job.yaml")
+	if fi, err := os.Lstat(path); err == nil && fi.Mode().IsRegular() {
+		if cur, err := os.ReadFile(path); err == nil && bytes.Equal(cur, j.RawYAML) {
+			return nil
+		}
+	}
+	if dryRun {
+		log.Printf("dry-run: write disabled spec %s", path)
+		return nil
+	}
+	f, err := os.CreateTemp(targetPath, ".job.yaml-*")
 	if err != nil {
-		return fmt.Errorf("create %s: %w", dst, err)
+		return fmt.Errorf("write deployed spec: %w", err)
 	}
-	defer func() { _ = out.Close() }()
-
-	if _, err := io.Copy(out, in); err != nil {
-		return fmt.Errorf("copy %s -> %s: %w", src, dst, err)
+	defer func() { _ = os.Remove(f.Name()) }()
+	_, err = f.Write(j.RawYAML)
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write deployed spec: %w", err)
 	}
-	if err := out.Close(); err != nil {
-		_ = os.Remove(dst) // Clean up failed file
-		return fmt.Errorf("close %s: %w", dst, err)
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("write deployed spec: %w", err)
 	}
 	return nil
 }
@@ -4,22 +4,44 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/runner"
+	"github.com/yegor-usoltsev/cronctl/internal/secrets"
 )
 
-var envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var (
+	envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 
-func writeCronFile(dryRun bool, cronPath string, j job.Job, targetPath string) error {
-	data, err := renderCron(j, targetPath)
-	if err != nil {
-		return err
+	pointerEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+	pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+)
+
+const sourceCommitHeader = "# Source commit: "
+
+// cronFile returns the cron file of j: the prerendered one for bundle
+// installs, otherwise rendered from the spec.
+func cronFile(opts Options, j job.Job, targetPath string) ([]byte, error) {
+	if opts.Bundle == nil {
+		return RenderCron(j, targetPath, opts.SourceCommit)
+	}
+	data, ok := opts.Bundle.CronFiles[j.ID]
+	if !ok {
+		return nil, errBundleNoCron
 	}
+	return data, nil
+}
+
+func writeCronFile(dryRun bool, cronPath string, data []byte) error {
 	if dryRun {
 		log.Printf("dry-run: write cron %s", cronPath)
 		return nil
@@ -36,50 +58,123 @@ func writeCronFile(dryRun bool, cronPath string, j job.Job, targetPath string) e
 	return nil
 }
 
-func renderCron(j job.Job, targetPath string) ([]byte, error) {
-	var buf bytes.Buffer
-	buf.WriteString("# Generated by cronctl. DO NOT EDIT.\n")
+// RenderError is a value of a job spec that RenderCron cannot write to a
+// cron file.
+type RenderError struct {
+	// Pointer is the JSON pointer of the value, e.g. /schedule/0/args/1.
+	Pointer string
+	Err     error
+}
 
-	keys := make([]string, 0, len(j.Spec.Env))
-	for k := range j.Spec.Env {
-		keys = append(keys, k)
+func (e *RenderError) Error() string {
+	return fieldName(e.Pointer) + ": " + e.Err.Error()
+}
+
+func (e *RenderError) Unwrap() error { return e.Err }
+
+// CheckRender returns every value of spec that keeps RenderCron from
+// rendering it, without touching the filesystem. Jobs without a schedule get
+// no cron file, so they have nothing to check.
+func CheckRender(spec job.Spec) []*RenderError {
+	if len(spec.Schedule) == 0 {
+		return nil
 	}
-	sort.Strings(keys)
-	for _, k := range keys {
-		if !envKeyRe.MatchString(k) {
-			return nil, fmt.Errorf("%w: %q", errInvalidEnvKey, k)
+	var errs []*RenderError
+	add := func(ptr string, err error) {
+		if err != nil {
+			errs = append(errs, &RenderError{Pointer: ptr, Err: err})
 		}
+	}
+	checkEnv := func(ptr string, env map[string]string, check func(string) error) {
+		for _, k := range slices.Sorted(maps.Keys(env)) {
+			kptr := ptr + "/" + pointerEscaper.Replace(k)
+			if !envKeyRe.MatchString(k) {
+				add(kptr, fmt.Errorf("%w: %q", errInvalidEnvKey, k))
+				continue
+			}
+			add(kptr, check(env[k]))
+		}
+	}
+
+	checkEnv("/env", spec.Env, CheckCronValue)
+	if strings.TrimSpace(spec.User) == "" {
+		add("/user", errUserEmpty)
+	} else {
+		add("/user", CheckCronUser(spec.User))
+	}
+	if ep := runEntrypoint(spec); !filepath.IsLocal(ep) {
+		add("/run/entrypoint", fmt.Errorf("%w: %s", errEntrypointNotLocal, ep))
+	} else {
+		add("/run/entrypoint", CheckCronCommand(ep))
+	}
+	for i, f := range spec.Secrets.EnvFiles {
+		add(fmt.Sprintf("/secrets/env_files/%d", i), CheckCronCommand(f))
+	}
+	for i, s := range spec.Schedule {
+		ptr := fmt.Sprintf("/schedule/%d", i)
+		if strings.TrimSpace(s.Cron) == "" {
+			add(ptr+"/cron", errScheduleCronEmpty)
+		} else {
+			add(ptr+"/cron", CheckCronValue(s.Cron))
+		}
+		for k, a := range s.Args {
+			add(fmt.Sprintf("%s/args/%d", ptr, k), CheckCronCommand(a))
+		}
+		checkEnv(ptr+"/env", s.Env, CheckCronCommand)
+	}
+	return errs
+}
+
+// RenderCron returns the /etc/cron.d file for j deployed at targetPath. A
+// non-empty commit is recorded in the header as the source of the job.
+func RenderCron(j job.Job, targetPath, commit string) ([]byte, error) {
+	if errs := CheckRender(j.Spec); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	if err := CheckCronCommand(targetPath); err != nil {
+		return nil, fmt.Errorf("target dir: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by cronctl. DO NOT EDIT.\n")
+	if commit != "" {
+		buf.WriteString(sourceCommitHeader + commit + "\n")
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(j.Spec.Env)) {
 		// Global env vars in cron files are written as: KEY=value (no shell escaping needed)
 		buf.WriteString(fmt.Sprintf("%s=%s\n", k, j.Spec.Env[k]))
 	}
 
 	user := strings.TrimSpace(j.Spec.User)
-	if user == "" {
-		return nil, errUserEmpty
+	cmd := shellEscape(filepath.Join(targetPath, runEntrypoint(j.Spec)))
+	cronUser := user
+	files := j.Spec.Secrets.EnvFiles
+	if j.Spec.Secrets.Encrypted != "" {
+		files = append(slices.Clip(files), secrets.DeployedPath(runner.JobSecretsDir(j.ID)))
 	}
-
-	runEntrypoint := strings.TrimSpace(j.Spec.Run.Entrypoint)
-	if runEntrypoint == "" {
-		runEntrypoint = job.DefaultRunEntrypoint
+	if len(files) > 0 {
+		// Secret env files are only readable by root or the job user, so
+		// cron starts the runner as root, which drops to the job user.
+		argv := []string{shellEscape(runner.Path), "exec", "--user", shellEscape(user), "--job", shellEscape(j.ID)}
+		for _, f := range files {
+			argv = append(argv, "--env-file", shellEscape(f))
+		}
+		cmd = strings.Join(argv, " ") + " -- " + cmd
+		cronUser = "root"
 	}
-	cmdPath := filepath.Join(targetPath, runEntrypoint)
 
 	for i, s := range j.Spec.Schedule {
-		cron := strings.TrimSpace(s.Cron)
-		if cron == "" {
-			return nil, fmt.Errorf("schedule[%d]: %w", i, errScheduleCronEmpty)
-		}
 		// Schedule-specific env vars (merged on top of global_env at runtime by cron)
 		prefix, err := renderEnvAssignments(s.Env)
 		if err != nil {
 			return nil, fmt.Errorf("schedule[%d]: %w", i, err)
 		}
 
-		args := s.Args
 		var argStr string
-		if len(args) > 0 {
-			parts := make([]string, 0, len(args))
-			for _, a := range args {
+		if len(s.Args) > 0 {
+			parts := make([]string, 0, len(s.Args))
+			for _, a := range s.Args {
 				parts = append(parts, shellEscape(a))
 			}
 			argStr = " " + strings.Join(parts, " ")
@@ -90,13 +185,54 @@ func renderCron(j job.Job, targetPath string) ([]byte, error) {
 			redirect = " >/dev/null 2>&1"
 		}
 
-		line := fmt.Sprintf("%s %s %s%s%s%s\n", cron, user, prefix, shellEscape(cmdPath), argStr, redirect)
+		// Cron turns an unescaped % in the command into a newline.
+		line := fmt.Sprintf("%s %s %s\n", strings.TrimSpace(s.Cron), cronUser, strings.ReplaceAll(prefix+cmd+argStr+redirect, "%", `\%`))
 		buf.WriteString(line)
 	}
 
 	return buf.Bytes(), nil
 }
 
+// runEntrypoint returns the run entrypoint of spec relative to the job dir.
+func runEntrypoint(spec job.Spec) string {
+	if ep := strings.TrimSpace(spec.Run.Entrypoint); ep != "" {
+		return ep
+	}
+	return job.DefaultRunEntrypoint
+}
+
+// fieldName returns the dotted name of the value at JSON pointer ptr, e.g.
+// schedule[0].args[1] for /schedule/0/args/1.
+func fieldName(ptr string) string {
+	var b strings.Builder
+	for _, seg := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+		seg = pointerUnescaper.Replace(seg)
+		if _, err := strconv.Atoi(seg); err == nil {
+			b.WriteString("[" + seg + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(seg)
+	}
+	return b.String()
+}
+
+// SourceCommit returns the commit recorded in the header of a cron file
+// written by RenderCron, if any.
+func SourceCommit(cronFile []byte) string {
+	for line := range strings.Lines(string(cronFile)) {
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		if commit, ok := strings.CutPrefix(line, sourceCommitHeader); ok {
+			return strings.TrimSpace(commit)
+		}
+	}
+	return ""
+}
+
 func renderEnvAssignments(env map[string]string) (string, error) {
 	if len(env) == 0 {
 		return "", nil
@@ -111,11 +247,44 @@ func renderEnvAssignments(env map[string]string) (string, error) {
 		if !envKeyRe.MatchString(k) {
 			return "", fmt.Errorf("%w: %q", errInvalidEnvKey, k)
 		}
+		if err := CheckCronCommand(env[k]); err != nil {
+			return "", fmt.Errorf("env %s: %w", k, err)
+		}
 		parts = append(parts, fmt.Sprintf("%s=%s", k, shellEscape(env[k])))
 	}
 	return strings.Join(parts, " ") + " ", nil
 }
 
+// CheckCronValue returns an error if s contains control characters, which
+// cron files cannot hold: a newline would start a new cron entry.
+func CheckCronValue(s string) error {
+	if strings.ContainsFunc(s, unicode.IsControl) {
+		return errControlChar
+	}
+	return nil
+}
+
+// CheckCronCommand is CheckCronValue for the command field of a cron entry,
+// where a literal \% cannot be written: cron reads it as an escaped %.
+func CheckCronCommand(s string) error {
+	if err := CheckCronValue(s); err != nil {
+		return err
+	}
+	if strings.Contains(s, `\%`) {
+		return errEscapedPercent
+	}
+	return nil
+}
+
+// CheckCronUser is CheckCronCommand for the user field, which must also be
+// a single word.
+func CheckCronUser(user string) error {
+	if strings.ContainsFunc(strings.TrimSpace(user), unicode.IsSpace) {
+		return errUserSpace
+	}
+	return CheckCronCommand(user)
+}
+
 func shellEscape(s string) string {
 	// POSIX shell safe quoting using single quotes.
 	if s == "" {
@@ -1,6 +1,8 @@
 package syncer
 
 import (
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -73,6 +75,68 @@ MAILTO=admin@example.com
 */5 * * * * root BAZ='qux' FOO='bar' '/opt/jobs/test-job/script.sh' 'arg1' 'arg with spaces'
 `,
 		},
+		{
+			name: "job with secret env files",
+			job: job.Job{
+				ID: "test-job",
+				Spec: job.Spec{
+					User:    "app",
+					Run:     job.RunSpec{Entrypoint: "run.sh"},
+					Secrets: job.SecretsSpec{EnvFiles: []string{"/etc/cronctl/secrets/test-job.env", "/etc/cronctl/secrets/shared.env"}},
+					Schedule: []job.ScheduleItem{
+						{Cron: "0 * * * *", Args: []string{"--full"}, Env: map[string]string{"MODE": "x"}},
+					},
+				},
+			},
+			targetPath: "/opt/cronctl/jobs/test-job",
+			want: `# Generated by cronctl. DO NOT EDIT.
+0 * * * * root MODE='x' '/usr/local/bin/cronctl' exec --user 'app' --job 'test-job' --env-file '/etc/cronctl/secrets/test-job.env' --env-file '/etc/cronctl/secrets/shared.env' -- '/opt/cronctl/jobs/test-job/run.sh' '--full'
+`,
+		},
+		{
+			name: "job with encrypted secrets",
+			job: job.Job{
+				ID: "test-job",
+				Spec: job.Spec{
+					User:     "app",
+					Run:      job.RunSpec{Entrypoint: "run.sh"},
+					Secrets:  job.SecretsSpec{Encrypted: "secrets.env.age"},
+					Schedule: []job.ScheduleItem{{Cron: "0 * * * *"}},
+				},
+			},
+			targetPath: "/opt/cronctl/jobs/test-job",
+			want: `# Generated by cronctl. DO NOT EDIT.
+0 * * * * root '/usr/local/bin/cronctl' exec --user 'app' --job 'test-job' --env-file '/etc/cronctl/secrets/test-job/decrypted.env' -- '/opt/cronctl/jobs/test-job/run.sh'
+`,
+		},
+		{
+			name: "percent signs are escaped for cron",
+			job: job.Job{
+				ID: "test-job",
+				Spec: job.Spec{
+					User:     "root",
+					Run:      job.RunSpec{Entrypoint: "run.sh"},
+					Schedule: []job.ScheduleItem{{Cron: "0 0 * * *", Args: []string{"+%Y-%m-%d"}, Env: map[string]string{"FMT": "100%"}}},
+				},
+			},
+			targetPath: "/opt/cronctl/jobs/test-job",
+			want: `# Generated by cronctl. DO NOT EDIT.
+0 0 * * * root FMT='100\%' '/opt/cronctl/jobs/test-job/run.sh' '+\%Y-\%m-\%d'
+`,
+		},
+		{
+			name: "newline in env value",
+			job: job.Job{
+				ID: "test-job",
+				Spec: job.Spec{
+					User:     "root",
+					Env:      map[string]string{"MAILTO": "x\n* * * * * root evil"},
+					Run:      job.RunSpec{Entrypoint: "run.sh"},
+					Schedule: []job.ScheduleItem{{Cron: "0 * * * *"}},
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "job with silent schedule",
 			job: job.Job{
@@ -223,16 +287,16 @@ PATH=/usr/local/bin:/usr/bin
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			got, err := renderCron(tt.job, tt.targetPath)
+			got, err := RenderCron(tt.job, tt.targetPath, "")
 			if (err != nil) != tt.wantErr {
-				t.Errorf("renderCron() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("RenderCron() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if err != nil {
 				return
 			}
 			if string(got) != tt.want {
-				t.Errorf("renderCron() =\n%s\nwant:\n%s", string(got), tt.want)
+				t.Errorf("RenderCron() =\n%s\nwant:\n%s", string(got), tt.want)
 			}
 		})
 	}
@@ -343,16 +407,16 @@ func TestRenderCronNoSchedule(t *testing.T) {
 		},
 	}
 
-	got, err := renderCron(j, "/opt/cronctl/jobs/no-schedule")
+	got, err := RenderCron(j, "/opt/cronctl/jobs/no-schedule", "")
 	if err != nil {
-		t.Fatalf("renderCron() unexpected error: %v", err)
+		t.Fatalf("RenderCron() unexpected error: %v", err)
 	}
 
 	want := `# Generated by cronctl. DO NOT EDIT.
 PATH=/usr/bin
 `
 	if string(got) != want {
-		t.Errorf("renderCron() with no schedule =\n%s\nwant:\n%s", string(got), want)
+		t.Errorf("RenderCron() with no schedule =\n%s\nwant:\n%s", string(got), want)
 	}
 }
 
@@ -376,9 +440,9 @@ func TestRenderCronSortedGlobalEnv(t *testing.T) {
 		},
 	}
 
-	got, err := renderCron(j, "/opt/cronctl/jobs/sorted-env")
+	got, err := RenderCron(j, "/opt/cronctl/jobs/sorted-env", "")
 	if err != nil {
-		t.Fatalf("renderCron() unexpected error: %v", err)
+		t.Fatalf("RenderCron() unexpected error: %v", err)
 	}
 
 	lines := strings.Split(string(got), "\n")
@@ -397,3 +461,144 @@ func TestRenderCronSortedGlobalEnv(t *testing.T) {
 		t.Errorf("line 3: got %q, want %q", lines[3], "ZZZ=last")
 	}
 }
+
+func TestRenderCronSourceCommit(t *testing.T) {
+	t.Parallel()
+	j := job.Job{
+		ID: "from-git",
+		Spec: job.Spec{
+			User:     "root",
+			Run:      job.RunSpec{Entrypoint: "run.sh"},
+			Schedule: []job.ScheduleItem{{Cron: "0 * * * *"}},
+		},
+	}
+
+	got, err := RenderCron(j, "/opt/cronctl/jobs/from-git", "0123456789abcdef0123456789abcdef01234567")
+	if err != nil {
+		t.Fatalf("RenderCron() unexpected error: %v", err)
+	}
+
+	want := `# Generated by cronctl. DO NOT EDIT.
+# Source commit: 0123456789abcdef0123456789abcdef01234567
+0 * * * * root '/opt/cronctl/jobs/from-git/run.sh'
+`
+	if string(got) != want {
+		t.Errorf("RenderCron() with commit =\n%s\nwant:\n%s", string(got), want)
+	}
+	if c := SourceCommit(got); c != "0123456789abcdef0123456789abcdef01234567" {
+		t.Errorf("SourceCommit() = %q", c)
+	}
+	if c := SourceCommit([]byte("# Generated by cronctl. DO NOT EDIT.\n0 * * * * root true # Source commit: x\n")); c != "" {
+		t.Errorf("SourceCommit() without header = %q, want empty", c)
+	}
+}
+
+func FuzzRenderCron(f *testing.F) {
+	f.Add("app", "/usr/bin:/bin", "run.sh", "date +%Y-%m-%d", "it's", "50%")
+	f.Add("root", "a\nb", "run.sh", "x", "y", "z")
+	f.Add("app", "v", "../x %s.sh", `a\%b`, "\\", "'")
+	f.Add("root evil", "v", "run.sh", "", "\t", "\r")
+	f.Fuzz(func(t *testing.T, user, envValue, entrypoint, arg1, arg2, schedValue string) {
+		j := job.Job{
+			ID: "fuzz",
+			Spec: job.Spec{
+				User: user,
+				Env:  map[string]string{"G": envValue},
+				Run:  job.RunSpec{Entrypoint: entrypoint},
+				Schedule: []job.ScheduleItem{
+					{Cron: "0 * * * *", Args: []string{arg1, arg2}, Env: map[string]string{"S": schedValue}},
+				},
+			},
+		}
+		out, err := RenderCron(j, "/opt/cronctl/jobs/fuzz", "")
+		if err != nil {
+			return
+		}
+
+		lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("got %d lines, want header, env and entry:\n%s", len(lines), out)
+		}
+		if lines[1] != "G="+envValue {
+			t.Fatalf("env line = %q, want G=%q", lines[1], envValue)
+		}
+		fields := strings.SplitN(lines[2], " ", 7)
+		if len(fields) != 7 || strings.Join(fields[:5], " ") != "0 * * * *" || fields[5] != strings.TrimSpace(user) {
+			t.Fatalf("entry = %q, want schedule and user %q", lines[2], strings.TrimSpace(user))
+		}
+		cmd, ok := cronCommandUnescape(fields[6])
+		if !ok {
+			t.Fatalf("command %q has an unescaped %%", fields[6])
+		}
+		words, ok := shellSplit(cmd)
+		if !ok {
+			t.Fatalf("command %q is not valid single-quoted shell", cmd)
+		}
+		ep := strings.TrimSpace(entrypoint)
+		if ep == "" {
+			ep = job.DefaultRunEntrypoint
+		}
+		want := []string{"S=" + schedValue, filepath.Join("/opt/cronctl/jobs/fuzz", ep), arg1, arg2}
+		if !slices.Equal(words, want) {
+			t.Fatalf("command %q parses to %q, want %q", fields[6], words, want)
+		}
+	})
+}
+
+// cronCommandUnescape does what cron does to the command field: \% is a
+// literal % and the first other % would end the command.
+func cronCommandUnescape(s string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '%':
+			b.WriteByte('%')
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			b.WriteString(s[i : i+2])
+			i++
+		case s[i] == '%':
+			return "", false
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), true
+}
+
+// shellSplit splits the words of a command made of single-quoted strings,
+// backslash escapes and unquoted words, as sh would.
+func shellSplit(s string) ([]string, bool) {
+	var words []string
+	var w strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ' ':
+			if inWord {
+				words = append(words, w.String())
+				w.Reset()
+				inWord = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, false
+			}
+			w.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '\\' && i+1 < len(s):
+			w.WriteByte(s[i+1])
+			i++
+			inWord = true
+		default:
+			w.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, w.String())
+	}
+	return words, true
+}
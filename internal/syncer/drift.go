@@ -0,0 +1,126 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/runner"
+)
+
+// Repair restores the cron files of jobs, as deployed by Sync with the same
+// options, if they were edited, removed or added by hand. Payloads are not
+// checked. It returns the repaired cron file paths.
+func Repair(ctx context.Context, jobs []job.Job, opts Options) ([]string, error) {
+	if opts.CronDir == "" {
+		opts.CronDir = "/etc/cron.d"
+	}
+	if opts.TargetDir == "" {
+		opts.TargetDir = "/opt/cronctl/jobs"
+	}
+	if opts.SecretsDir == "" {
+		opts.SecretsDir = runner.SecretsDir
+	}
+
+	var repaired []string
+	seen := make(map[string]struct{}, len(jobs))
+	for _, j := range jobs {
+		if err := ctx.Err(); err != nil {
+			return repaired, fmt.Errorf("repair: %w", err)
+		}
+		seen[j.ID] = struct{}{}
+		cronPath := filepath.Join(opts.CronDir, "cronctl-"+j.ID)
+
+		state, want, err := checkCron(opts, j)
+		if err != nil {
+			return repaired, err
+		}
+		switch state {
+		case CronOK:
+			continue
+		case CronUnexpected:
+			log.Printf("repair: %s: unexpected cron file %s", j.ID, cronPath)
+			if err := removeFileIfExists(opts.DryRun, cronPath); err != nil {
+				return repaired, err
+			}
+		default:
+			log.Printf("repair: %s: cron file %s %s", j.ID, cronPath, state)
+			if err := writeCronFile(opts.DryRun, cronPath, want); err != nil {
+				return repaired, fmt.Errorf("job %s: write cron: %w", j.ID, err)
+			}
+		}
+		repaired = append(repaired, cronPath)
+	}
+
+	if opts.RemoveOrphans {
+		removed, err := pruneOrphans(opts, seen)
+		repaired = append(repaired, removed...)
+		if err != nil {
+			return repaired, err
+		}
+	}
+	return repaired, nil
+}
+
+// CronState is the state of a deployed cron file compared to its job.
+type CronState string
+
+const (
+	CronOK         CronState = "ok"
+	CronModified   CronState = "modified"
+	CronMissing    CronState = "missing"
+	CronUnexpected CronState = "unexpected"
+)
+
+// CheckCron compares the cron file of j with what Sync would write with the
+// same options.
+func CheckCron(opts Options, j job.Job) (CronState, error) {
+	if opts.CronDir == "" {
+		opts.CronDir = "/etc/cron.d"
+	}
+	if opts.TargetDir == "" {
+		opts.TargetDir = "/opt/cronctl/jobs"
+	}
+	state, _, err := checkCron(opts, j)
+	return state, err
+}
+
+// checkCron returns the state of the cron file of j and its desired contents.
+func checkCron(opts Options, j job.Job) (CronState, []byte, error) {
+	cronPath := filepath.Join(opts.CronDir, "cronctl-"+j.ID)
+	info, err := os.Lstat(cronPath)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", nil, fmt.Errorf("stat %s: %w", cronPath, err)
+	}
+
+	if !j.Spec.Enabled || len(j.Spec.Schedule) == 0 {
+		if exists {
+			return CronUnexpected, nil, nil
+		}
+		return CronOK, nil, nil
+	}
+	want, err := cronFile(opts, j, filepath.Join(opts.TargetDir, j.ID))
+	if err != nil {
+		return "", nil, fmt.Errorf("job %s: render cron: %w", j.ID, err)
+	}
+	if !exists {
+		return CronMissing, want, nil
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm() != 0o644 {
+		return CronModified, want, nil
+	}
+	got, err := os.ReadFile(cronPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("read %s: %w", cronPath, err)
+	}
+	if !bytes.Equal(got, want) {
+		return CronModified, want, nil
+	}
+	return CronOK, want, nil
+}
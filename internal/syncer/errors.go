@@ -3,11 +3,20 @@ package syncer
 import "errors"
 
 var (
-	errBuildNeedsRoot    = errors.New("build requires root to switch user")
-	errUserEmpty         = errors.New("user is empty")
-	errScheduleCronEmpty = errors.New("cron is empty")
-	errInvalidEnvKey     = errors.New("invalid env key")
-	errNegativeID        = errors.New("negative")
-	errIDTooLarge        = errors.New("too large")
-	errPathTraversal     = errors.New("path traversal detected")
+	errBuildNeedsRoot     = errors.New("build requires root to switch user")
+	errUserEmpty          = errors.New("user is empty")
+	errScheduleCronEmpty  = errors.New("cron is empty")
+	errInvalidEnvKey      = errors.New("invalid env key")
+	errEntrypointNotLocal = errors.New("must be a relative path inside the job dir")
+	errUserSpace          = errors.New("contains whitespace")
+	errControlChar        = errors.New("contains a control character such as a newline")
+	errEscapedPercent     = errors.New(`contains \%, which cron cannot represent`)
+	errNegativeID         = errors.New("negative")
+	errIDTooLarge         = errors.New("too large")
+	errPrebuiltMismatch   = errors.New("prebuilt payload does not match repo")
+	errDeployMismatch     = errors.New("job does not deploy as signed")
+	errNoDeployRecord     = errors.New("manifest has no deploy record (export it again with this cronctl version)")
+	errBundleNoCron       = errors.New("bundle has no cron file for job")
+	errSecretsNotLocal    = errors.New("encrypted secrets must be a file in the job dir")
+	errSecretsNotFile     = errors.New("encrypted secrets are not a regular file")
 )
@@ -0,0 +1,186 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/yegor-usoltsev/cronctl/internal/build"
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/manifest"
+	"github.com/yegor-usoltsev/cronctl/internal/payload"
+	"github.com/yegor-usoltsev/cronctl/internal/signing"
+)
+
+// DescribeDeploy returns the deploy record of j deployed to targetDir, as
+// build.Export stores it in payload manifests.
+func DescribeDeploy(j job.Job, targetDir string) (manifest.Deploy, error) {
+	d := manifest.Deploy{SpecSHA256: manifest.SHA256(j.RawYAML), TargetDir: targetDir, CronSHA256: ""}
+	if len(j.Spec.Schedule) == 0 {
+		return d, nil
+	}
+	data, err := RenderCron(j, filepath.Join(targetDir, j.ID), "")
+	if err != nil {
+		return manifest.Deploy{}, fmt.Errorf("render cron: %w", err)
+	}
+	d.CronSHA256 = manifest.SHA256(data)
+	return d, nil
+}
+
+// prebuiltManifest is the manifest of a prebuilt payload, as read once by
+// checkPrebuilt.
+type prebuiltManifest struct {
+	manifest.Manifest
+
+	data []byte
+}
+
+// checkPrebuilt verifies, before anything is changed, that every enabled job
+// has a prebuilt payload that still matches its manifest. Unless deploying a
+// bundle (whose manifest covers the payload manifests and cron files), the
+// manifest must be signed by one of opts.VerifyKeys, if set, and record the
+// current job sources, effective spec and cron file. It returns the checked
+// manifests by job ID, which stagePrebuilt verifies the staged copies with.
+func checkPrebuilt(ctx context.Context, jobs []job.Job, opts Options) (map[string]prebuiltManifest, error) {
+	var errs []error
+	manifests := make(map[string]prebuiltManifest, len(jobs))
+	for _, j := range jobs {
+		if !j.Spec.Enabled {
+			continue
+		}
+		payloadDir := filepath.Join(opts.prebuiltDir(), j.ID)
+		m, err := checkPrebuiltJob(ctx, j, payloadDir, opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("job %s: %w", j.ID, err))
+			continue
+		}
+		manifests[j.ID] = m
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return manifests, nil
+}
+
+// checkSignedManifests verifies, before anything is changed, that every
+// enabled job matches its signed manifest in opts.SignedManifests, for
+// syncs that build from the repo.
+func checkSignedManifests(ctx context.Context, jobs []job.Job, opts Options) error {
+	var errs []error
+	for _, j := range jobs {
+		if !j.Spec.Enabled {
+			continue
+		}
+		if _, err := checkManifest(ctx, j, filepath.Join(opts.SignedManifests, j.ID), opts); err != nil {
+			errs = append(errs, fmt.Errorf("job %s: %w", j.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkPrebuiltJob(ctx context.Context, j job.Job, payloadDir string, opts Options) (prebuiltManifest, error) {
+	m, err := checkManifest(ctx, j, payloadDir, opts)
+	if err != nil {
+		return prebuiltManifest{}, err
+	}
+	if err := m.Verify(payloadDir); err != nil {
+		return prebuiltManifest{}, fmt.Errorf("verify %s: %w", payloadDir, err)
+	}
+	return m, nil
+}
+
+// checkManifest reads the manifest of the payload in payloadDir and checks
+// it is for j. Outside bundles, it must be signed by one of opts.VerifyKeys,
+// if set, and record the current job sources and deploy (see checkDeploy).
+// The signature is checked on the same bytes that are parsed and returned.
+func checkManifest(ctx context.Context, j job.Job, payloadDir string, opts Options) (prebuiltManifest, error) {
+	path := manifest.Path(payloadDir)
+	data, err := os.ReadFile(path) // #nosec G304 -- the payload dir is given by the operator.
+	if err != nil {
+		return prebuiltManifest{}, fmt.Errorf("read manifest: %w", err)
+	}
+	bundled := opts.Bundle != nil
+	if !bundled && len(opts.VerifyKeys) > 0 {
+		if err := signing.VerifyData(path, data, opts.VerifyKeys); err != nil {
+			return prebuiltManifest{}, err
+		}
+	}
+	m, err := manifest.Parse(path, data)
+	if err != nil {
+		return prebuiltManifest{}, err
+	}
+	if m.JobID != j.ID {
+		return prebuiltManifest{}, fmt.Errorf("%w: manifest is for job %q", errPrebuiltMismatch, m.JobID)
+	}
+	pm := prebuiltManifest{Manifest: m, data: data}
+	if bundled {
+		return pm, nil
+	}
+	cur, err := build.InputsHash(ctx, j.Dir, j.RawYAML)
+	if err != nil {
+		return prebuiltManifest{}, fmt.Errorf("hash inputs: %w", err)
+	}
+	if m.InputHash != cur {
+		return prebuiltManifest{}, fmt.Errorf("%w: built from %s, repo is at %s", errPrebuiltMismatch, m.InputHash, cur)
+	}
+	if err := checkDeploy(j, m, opts); err != nil {
+		return prebuiltManifest{}, err
+	}
+	return pm, nil
+}
+
+// checkDeploy returns an error unless j deploys as recorded in m: with the
+// same effective spec and, for opts.TargetDir, the same cron file. The spec
+// and cron file come from the repo, not the payload, so a signature only
+// covers them through this record; signed manifests must have one.
+func checkDeploy(j job.Job, m manifest.Manifest, opts Options) error {
+	if m.Deploy == nil {
+		if len(opts.VerifyKeys) > 0 {
+			return errNoDeployRecord
+		}
+		return nil
+	}
+	want, err := DescribeDeploy(j, opts.TargetDir)
+	if err != nil {
+		return err
+	}
+	switch got := *m.Deploy; {
+	case got.SpecSHA256 != want.SpecSHA256:
+		return fmt.Errorf("%w: the effective job spec differs", errDeployMismatch)
+	case got.TargetDir != want.TargetDir:
+		return fmt.Errorf("%w: the cron file was rendered for target dir %s, not %s", errDeployMismatch, got.TargetDir, want.TargetDir)
+	case got.CronSHA256 != want.CronSHA256:
+		return fmt.Errorf("%w: the rendered cron file differs", errDeployMismatch)
+	}
+	return nil
+}
+
+// stagePrebuilt copies the prebuilt payload of jobID from dir into the
+// staging dir, writes m, its manifest as checked by checkPrebuilt, there and
+// verifies the staged copy against m. The source may have changed since it
+// was checked; the staged copy is out of the job user's reach until deployed.
+func stagePrebuilt(dryRun bool, dir, jobID, stagingDir string, m prebuiltManifest) error {
+	src := filepath.Join(dir, jobID)
+	if dryRun {
+		log.Printf("dry-run: copy prebuilt payload %s -> %s", src, stagingDir)
+		return nil
+	}
+	if err := payload.Copy(src, stagingDir, payload.Filter{}); err != nil {
+		return err
+	}
+	to := manifest.Path(stagingDir)
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(to), err)
+	}
+	// #nosec G306 -- the manifest holds digests, not secrets.
+	if err := os.WriteFile(to, m.data, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", to, err)
+	}
+	if err := m.Verify(stagingDir); err != nil {
+		return fmt.Errorf("verify staged payload: %w", err)
+	}
+	return nil
+}
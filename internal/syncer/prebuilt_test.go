@@ -0,0 +1,62 @@
+package syncer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yegor-usoltsev/cronctl/internal/manifest"
+)
+
+func TestStagePrebuiltVerifiesStagedCopy(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "prebuilt", "report")
+	if err := os.MkdirAll(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.New("report", "inputs", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Write(manifest.Path(src), m); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(manifest.Path(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checked := prebuiltManifest{Manifest: m, data: data}
+
+	staged := filepath.Join(dir, "staged")
+	if err := stagePrebuilt(false, filepath.Dir(src), "report", staged, checked); err != nil {
+		t.Fatalf("stagePrebuilt: %v", err)
+	}
+	if b, err := os.ReadFile(manifest.Path(staged)); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("staged manifest = %q, %v; want the checked one", b, err)
+	}
+
+	// Swap the payload, and its manifest, after the check.
+	if err := os.WriteFile(filepath.Join(src, "run.sh"), []byte("#!/bin/sh\necho swapped\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	swapped, err := manifest.New("report", "inputs", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Write(manifest.Path(src), swapped); err != nil {
+		t.Fatal(err)
+	}
+	staged = filepath.Join(dir, "staged-swapped")
+	if err := stagePrebuilt(false, filepath.Dir(src), "report", staged, checked); err == nil {
+		t.Fatal("stagePrebuilt(swapped payload) = nil, want error")
+	}
+	if b, err := os.ReadFile(manifest.Path(staged)); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("staged manifest = %q, %v; want the checked one", b, err)
+	}
+}
@@ -8,11 +8,15 @@ import (
 	"strings"
 )
 
-func pruneOrphans(dryRun bool, cronDir string, keep map[string]struct{}) error {
+// pruneOrphans removes the cron files, and decrypted secrets, of jobs in
+// opts.CronDir that are not in keep.
+func pruneOrphans(opts Options, keep map[string]struct{}) ([]string, error) {
+	dryRun, cronDir := opts.DryRun, opts.CronDir
 	ents, err := os.ReadDir(cronDir)
 	if err != nil {
-		return fmt.Errorf("read cron dir %s: %w", cronDir, err)
+		return nil, fmt.Errorf("read cron dir %s: %w", cronDir, err)
 	}
+	var removed []string
 	for _, e := range ents {
 		name := e.Name()
 		if !strings.HasPrefix(name, "cronctl-") {
@@ -23,13 +27,15 @@ func pruneOrphans(dryRun bool, cronDir string, keep map[string]struct{}) error {
 			continue
 		}
 		path := filepath.Join(cronDir, name)
+		removed = append(removed, path)
 		if dryRun {
 			log.Printf("dry-run: prune orphan cron %s", path)
-			continue
+		} else if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove orphan %s: %w", path, err)
 		}
-		if err := os.Remove(path); err != nil {
-			return fmt.Errorf("remove orphan %s: %w", path, err)
+		if err := removeSecrets(opts, id); err != nil {
+			return nil, fmt.Errorf("job %s: %w", id, err)
 		}
 	}
-	return nil
+	return removed, nil
 }
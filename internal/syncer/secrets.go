@@ -0,0 +1,150 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/runner"
+	"github.com/yegor-usoltsev/cronctl/internal/secrets"
+)
+
+// checkSecrets checks that the secret env files of j exist with ownership
+// and mode safe for a job run by uid. With AllowInsecureSecrets problems are
+// only logged.
+func checkSecrets(opts Options, j job.Job, uid int) error {
+	files := j.Spec.Secrets.EnvFiles
+	if len(files) == 0 && j.Spec.Secrets.Encrypted == "" {
+		return nil
+	}
+	for _, f := range files {
+		err := runner.CheckEnvFile(f, j.ID, uid)
+		if err == nil {
+			continue
+		}
+		if !opts.AllowInsecureSecrets {
+			return fmt.Errorf("secrets: %w", err)
+		}
+		log.Printf("sync: %s: warning: %v", j.ID, err)
+	}
+	if _, err := os.Stat(runner.Path); err != nil {
+		log.Printf("sync: %s: warning: secret env files are loaded by %s: %v", j.ID, runner.Path, err)
+	}
+	return nil
+}
+
+// readSecrets decrypts the encrypted secrets of j from srcDir, the job
+// checkout or a verified prebuilt payload, before the job user gets to touch
+// either. The file is opened within srcDir and must not be a symlink.
+func readSecrets(ctx context.Context, opts Options, j job.Job, srcDir string) ([]byte, error) {
+	name := j.Spec.Secrets.Encrypted
+	if name == "" {
+		return nil, nil
+	}
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("secrets: %w: %s", errSecretsNotLocal, name)
+	}
+	if opts.DryRun {
+		log.Printf("dry-run: decrypt %s", filepath.Join(srcDir, name))
+		return nil, nil
+	}
+	root, err := os.OpenRoot(srcDir)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	defer func() { _ = root.Close() }()
+	// Root follows symlinks that stay within it, so the file opened must
+	// be the regular file found without following any.
+	lfi, err := root.Lstat(name)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	if !lfi.Mode().IsRegular() || !os.SameFile(lfi, fi) {
+		return nil, fmt.Errorf("secrets: %w: %s", errSecretsNotFile, name)
+	}
+	plain, err := secrets.DecryptReader(ctx, f, opts.AgeIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %s: %w", name, err)
+	}
+	if _, err := runner.ParseEnvFile(plain); err != nil {
+		return nil, fmt.Errorf("secrets: %s: %w", name, err)
+	}
+	return plain, nil
+}
+
+// deploySecrets writes plain, the decrypted secrets of j, into the job's
+// root-owned dir in opts.SecretsDir, where cronctl exec reads them before
+// dropping privileges. The plaintext of a job without encrypted secrets is
+// removed.
+func deploySecrets(opts Options, j job.Job, plain []byte) error {
+	if j.Spec.Secrets.Encrypted == "" {
+		return removeSecrets(opts, j.ID)
+	}
+	dir := filepath.Join(opts.SecretsDir, j.ID)
+	dst := secrets.DeployedPath(dir)
+	if opts.DryRun {
+		log.Printf("dry-run: write secrets %s", dst)
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+	if err := runner.CheckSecretsDir(dir); err != nil {
+		return fmt.Errorf("secrets: %w", err)
+	}
+	return writeSecrets(dir, dst, plain)
+}
+
+// writeSecrets replaces dst in dir with a root-owned 0600 file holding
+// plain. The rename replaces whatever is at dst without following it.
+func writeSecrets(dir, dst string, plain []byte) error {
+	f, err := os.CreateTemp(dir, ".decrypted.env-*")
+	if err != nil {
+		return fmt.Errorf("write secrets: %w", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	_, err = f.Write(plain)
+	if err == nil {
+		err = f.Chmod(0o600)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write secrets: %w", err)
+	}
+	if err := os.Rename(f.Name(), dst); err != nil {
+		return fmt.Errorf("write secrets: %w", err)
+	}
+	return nil
+}
+
+// removeSecrets removes the decrypted secrets of job id, if there are any.
+func removeSecrets(opts Options, id string) error {
+	if !filepath.IsLocal(id) || strings.ContainsRune(id, '/') {
+		return nil
+	}
+	path := secrets.DeployedPath(filepath.Join(opts.SecretsDir, id))
+	if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err := removeFileIfExists(opts.DryRun, path); err != nil {
+		return fmt.Errorf("secrets: %w", err)
+	}
+	return nil
+}
@@ -2,12 +2,16 @@ package syncer
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 
+	"github.com/yegor-usoltsev/cronctl/internal/artifact"
 	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/runner"
+	"github.com/yegor-usoltsev/cronctl/internal/secrets"
 )
 
 type Options struct {
@@ -20,6 +24,55 @@ type Options struct {
 
 	Chown             bool
 	RunBuildAsJobUser bool
+
+	// Artifacts, if set, is consulted before running a build and receives
+	// the outputs of successful builds.
+	Artifacts *artifact.Store
+	// Verbose streams build output live, prefixed with the job ID.
+	Verbose bool
+	// FromArtifacts deploys prebuilt payloads from <dir>/<job-id> (see
+	// build.Export) instead of copying and building the job sources.
+	FromArtifacts string
+	// VerifyKeys, if set, are the trusted keys FromArtifacts and
+	// SignedManifests manifests must be signed with.
+	VerifyKeys []ed25519.PublicKey
+	// SignedManifests, if set, holds a manifest for every enabled job as
+	// <dir>/<job-id>/.cronctl/manifest.json (see build.Export). Jobs are
+	// still built from the repo, but only if their sources, effective spec
+	// and cron file match.
+	SignedManifests string
+	// Bundle, if set, deploys an extracted bundle. There are no job sources,
+	// so payloads are only checked against their manifests.
+	Bundle *Bundle
+	// SourceCommit, if set, is the git commit the jobs are deployed from. It
+	// is recorded in the cron file headers.
+	SourceCommit string
+	// AllowInsecureSecrets only warns about secret env files that are
+	// missing or readable by others instead of failing the job.
+	AllowInsecureSecrets bool
+	// AgeIdentity is the age identity file encrypted job secrets are
+	// decrypted with (default: /etc/cronctl/age.key).
+	AgeIdentity string
+	// SecretsDir is the root-owned dir encrypted job secrets are decrypted
+	// into, as <dir>/<job-id>/decrypted.env (default: runner.SecretsDir, the
+	// only dir cronctl exec trusts).
+	SecretsDir string
+}
+
+// Bundle is an extracted deploy bundle (see internal/bundle).
+type Bundle struct {
+	// PayloadDir holds the prebuilt payloads as <dir>/<job-id>.
+	PayloadDir string
+	// CronFiles holds the rendered cron files by job ID.
+	CronFiles map[string][]byte
+}
+
+// prebuiltDir returns the dir of prebuilt payloads, if any.
+func (o Options) prebuiltDir() string {
+	if o.Bundle != nil {
+		return o.Bundle.PayloadDir
+	}
+	return o.FromArtifacts
 }
 
 func Sync(ctx context.Context, jobs []job.Job, opts Options) error {
@@ -32,10 +85,28 @@ func Sync(ctx context.Context, jobs []job.Job, opts Options) error {
 	if opts.TargetDir == "" {
 		opts.TargetDir = "/opt/cronctl/jobs"
 	}
+	if opts.AgeIdentity == "" {
+		opts.AgeIdentity = secrets.Identity
+	}
+	if opts.SecretsDir == "" {
+		opts.SecretsDir = runner.SecretsDir
+	}
 	if len(jobs) == 0 {
 		return nil
 	}
 
+	var manifests map[string]prebuiltManifest
+	if dir := opts.prebuiltDir(); dir != "" {
+		var err error
+		if manifests, err = checkPrebuilt(ctx, jobs, opts); err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+	} else if opts.SignedManifests != "" {
+		if err := checkSignedManifests(ctx, jobs, opts); err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+	}
+
 	if err := os.MkdirAll(opts.TargetDir, 0o755); err != nil {
 		return fmt.Errorf("mkdir target dir: %s: %w", opts.TargetDir, err)
 	}
@@ -43,6 +114,7 @@ func Sync(ctx context.Context, jobs []job.Job, opts Options) error {
 	// Build strategy:
 	// - copy sources into a temp dir under TargetDir
 	// - run build in that temp dir (so we don't dirty the repo checkout)
+	// - drop files excluded by payload.include/exclude and .cronctlignore
 	// - atomically swap the temp dir into TargetDir/jobID
 
 	seen := make(map[string]struct{}, len(jobs))
@@ -63,6 +135,11 @@ func Sync(ctx context.Context, jobs []job.Job, opts Options) error {
 				if err := removeDirIfExists(opts.DryRun, targetPath); err != nil {
 					return err
 				}
+			} else if err := writeDisabledSpec(opts.DryRun, j, targetPath); err != nil {
+				return fmt.Errorf("job %s: %w", j.ID, err)
+			}
+			if err := removeSecrets(opts, j.ID); err != nil {
+				return fmt.Errorf("job %s: %w", j.ID, err)
 			}
 			continue
 		}
@@ -71,6 +148,9 @@ func Sync(ctx context.Context, jobs []job.Job, opts Options) error {
 		if err != nil {
 			return fmt.Errorf("job %s: resolve user %q: %w", j.ID, j.Spec.User, err)
 		}
+		if err := checkSecrets(opts, j, uid); err != nil {
+			return fmt.Errorf("job %s: %w", j.ID, err)
+		}
 
 		tmpDir, err := stageJobDir(opts.DryRun, opts.TargetDir, j.ID)
 		if err != nil {
@@ -85,22 +165,27 @@ func Sync(ctx context.Context, jobs []job.Job, opts Options) error {
 				_ = os.RemoveAll(tmpDir)
 			}()
 		}
-		if err := copyJobDir(opts.DryRun, j.Dir, tmpDir); err != nil {
-			return fmt.Errorf("job %s: copy payload: %w", j.ID, err)
-		}
-		if err := carryOverFilehash(opts.DryRun, targetPath, tmpDir); err != nil {
-			return fmt.Errorf("job %s: carry over cache: %w", j.ID, err)
-		}
-		if opts.Chown {
-			if err := chownTree(opts.DryRun, tmpDir, uid, gid); err != nil {
-				return fmt.Errorf("job %s: chown payload: %w", j.ID, err)
+		// Secrets are decrypted from sources the job user cannot write yet:
+		// the checkout before the build, or the prebuilt payload before chown.
+		var plain []byte
+		if dir := opts.prebuiltDir(); dir != "" {
+			if err := stagePrebuilt(opts.DryRun, dir, j.ID, tmpDir, manifests[j.ID]); err != nil {
+				return fmt.Errorf("job %s: copy prebuilt payload: %w", j.ID, err)
+			}
+			if plain, err = readSecrets(ctx, opts, j, tmpDir); err != nil {
+				return fmt.Errorf("job %s: %w", j.ID, err)
+			}
+		} else {
+			if plain, err = readSecrets(ctx, opts, j, j.Dir); err != nil {
+				return fmt.Errorf("job %s: %w", j.ID, err)
+			}
+			if err := stageAndBuild(ctx, opts, j, tmpDir, targetPath, uid, gid); err != nil {
+				return err
 			}
 		}
 
-		if j.Spec.Build.Enabled {
-			if err := runBuildIfNeeded(ctx, opts.DryRun, j.ID, tmpDir, j.Spec.Build.Entrypoint, opts.ForceBuild, opts.RunBuildAsJobUser, uid, gid); err != nil {
-				return fmt.Errorf("job %s: build: %w", j.ID, err)
-			}
+		if err := deploySecrets(opts, j, plain); err != nil {
+			return fmt.Errorf("job %s: %w", j.ID, err)
 		}
 
 		if err := replaceDir(opts.DryRun, tmpDir, targetPath); err != nil {
@@ -124,7 +209,11 @@ func Sync(ctx context.Context, jobs []job.Job, opts Options) error {
 			continue
 		}
 
-		if err := writeCronFile(opts.DryRun, cronPath, j, targetPath); err != nil {
+		data, err := cronFile(opts, j, targetPath)
+		if err != nil {
+			return fmt.Errorf("job %s: render cron: %w", j.ID, err)
+		}
+		if err := writeCronFile(opts.DryRun, cronPath, data); err != nil {
 			return fmt.Errorf("job %s: write cron: %w", j.ID, err)
 		}
 
@@ -132,10 +221,41 @@ func Sync(ctx context.Context, jobs []job.Job, opts Options) error {
 	}
 
 	if opts.RemoveOrphans {
-		if err := pruneOrphans(opts.DryRun, opts.CronDir, seen); err != nil {
+		if _, err := pruneOrphans(opts, seen); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// stageAndBuild copies the sources of j into the staging dir, builds them if
+// needed, drops files that are not part of the payload and writes the
+// effective job.yaml.
+func stageAndBuild(ctx context.Context, opts Options, j job.Job, tmpDir, targetPath string, uid, gid int) error {
+	if err := copyJobDir(opts.DryRun, j.Dir, tmpDir); err != nil {
+		return fmt.Errorf("job %s: copy payload: %w", j.ID, err)
+	}
+	if err := carryOverState(opts.DryRun, targetPath, tmpDir); err != nil {
+		return fmt.Errorf("job %s: carry over cache: %w", j.ID, err)
+	}
+	if opts.Chown {
+		if err := chownTree(opts.DryRun, tmpDir, uid, gid); err != nil {
+			return fmt.Errorf("job %s: chown payload: %w", j.ID, err)
+		}
+	}
+
+	if j.Spec.Build.Enabled {
+		if err := runBuildIfNeeded(ctx, opts, j, tmpDir, targetPath, uid, gid); err != nil {
+			return fmt.Errorf("job %s: build: %w", j.ID, err)
+		}
+	}
+
+	if err := filterPayload(opts.DryRun, j, tmpDir); err != nil {
+		return fmt.Errorf("job %s: filter payload: %w", j.ID, err)
+	}
+	if err := writeSpec(opts.DryRun, j, tmpDir); err != nil {
+		return fmt.Errorf("job %s: %w", j.ID, err)
+	}
+	return nil
+}
@@ -2,11 +2,24 @@ package syncer_test
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 
+	"github.com/yegor-usoltsev/cronctl/internal/build"
 	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/manifest"
+	"github.com/yegor-usoltsev/cronctl/internal/runner"
+	"github.com/yegor-usoltsev/cronctl/internal/secrets"
+	"github.com/yegor-usoltsev/cronctl/internal/signing"
 	"github.com/yegor-usoltsev/cronctl/internal/syncer"
 )
 
@@ -127,6 +140,14 @@ schedule: []
 	if err := os.WriteFile(cronFile, []byte("# old cron\n"), 0o644); err != nil {
 		t.Fatal(err)
 	}
+	// The payload deployed while the job was enabled is kept.
+	deployedSpec := filepath.Join(targetDir, "disabled-job", "job.yaml")
+	if err := os.MkdirAll(filepath.Dir(deployedSpec), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(deployedSpec, []byte("name: disabled-job\nenabled: true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
 
 	opts := syncer.Options{
 		CronDir:   cronDir,
@@ -142,6 +163,10 @@ schedule: []
 	if _, err := os.Stat(cronFile); !os.IsNotExist(err) {
 		t.Errorf("cron file should be removed for disabled job")
 	}
+	// The kept payload's spec shows the job as disabled.
+	if b, err := os.ReadFile(deployedSpec); err != nil || string(b) != jobYAML {
+		t.Errorf("deployed spec = %q, %v; want the disabled spec", b, err)
+	}
 }
 
 func TestSyncOrphanPruning(t *testing.T) {
@@ -299,3 +324,665 @@ schedule: []
 		t.Errorf("payload should be deployed even with empty schedule: %v", err)
 	}
 }
+
+func TestSyncPayloadFilter(t *testing.T) {
+	t.Parallel()
+	if os.Geteuid() != 0 {
+		t.Skip("skipping test that requires root")
+	}
+
+	ctx := context.Background()
+	tmpRoot := t.TempDir()
+	jobDir := filepath.Join(tmpRoot, "jobs", "filtered")
+	if err := os.MkdirAll(filepath.Join(jobDir, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	jobYAML := `$schema: https://cronctl.usoltsev.xyz/v0.json
+name: filtered
+enabled: true
+user: root
+tags: []
+build:
+  enabled: true
+run:
+  entrypoint: run.sh
+payload:
+  include: [run.sh, app]
+schedule:
+  - cron: "0 * * * *"
+`
+	files := map[string]string{
+		"job.yaml":    jobYAML,
+		"run.sh":      "#!/bin/sh\nexec ./app\n",
+		"build.sh":    "#!/bin/sh\ncp src/main.sh app\n",
+		"src/main.sh": "#!/bin/sh\necho ok\n",
+		".gitignore":  "app\n",
+	}
+	for rel, content := range files {
+		if err := os.WriteFile(filepath.Join(jobDir, rel), []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs, err := job.Discover(ctx, filepath.Join(tmpRoot, "jobs"))
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	targetDir := filepath.Join(tmpRoot, "deployed")
+	opts := syncer.Options{CronDir: filepath.Join(tmpRoot, "cron.d"), TargetDir: targetDir}
+
+	// The second sync hits the build cache and must still ship the output.
+	for i := range 2 {
+		if err := syncer.Sync(ctx, jobs, opts); err != nil {
+			t.Fatalf("Sync #%d failed: %v", i+1, err)
+		}
+		for _, rel := range []string{"job.yaml", "run.sh", "app"} {
+			if _, err := os.Stat(filepath.Join(targetDir, "filtered", rel)); err != nil {
+				t.Fatalf("sync #%d: expected %s to be deployed: %v", i+1, rel, err)
+			}
+		}
+		for _, rel := range []string{"build.sh", "src", ".gitignore"} {
+			if _, err := os.Stat(filepath.Join(targetDir, "filtered", rel)); !os.IsNotExist(err) {
+				t.Fatalf("sync #%d: expected %s to be filtered out, stat err = %v", i+1, rel, err)
+			}
+		}
+	}
+}
+
+func TestSyncFromArtifacts(t *testing.T) {
+	t.Parallel()
+	if os.Geteuid() != 0 {
+		t.Skip("skipping test that requires root")
+	}
+
+	ctx := context.Background()
+	tmpRoot := t.TempDir()
+	jobsDir := filepath.Join(tmpRoot, "jobs")
+	jobDir := filepath.Join(jobsDir, "prebuilt")
+	if err := os.MkdirAll(jobDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	jobYAML := `$schema: https://cronctl.usoltsev.xyz/v0.json
+name: prebuilt
+enabled: true
+user: root
+tags: []
+build:
+  enabled: true
+run:
+  entrypoint: run.sh
+schedule:
+  - cron: "0 * * * *"
+`
+	files := map[string]string{
+		"job.yaml": jobYAML,
+		"run.sh":   "#!/bin/sh\nexec ./app\n",
+		"build.sh": "#!/bin/sh\necho built > app\n",
+	}
+	for rel, content := range files {
+		if err := os.WriteFile(filepath.Join(jobDir, rel), []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Simulate CI: build in one checkout and export the payloads.
+	ciJobs := filepath.Join(tmpRoot, "ci", "jobs")
+	if err := os.MkdirAll(filepath.Join(ciJobs, "prebuilt"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for rel, content := range files {
+		if err := os.WriteFile(filepath.Join(ciJobs, "prebuilt", rel), []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	built, err := job.Discover(ctx, ciJobs)
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if err := build.All(ctx, ciJobs, built, build.Options{Parallel: 1}); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	targetDir := filepath.Join(tmpRoot, "deployed")
+	deploy, err := syncer.DescribeDeploy(built[0], targetDir)
+	if err != nil {
+		t.Fatalf("DescribeDeploy failed: %v", err)
+	}
+	artifactsDir := filepath.Join(tmpRoot, "artifacts")
+	if err := build.Export(ctx, built[0], artifactsDir, deploy); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	jobs, err := job.Discover(ctx, jobsDir)
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := syncer.Options{CronDir: filepath.Join(tmpRoot, "cron.d"), TargetDir: targetDir, FromArtifacts: artifactsDir, VerifyKeys: []ed25519.PublicKey{pub}}
+	if err := syncer.Sync(ctx, jobs, opts); !errors.Is(err, signing.ErrNoSignature) {
+		t.Fatalf("expected unsigned manifest to be rejected, got %v", err)
+	}
+	if _, err := os.Stat(targetDir); !os.IsNotExist(err) {
+		t.Fatalf("expected no changes for unsigned manifest, stat err = %v", err)
+	}
+	if err := signing.SignFile(manifest.Path(filepath.Join(artifactsDir, "prebuilt")), priv); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.Sync(ctx, jobs, opts); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(targetDir, "prebuilt", "app"))
+	if err != nil || string(b) != "built\n" {
+		t.Fatalf("expected prebuilt app to be deployed, got %q (%v)", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "prebuilt", ".cronctl", "manifest.json")); err != nil {
+		t.Fatalf("expected manifest to be deployed: %v", err)
+	}
+
+	// The spec and cron file come from the repo: unsigned defaults or another
+	// target dir must not change what was signed.
+	if err := os.RemoveAll(targetDir); err != nil {
+		t.Fatal(err)
+	}
+	other := opts
+	other.TargetDir = filepath.Join(tmpRoot, "elsewhere")
+	if err := syncer.Sync(ctx, jobs, other); err == nil || !strings.Contains(err.Error(), "rendered for target dir") {
+		t.Fatalf("expected target dir mismatch, got %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(jobsDir, "_defaults.yaml"), []byte("env:\n  INJECTED: \"1\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defaulted, err := job.Discover(ctx, jobsDir)
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	// The effective spec is a build input, so the payload is stale.
+	if err := syncer.Sync(ctx, defaulted, opts); err == nil || !strings.Contains(err.Error(), "does not match repo") {
+		t.Fatalf("expected a changed effective spec to be rejected, got %v", err)
+	}
+	if _, err := os.Stat(targetDir); !os.IsNotExist(err) {
+		t.Fatalf("expected no changes for a changed spec, stat err = %v", err)
+	}
+	if err := os.Remove(filepath.Join(jobsDir, "_defaults.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A source change must fail instead of rebuilding, and leave the host alone.
+	if err := os.WriteFile(filepath.Join(jobDir, "run.sh"), []byte("#!/bin/sh\nexec ./app --new\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(targetDir); err != nil {
+		t.Fatal(err)
+	}
+	err = syncer.Sync(ctx, jobs, opts)
+	if err == nil || !strings.Contains(err.Error(), "does not match repo") {
+		t.Fatalf("expected input hash mismatch, got %v", err)
+	}
+	if _, err := os.Stat(targetDir); !os.IsNotExist(err) {
+		t.Fatalf("expected no changes on mismatch, stat err = %v", err)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	t.Parallel()
+	if os.Geteuid() != 0 {
+		t.Skip("skipping test that requires root")
+	}
+
+	ctx := context.Background()
+	tmpRoot := t.TempDir()
+	for id, enabled := range map[string]string{"on": "true", "off": "false"} {
+		jobDir := filepath.Join(tmpRoot, "jobs", id)
+		if err := os.MkdirAll(jobDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		jobYAML := `$schema: https://cronctl.usoltsev.xyz/v0.json
+name: ` + id + `
+enabled: ` + enabled + `
+user: root
+tags: []
+build:
+  enabled: false
+run:
+  entrypoint: run.sh
+schedule:
+  - cron: "0 * * * *"
+`
+		if err := os.WriteFile(filepath.Join(jobDir, "job.yaml"), []byte(jobYAML), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(jobDir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs, err := job.Discover(ctx, filepath.Join(tmpRoot, "jobs"))
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	cronDir := filepath.Join(tmpRoot, "cron.d")
+	opts := syncer.Options{CronDir: cronDir, TargetDir: filepath.Join(tmpRoot, "deployed"), RemoveOrphans: true}
+	if err := syncer.Sync(ctx, jobs, opts); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	repaired, err := syncer.Repair(ctx, jobs, opts)
+	if err != nil || len(repaired) != 0 {
+		t.Fatalf("Repair after sync = %v, %v; want nothing repaired", repaired, err)
+	}
+
+	onPath := filepath.Join(cronDir, "cronctl-on")
+	want, err := os.ReadFile(onPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{
+		onPath:                                "* * * * * root /tmp/evil\n",
+		filepath.Join(cronDir, "cronctl-off"): "* * * * * root true\n",
+		filepath.Join(cronDir, "cronctl-new"): "* * * * * root true\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repaired, err = syncer.Repair(ctx, jobs, opts)
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	if len(repaired) != 3 {
+		t.Fatalf("Repair repaired %v, want 3 files", repaired)
+	}
+	if got, err := os.ReadFile(onPath); err != nil || string(got) != string(want) {
+		t.Fatalf("cronctl-on = %q, %v; want %q", got, err, want)
+	}
+	for _, name := range []string{"cronctl-off", "cronctl-new"} {
+		if _, err := os.Stat(filepath.Join(cronDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, stat err = %v", name, err)
+		}
+	}
+}
+
+func TestSyncWritesEffectiveSpec(t *testing.T) {
+	t.Parallel()
+	if os.Geteuid() != 0 {
+		t.Skip("skipping test that requires root")
+	}
+
+	ctx := context.Background()
+	tmpRoot := t.TempDir()
+	jobDir := filepath.Join(tmpRoot, "jobs", "defaulted")
+	if err := os.MkdirAll(jobDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	jobYAML := `name: defaulted
+enabled: true
+tags: []
+run:
+  entrypoint: run.sh
+schedule:
+  - cron: "0 * * * *"
+`
+	if err := os.WriteFile(filepath.Join(jobDir, "job.yaml"), []byte(jobYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(jobDir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := job.DiscoverWith(ctx, filepath.Join(tmpRoot, "jobs"), job.Options{
+		Defaults: job.Defaults{User: "root", Env: map[string]string{"PATH": "/usr/bin:/bin"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := syncer.Options{CronDir: filepath.Join(tmpRoot, "cron.d"), TargetDir: filepath.Join(tmpRoot, "deployed")}
+	if err := syncer.Sync(ctx, jobs, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// The deployed job.yaml carries the defaults, so tools reading the
+	// target dir see the same spec as sync did.
+	deployed, err := job.Discover(ctx, opts.TargetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployed) != 1 || deployed[0].Spec.User != "root" || deployed[0].Spec.Env["PATH"] != "/usr/bin:/bin" {
+		t.Fatalf("deployed spec = %+v", deployed)
+	}
+	if state, err := syncer.CheckCron(opts, deployed[0]); err != nil || state != syncer.CronOK {
+		t.Fatalf("CheckCron(deployed) = %q, %v; want ok", state, err)
+	}
+	src, err := os.ReadFile(filepath.Join(jobDir, "job.yaml"))
+	if err != nil || string(src) != jobYAML {
+		t.Fatalf("source job.yaml changed: %q, %v", src, err)
+	}
+}
+
+func TestSyncChecksSecrets(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tmpRoot := t.TempDir()
+	jobDir := filepath.Join(tmpRoot, "jobs", "secretive")
+	if err := os.MkdirAll(jobDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(tmpRoot, "secretive.env")
+	if err := os.WriteFile(secret, []byte("TOKEN=x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	jobYAML := "name: secretive\nenabled: true\nuser: root\nrun: { entrypoint: run.sh }\nsecrets:\n  env_files: [" + secret + "]\nschedule: [{ cron: \"0 * * * *\" }]\n"
+	if err := os.WriteFile(filepath.Join(jobDir, "job.yaml"), []byte(jobYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(jobDir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := job.Discover(ctx, filepath.Join(tmpRoot, "jobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := syncer.Options{CronDir: filepath.Join(tmpRoot, "cron.d"), TargetDir: filepath.Join(tmpRoot, "deployed"), DryRun: true}
+	if err := syncer.Sync(ctx, jobs, opts); !errors.Is(err, runner.ErrUnsafeEnvFile) {
+		t.Fatalf("Sync(0644 secret) = %v, want %v", err, runner.ErrUnsafeEnvFile)
+	}
+	opts.AllowInsecureSecrets = true
+	if err := syncer.Sync(ctx, jobs, opts); err != nil {
+		t.Fatalf("Sync(0644 secret, allow insecure) = %v", err)
+	}
+	opts.AllowInsecureSecrets = false
+	if err := os.Chmod(secret, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if os.Geteuid() == 0 {
+		if err := syncer.Sync(ctx, jobs, opts); err != nil {
+			t.Fatalf("Sync(0600 secret) = %v", err)
+		}
+	}
+}
+
+// writeSecretsJob writes job id with encrypted secrets to jobsDir.
+func writeSecretsJob(t *testing.T, jobsDir, id string, enabled bool) string {
+	t.Helper()
+	dir := filepath.Join(jobsDir, id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	jobYAML := "name: " + id + "\nenabled: " + strconv.FormatBool(enabled) + "\nuser: root\n" +
+		"run: { entrypoint: run.sh }\nschedule: [{ cron: \"0 * * * *\" }]\nsecrets: { encrypted: secrets.env.age }\n"
+	if err := os.WriteFile(filepath.Join(dir, "job.yaml"), []byte(jobYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestSyncDecryptsSecrets(t *testing.T) {
+	t.Parallel()
+	if os.Geteuid() != 0 {
+		t.Skip("skipping test that requires root")
+	}
+	if _, err := exec.LookPath("age-keygen"); err != nil {
+		t.Skip("age not installed")
+	}
+
+	ctx := context.Background()
+	tmpRoot := t.TempDir()
+	identity := filepath.Join(tmpRoot, "age.key")
+	out, err := exec.CommandContext(ctx, "age-keygen", "-o", identity).CombinedOutput()
+	if err != nil {
+		t.Fatalf("age-keygen: %v: %s", err, out)
+	}
+	_, recipient, _ := strings.Cut(strings.TrimSpace(string(out)), "Public key: ")
+	jobDir := writeSecretsJob(t, filepath.Join(tmpRoot, "jobs"), "report", true)
+	if err := secrets.Encrypt(ctx, []byte("TOKEN=abc\n"), []string{recipient}, filepath.Join(jobDir, "secrets.env.age")); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := job.Discover(ctx, filepath.Join(tmpRoot, "jobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := syncer.Options{
+		CronDir:     filepath.Join(tmpRoot, "cron.d"),
+		TargetDir:   filepath.Join(tmpRoot, "deployed"),
+		AgeIdentity: identity,
+		SecretsDir:  filepath.Join(tmpRoot, "secrets"),
+	}
+	if err := syncer.Sync(ctx, jobs, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	dst := secrets.DeployedPath(filepath.Join(opts.SecretsDir, "report"))
+	if b, err := os.ReadFile(dst); err != nil || string(b) != "TOKEN=abc\n" {
+		t.Fatalf("decrypted secrets = %q, %v", b, err)
+	}
+	fi, err := os.Lstat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); fi.Mode() != 0o600 || !ok || st.Uid != 0 || st.Gid != 0 {
+		t.Fatalf("decrypted secrets mode %v, sys %+v; want a root-owned 0600 file", fi.Mode(), fi.Sys())
+	}
+	if err := runner.CheckSecretsDir(filepath.Dir(dst)); err != nil {
+		t.Fatalf("secrets dir: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(opts.TargetDir, "report", ".cronctl", "secrets.env")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("plaintext in the payload: %v", err)
+	}
+}
+
+func TestSyncRejectsSymlinkedSecrets(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tmpRoot := t.TempDir()
+	jobDir := writeSecretsJob(t, filepath.Join(tmpRoot, "jobs"), "report", true)
+	// Even a link within the job dir is refused: the file it points to
+	// could be swapped.
+	if err := os.WriteFile(filepath.Join(jobDir, "other.age"), []byte("not secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("other.age", filepath.Join(jobDir, "secrets.env.age")); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := job.Discover(ctx, filepath.Join(tmpRoot, "jobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := syncer.Options{
+		CronDir:    filepath.Join(tmpRoot, "cron.d"),
+		TargetDir:  filepath.Join(tmpRoot, "deployed"),
+		SecretsDir: filepath.Join(tmpRoot, "secrets"),
+	}
+	if err := syncer.Sync(ctx, jobs, opts); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Fatalf("Sync(symlinked secrets) = %v, want not a regular file", err)
+	}
+	if _, err := os.Lstat(opts.SecretsDir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("secrets dir after failed sync: %v, want none", err)
+	}
+}
+
+func TestSyncRemovesSecrets(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tmpRoot := t.TempDir()
+	writeSecretsJob(t, filepath.Join(tmpRoot, "jobs"), "disabled", false)
+	jobs, err := job.Discover(ctx, filepath.Join(tmpRoot, "jobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := syncer.Options{
+		CronDir:       filepath.Join(tmpRoot, "cron.d"),
+		TargetDir:     filepath.Join(tmpRoot, "deployed"),
+		SecretsDir:    filepath.Join(tmpRoot, "secrets"),
+		RemoveOrphans: true,
+	}
+	// Plaintext left by earlier deploys of a now disabled job and of a job
+	// whose cron file is pruned.
+	for _, id := range []string{"disabled", "orphan", "kept"} {
+		dst := secrets.DeployedPath(filepath.Join(opts.SecretsDir, id))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dst, []byte("TOKEN=abc\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(opts.CronDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(opts.CronDir, "cronctl-orphan"), []byte("# orphan\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.Sync(ctx, jobs, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	for id, want := range map[string]bool{"disabled": false, "orphan": false, "kept": true} {
+		_, err := os.Lstat(secrets.DeployedPath(filepath.Join(opts.SecretsDir, id)))
+		if got := err == nil; got != want {
+			t.Errorf("%s: decrypted secrets exist = %v (%v), want %v", id, got, err, want)
+		}
+	}
+}
+
+func TestSyncKeepsFailedBuildLog(t *testing.T) {
+	t.Parallel()
+	if os.Geteuid() != 0 {
+		t.Skip("skipping test that requires root")
+	}
+
+	ctx := context.Background()
+	tmpRoot := t.TempDir()
+	jobsDir := filepath.Join(tmpRoot, "jobs")
+	targetDir := filepath.Join(tmpRoot, "deployed")
+	opts := syncer.Options{CronDir: filepath.Join(tmpRoot, "cron.d"), TargetDir: targetDir}
+	writeJob := func(id, buildScript string) {
+		t.Helper()
+		dir := filepath.Join(jobsDir, id)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		jobYAML := "$schema: https://cronctl.usoltsev.xyz/v0.json\nname: " + id + "\nenabled: true\nuser: root\nbuild:\n  enabled: true\nrun:\n  entrypoint: run.sh\nschedule:\n  - cron: \"0 * * * *\"\n"
+		if err := os.WriteFile(filepath.Join(dir, "job.yaml"), []byte(jobYAML), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "build.sh"), []byte(buildScript), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// sync syncs only the job id, since Sync stops at the first failure.
+	sync := func(id string) error {
+		t.Helper()
+		jobs, err := job.Discover(ctx, jobsDir)
+		if err != nil {
+			t.Fatalf("Discover failed: %v", err)
+		}
+		jobs = slices.DeleteFunc(jobs, func(j job.Job) bool { return j.ID != id })
+		return syncer.Sync(ctx, jobs, opts)
+	}
+
+	writeJob("build-job", "#!/bin/sh\necho ok\n")
+	if err := sync("build-job"); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	statePath := build.StateFilePath(filepath.Join(targetDir, "build-job"))
+	before, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("read state: %v", err)
+	}
+
+	writeJob("build-job", "#!/bin/sh\necho boom\nexit 3\n")
+	if err := sync("build-job"); err == nil {
+		t.Fatal("Sync succeeded, want a build error")
+	}
+	if after, err := os.ReadFile(statePath); err != nil || string(after) != string(before) {
+		t.Fatalf("state.json = %q, %v; want the deployed build's %q", after, err, before)
+	}
+	logData, err := os.ReadFile(build.FailedLogFilePath(filepath.Join(targetDir, "build-job")))
+	if err != nil || !strings.Contains(string(logData), "boom") {
+		t.Fatalf("failed build log = %q, %v", logData, err)
+	}
+
+	writeJob("new-job", "#!/bin/sh\nexit 1\n")
+	if err := sync("new-job"); err == nil || !strings.Contains(err.Error(), "job new-job: build") {
+		t.Fatalf("Sync(new-job) = %v, want a build error", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "new-job")); !os.IsNotExist(err) {
+		t.Fatalf("stat new-job: %v, want no dir for a job that never deployed", err)
+	}
+}
+
+func TestSyncSignedManifests(t *testing.T) {
+	t.Parallel()
+	if os.Geteuid() != 0 {
+		t.Skip("skipping test that requires root")
+	}
+
+	ctx := context.Background()
+	tmpRoot := t.TempDir()
+	jobsDir := filepath.Join(tmpRoot, "jobs")
+	jobDir := filepath.Join(jobsDir, "checked")
+	if err := os.MkdirAll(jobDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	jobYAML := "$schema: https://cronctl.usoltsev.xyz/v0.json\nname: checked\nenabled: true\nuser: root\nbuild:\n  enabled: false\nrun:\n  entrypoint: run.sh\nschedule:\n  - cron: \"0 * * * *\"\n"
+	if err := os.WriteFile(filepath.Join(jobDir, "job.yaml"), []byte(jobYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(jobDir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := job.Discover(ctx, jobsDir)
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+
+	// CI exports and signs the manifests; the host builds from its checkout.
+	targetDir := filepath.Join(tmpRoot, "deployed")
+	deploy, err := syncer.DescribeDeploy(jobs[0], targetDir)
+	if err != nil {
+		t.Fatalf("DescribeDeploy failed: %v", err)
+	}
+	manifests := filepath.Join(tmpRoot, "manifests")
+	if err := build.Export(ctx, jobs[0], manifests, deploy); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := syncer.Options{CronDir: filepath.Join(tmpRoot, "cron.d"), TargetDir: targetDir, SignedManifests: manifests, VerifyKeys: []ed25519.PublicKey{pub}}
+	if err := syncer.Sync(ctx, jobs, opts); !errors.Is(err, signing.ErrNoSignature) {
+		t.Fatalf("expected unsigned manifest to be rejected, got %v", err)
+	}
+	if err := signing.SignFile(manifest.Path(filepath.Join(manifests, "checked")), priv); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.Sync(ctx, jobs, opts); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(opts.CronDir, "cronctl-checked")); err != nil {
+		t.Fatalf("expected cron file: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(jobDir, "run.sh"), []byte("#!/bin/sh\necho changed\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(targetDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.Sync(ctx, jobs, opts); err == nil || !strings.Contains(err.Error(), "does not match repo") {
+		t.Fatalf("expected input hash mismatch, got %v", err)
+	}
+	if _, err := os.Stat(targetDir); !os.IsNotExist(err) {
+		t.Fatalf("expected no changes on mismatch, stat err = %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package validate
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/yegor-usoltsev/cronctl/internal/job"
+)
+
+// maxScript is how much of a script entrypoint is read for checks.
+const maxScript = 1 << 20
+
+var (
+	errInterpNotFound = errors.New("not found on this host")
+	errEnvNotFound    = errors.New("not found in PATH on this host")
+
+	elfMagic = []byte(elf.ELFMAG)
+)
+
+// entrypoint is the run or build entrypoint of a job.
+type entrypoint struct {
+	// Field is run.entrypoint or build.entrypoint.
+	Field string
+	Path  string
+}
+
+func (e entrypoint) pointer() string {
+	return "/" + strings.ReplaceAll(e.Field, ".", "/")
+}
+
+// entrypoints returns the run entrypoint of spec and, if builds are enabled,
+// the build entrypoint.
+func entrypoints(spec job.Spec) []entrypoint {
+	eps := []entrypoint{{Field: "run.entrypoint", Path: orDefault(spec.Run.Entrypoint, job.DefaultRunEntrypoint)}}
+	if spec.Build.Enabled {
+		eps = append(eps, entrypoint{Field: "build.entrypoint", Path: orDefault(spec.Build.Entrypoint, job.DefaultBuildEntrypoint)})
+	}
+	return eps
+}
+
+func orDefault(s, def string) string {
+	if s = strings.TrimSpace(s); s != "" {
+		return s
+	}
+	return def
+}
+
+// checkEntrypoint reports what keeps the kernel from starting ep in any
+// environment: a file that is missing, outside the job dir, not executable or
+// a script without a usable #! line.
+func checkEntrypoint(j job.Job, ep entrypoint) []Error {
+	errPath := j.Source(ep.pointer())
+	fail := func(msg string) []Error {
+		return []Error{{JobID: j.ID, Path: errPath, Msg: ep.Field + " " + msg}}
+	}
+	if !filepath.IsLocal(ep.Path) {
+		return fail("resolves outside the job dir: " + ep.Path)
+	}
+	p := filepath.Join(j.Dir, ep.Path)
+	fi, err := os.Stat(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fail("file does not exist: " + p)
+		}
+		return []Error{{JobID: j.ID, Path: errPath, Msg: fmt.Sprintf("stat %s: %s: %v", ep.Field, p, err)}}
+	}
+	if target, inside := resolveInside(j.Dir, p); !inside {
+		return fail(fmt.Sprintf("resolves outside the job dir through a symlink: %s -> %s", ep.Path, target))
+	}
+	if !fi.Mode().IsRegular() {
+		return fail("is not a regular file: " + ep.Path)
+	}
+
+	var errs []Error
+	if fi.Mode().Perm()&0o111 == 0 {
+		errs = append(errs, fail("is not executable (chmod +x): "+ep.Path)...)
+	}
+	data, isELF, err := readEntrypoint(p)
+	if err != nil {
+		return append(errs, Error{JobID: j.ID, Path: errPath, Msg: fmt.Sprintf("read %s: %v", ep.Field, err)})
+	}
+	if isELF {
+		return errs
+	}
+	if !bytes.HasPrefix(data, []byte("#!")) {
+		return append(errs, fail("has no #! line and is not an ELF binary: "+ep.Path)...)
+	}
+	if bytes.Contains(data, []byte("\r\n")) {
+		errs = append(errs, fail("has CRLF line endings (convert with dos2unix): "+ep.Path)...)
+	}
+	switch interp := shebang(data); {
+	case len(interp) == 0:
+		errs = append(errs, fail("has an empty #! line: "+ep.Path)...)
+	case !filepath.IsAbs(interp[0]):
+		errs = append(errs, fail(fmt.Sprintf("#! interpreter is not an absolute path: %s", interp[0]))...)
+	}
+	return errs
+}
+
+// checkRunnable reports entrypoints of j that cannot start on the target:
+// ELF binaries for another architecture than opts.Arch and, with opts.OnHost,
+// #! interpreters this machine does not have. Entrypoints checkEntrypoint
+// rejects are skipped.
+func checkRunnable(j job.Job, opts Options) []Error {
+	if opts.Arch == "" && !opts.OnHost {
+		return nil
+	}
+	spec, err := decodeSpec(j.RawYAML)
+	if err != nil {
+		return nil
+	}
+	var errs []Error
+	for _, ep := range entrypoints(spec) {
+		if !filepath.IsLocal(ep.Path) {
+			continue
+		}
+		p := filepath.Join(j.Dir, ep.Path)
+		data, isELF, err := readEntrypoint(p)
+		if err != nil {
+			continue
+		}
+		errPath := j.Source(ep.pointer())
+		switch {
+		case isELF && opts.Arch != "":
+			arch, err := elfArch(p)
+			if err != nil {
+				errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: fmt.Sprintf("%s: %s: %v", ep.Field, ep.Path, err)})
+			} else if arch != opts.Arch {
+				errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: fmt.Sprintf("%s is built for %s, the target is %s: %s", ep.Field, arch, opts.Arch, ep.Path)})
+			}
+		case !isELF && opts.OnHost:
+			if interp := shebang(data); len(interp) > 0 && filepath.IsAbs(interp[0]) {
+				if err := findInterpreter(interp); err != nil {
+					errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: fmt.Sprintf("%s #! interpreter %v", ep.Field, err)})
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// resolveInside returns the path p resolves to and whether that is inside
+// dir. Paths that do not resolve are left to the caller.
+func resolveInside(dir, p string) (string, bool) {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return p, true
+	}
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return p, true
+	}
+	rel, err := filepath.Rel(realDir, target)
+	return target, err == nil && filepath.IsLocal(rel)
+}
+
+// readEntrypoint returns the start of an ELF file, or up to maxScript bytes
+// of anything else.
+func readEntrypoint(p string) ([]byte, bool, error) {
+	f, err := os.Open(p) // #nosec G304 -- entrypoints are paths inside the job dir.
+	if err != nil {
+		return nil, false, fmt.Errorf("open: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	head := make([]byte, len(elfMagic))
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, false, fmt.Errorf("read: %w", err)
+	}
+	head = head[:n]
+	if bytes.Equal(head, elfMagic) {
+		return head, true, nil
+	}
+	rest, err := io.ReadAll(io.LimitReader(f, maxScript-int64(n)))
+	if err != nil {
+		return nil, false, fmt.Errorf("read: %w", err)
+	}
+	return append(head, rest...), false, nil
+}
+
+// shebang returns the interpreter and arguments of the #! line of script.
+func shebang(script []byte) []string {
+	line, _, _ := bytes.Cut(script, []byte("\n"))
+	line = bytes.TrimSuffix(bytes.TrimPrefix(line, []byte("#!")), []byte("\r"))
+	return strings.Fields(string(line))
+}
+
+// findInterpreter returns an error unless the interpreter of a #! line, and
+// the command of an /usr/bin/env one, exist on this machine.
+func findInterpreter(interp []string) error {
+	if _, err := exec.LookPath(interp[0]); err != nil {
+		return fmt.Errorf("%w: %s", errInterpNotFound, interp[0])
+	}
+	if filepath.Base(interp[0]) != "env" {
+		return nil
+	}
+	args := interp[1:]
+	for len(args) > 0 && (strings.HasPrefix(args[0], "-") || strings.Contains(args[0], "=")) {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return fmt.Errorf("%w: %s", errEnvNotFound, strings.Join(interp, " "))
+	}
+	return nil
+}
+
+// elfArch returns the GOARCH name of the machine the ELF file p is built for.
+func elfArch(p string) (string, error) {
+	f, err := elf.Open(p)
+	if err != nil {
+		return "", fmt.Errorf("read ELF header: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	switch f.Machine {
+	case elf.EM_X86_64:
+		return "amd64", nil
+	case elf.EM_386:
+		return "386", nil
+	case elf.EM_AARCH64:
+		return "arm64", nil
+	case elf.EM_ARM:
+		return "arm", nil
+	case elf.EM_RISCV:
+		if f.Class == elf.ELFCLASS64 {
+			return "riscv64", nil
+		}
+	case elf.EM_PPC64:
+		if f.ByteOrder == binary.LittleEndian {
+			return "ppc64le", nil
+		}
+		return "ppc64", nil
+	case elf.EM_S390:
+		return "s390x", nil
+	case elf.EM_LOONGARCH:
+		return "loong64", nil
+	}
+	return f.Machine.String(), nil
+}
@@ -7,26 +7,35 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 
 	"github.com/santhosh-tekuri/jsonschema/v6"
 	"github.com/yegor-usoltsev/cronctl/internal/job"
+	"github.com/yegor-usoltsev/cronctl/internal/payload"
 	"github.com/yegor-usoltsev/cronctl/internal/schema"
+	"github.com/yegor-usoltsev/cronctl/internal/syncer"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Error struct {
 	JobID string
-	Path  string
-	Msg   string
+	// Env is the environment whose overlay the error is specific to.
+	Env  string
+	Path string
+	Msg  string
 }
 
 func (e Error) Error() string {
+	if e.Env != "" {
+		return fmt.Sprintf("%s (%s, env %s): %s", e.Path, e.JobID, e.Env, e.Msg)
+	}
 	return fmt.Sprintf("%s (%s): %s", e.Path, e.JobID, e.Msg)
 }
 
@@ -45,19 +54,49 @@ func (e Errors) Error() string {
 
 var jobIDRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
 
-func All(ctx context.Context, jobs []job.Job) error {
-	var errs Errors
+// Options are repo-wide rules checked on top of the schema.
+type Options struct {
+	// AllowedTags, if set, are the only tags jobs may use.
+	AllowedTags []string
+	// Arch, if set, is the GOARCH ELF entrypoints must be built for.
+	Arch string
+	// OnHost checks that #! interpreters exist on this machine, for
+	// validating on the hosts jobs run on.
+	OnHost bool
+}
+
+func All(ctx context.Context, jobs []job.Job, opts Options) error {
+	return Envs(ctx, jobs, nil, opts)
+}
 
+// Envs validates jobs, the base specs, and envJobs, the jobs discovered for
+// each environment (see job.Options.Env). For an environment, only errors the
+// base specs do not have are reported.
+func Envs(ctx context.Context, jobs []job.Job, envJobs map[string][]job.Job, opts Options) error {
 	schemaV0, err := schema.V0()
 	if err != nil {
 		return fmt.Errorf("load schema: %w", err)
 	}
 
-	for _, j := range jobs {
-		if err := ctx.Err(); err != nil {
-			return fmt.Errorf("validate: %w", err)
+	errs, err := check(ctx, schemaV0, jobs, opts)
+	if err != nil {
+		return err
+	}
+	base := make(map[Error]bool, len(errs))
+	for _, e := range errs {
+		base[e] = true
+	}
+	for _, env := range slices.Sorted(maps.Keys(envJobs)) {
+		envErrs, err := check(ctx, schemaV0, envJobs[env], opts)
+		if err != nil {
+			return err
+		}
+		for _, e := range envErrs {
+			if !base[e] {
+				e.Env = env
+				errs = append(errs, e)
+			}
 		}
-		errs = append(errs, Job(ctx, schemaV0, j)...)
 	}
 
 	if len(errs) == 0 {
@@ -70,11 +109,27 @@ func All(ctx context.Context, jobs []job.Job) error {
 		if errs[i].JobID != errs[j].JobID {
 			return errs[i].JobID < errs[j].JobID
 		}
+		if errs[i].Env != errs[j].Env {
+			return errs[i].Env < errs[j].Env
+		}
 		return errs[i].Msg < errs[j].Msg
 	})
 	return errs
 }
 
+func check(ctx context.Context, schemaV0 *jsonschema.Schema, jobs []job.Job, opts Options) (Errors, error) {
+	var errs Errors
+	for _, j := range jobs {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("validate: %w", err)
+		}
+		errs = append(errs, Job(ctx, schemaV0, j)...)
+		errs = append(errs, tags(j, opts.AllowedTags)...)
+		errs = append(errs, checkRunnable(j, opts)...)
+	}
+	return errs, nil
+}
+
 func Job(ctx context.Context, schemaV0 *jsonschema.Schema, j job.Job) []Error {
 	if err := ctx.Err(); err != nil {
 		return []Error{{JobID: j.ID, Path: j.YAML, Msg: err.Error()}}
@@ -87,14 +142,20 @@ func Job(ctx context.Context, schemaV0 *jsonschema.Schema, j job.Job) []Error {
 		errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: "invalid job id, expected kebab-case ([a-z0-9][a-z0-9-]*)"})
 	}
 
+	if j.Err != nil {
+		var se *job.SpecError
+		if errors.As(j.Err, &se) {
+			return append(errs, Error{JobID: j.ID, Path: se.File, Msg: se.Pointer + ": " + se.Err.Error()})
+		}
+		return append(errs, Error{JobID: j.ID, Path: errPath, Msg: j.Err.Error()})
+	}
+
 	if len(bytesTrimSpace(j.RawYAML)) == 0 {
 		errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: "empty job.yaml"})
 		return errs
 	}
 
-	if err := validateSchema(schemaV0, j.RawYAML); err != nil {
-		errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: "JSON schema validation failed: " + err.Error()})
-	}
+	errs = append(errs, schemaErrors(schemaV0, j)...)
 
 	spec, err := decodeSpec(j.RawYAML)
 	if err != nil {
@@ -104,51 +165,68 @@ func Job(ctx context.Context, schemaV0 *jsonschema.Schema, j job.Job) []Error {
 	j.Spec = spec
 
 	if j.Spec.Name != "" && j.Spec.Name != j.ID {
-		errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: fmt.Sprintf("name must match job id: %q != %q", j.Spec.Name, j.ID)})
+		errs = append(errs, Error{JobID: j.ID, Path: j.Source("/name"), Msg: fmt.Sprintf("name must match job id: %q != %q", j.Spec.Name, j.ID)})
 	}
 
 	for i, s := range j.Spec.Schedule {
-		if len(strings.Fields(s.Cron)) != 5 {
-			errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: fmt.Sprintf("schedule[%d].cron must have 5 fields", i)})
+		if n := len(strings.Fields(s.Cron)); n != 0 && n != 5 {
+			errs = append(errs, Error{JobID: j.ID, Path: j.Source(fmt.Sprintf("/schedule/%d/cron", i)), Msg: fmt.Sprintf("schedule[%d].cron must have 5 fields", i)})
 		}
 	}
+	// Everything sync would fail to render, so CI fails before any host does.
+	badRun := false
+	for _, e := range syncer.CheckRender(j.Spec) {
+		errs = append(errs, Error{JobID: j.ID, Path: j.Source(e.Pointer), Msg: e.Error()})
+		badRun = badRun || e.Pointer == "/run/entrypoint"
+	}
 
 	if strings.TrimSpace(j.Spec.Schema) != "" && j.Spec.Schema != schema.V0URL {
-		errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: fmt.Sprintf("$schema must be %q", schema.V0URL)})
+		errs = append(errs, Error{JobID: j.ID, Path: j.Source("/$schema"), Msg: fmt.Sprintf("$schema must be %q", schema.V0URL)})
 	}
 	if strings.TrimSpace(j.Spec.Schema) == "" {
 		errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: fmt.Sprintf("$schema is required and must be %q", schema.V0URL)})
 	}
 
-	ep := strings.TrimSpace(j.Spec.Run.Entrypoint)
-	if ep == "" {
-		ep = job.DefaultRunEntrypoint
+	eps := entrypoints(j.Spec)
+	if !badRun {
+		errs = append(errs, checkEntrypoint(j, eps[0])...)
 	}
-	p := filepath.Join(j.Dir, ep)
-	if _, err := os.Stat(p); err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: "run.entrypoint file does not exist: " + p})
-		} else {
-			errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: fmt.Sprintf("stat run.entrypoint: %s: %v", p, err)})
-		}
+
+	if f, err := payload.Load(j.Dir, j.Spec.Payload); err != nil {
+		errs = append(errs, Error{JobID: j.ID, Path: j.Source("/payload"), Msg: "payload: " + err.Error()})
+	} else if ep := eps[0].Path; !f.Keep(ep) {
+		errs = append(errs, Error{JobID: j.ID, Path: j.Source("/run/entrypoint"), Msg: "run.entrypoint is excluded from the payload (payload.include/exclude or " + payload.IgnoreFile + "): " + ep})
+	}
+
+	if enc := j.Spec.Secrets.Encrypted; enc != "" {
+		errs = append(errs, checkEncrypted(j, enc)...)
 	}
 
 	if !j.Spec.Build.Enabled {
 		return errs
 	}
-	ep = strings.TrimSpace(j.Spec.Build.Entrypoint)
-	if ep == "" {
-		ep = job.DefaultBuildEntrypoint
+	if _, err := j.Spec.Build.TimeoutDuration(); err != nil {
+		errs = append(errs, Error{JobID: j.ID, Path: j.Source("/build/timeout"), Msg: "build.timeout: " + err.Error()})
 	}
-	p = filepath.Join(j.Dir, ep)
-	if _, err := os.Stat(p); err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: "build.entrypoint file does not exist: " + p})
-		} else {
-			errs = append(errs, Error{JobID: j.ID, Path: errPath, Msg: fmt.Sprintf("stat build.entrypoint: %s: %v", p, err)})
+	return append(errs, checkEntrypoint(j, eps[1])...)
+}
+
+// tags reports tags outside allowed. Jobs whose YAML does not decode are
+// reported by Job.
+func tags(j job.Job, allowed []string) []Error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	spec, err := decodeSpec(j.RawYAML)
+	if err != nil {
+		return nil
+	}
+	var errs []Error
+	for i, t := range spec.Tags {
+		if !slices.Contains(allowed, t) {
+			errs = append(errs, Error{JobID: j.ID, Path: j.Source(fmt.Sprintf("/tags/%d", i)), Msg: fmt.Sprintf("tag %q is not in allowed_tags (%s)", t, strings.Join(allowed, ", "))})
 		}
 	}
-
 	return errs
 }
 
@@ -170,15 +248,49 @@ func validateSchema(schemaV0 *jsonschema.Schema, yamlBytes []byte) error {
 		return fmt.Errorf("parse json: %w", err)
 	}
 	if err := schemaV0.Validate(jsonDoc); err != nil {
-		return schemaError{msg: formatSchemaErr(err)}
+		return schemaError{msg: formatSchemaErr(err), err: err}
 	}
 	return nil
 }
 
-type schemaError struct{ msg string }
+// schemaErrors validates j against the schema and reports the failures
+// against the files the failing values come from.
+func schemaErrors(schemaV0 *jsonschema.Schema, j job.Job) []Error {
+	err := validateSchema(schemaV0, j.RawYAML)
+	if err == nil {
+		return nil
+	}
+	var ve *jsonschema.ValidationError
+	if len(j.Sources) == 0 || !errors.As(err, &ve) {
+		return []Error{{JobID: j.ID, Path: j.YAML, Msg: "JSON schema validation failed: " + err.Error()}}
+	}
+
+	out := ve.BasicOutput()
+	byFile := make(map[string][]jsonschema.OutputUnit)
+	var files []string
+	for _, u := range out.Errors {
+		file := j.Source(u.InstanceLocation)
+		if _, ok := byFile[file]; !ok {
+			files = append(files, file)
+		}
+		byFile[file] = append(byFile[file], u)
+	}
+	errs := make([]Error, 0, len(files))
+	for _, file := range files {
+		errs = append(errs, Error{JobID: j.ID, Path: file, Msg: "JSON schema validation failed: " + formatUnits(byFile[file])})
+	}
+	return errs
+}
+
+type schemaError struct {
+	msg string
+	err error
+}
 
 func (e schemaError) Error() string { return e.msg }
 
+func (e schemaError) Unwrap() error { return e.err }
+
 func decodeSpec(b []byte) (job.Spec, error) {
 	var spec job.Spec
 	if err := yaml.Unmarshal(b, &spec); err != nil {
@@ -190,11 +302,48 @@ func decodeSpec(b []byte) (job.Spec, error) {
 func formatSchemaErr(err error) string {
 	var ve *jsonschema.ValidationError
 	if errors.As(err, &ve) {
-		b, mErr := json.Marshal(ve.BasicOutput())
-		if mErr != nil {
-			return "schema: " + err.Error()
+		if msg := formatUnits(ve.BasicOutput().Errors); msg != "" {
+			return msg
 		}
-		return "schema: " + string(b)
 	}
 	return "schema: " + err.Error()
 }
+
+// formatUnits returns the failures of units, one "at <location>: <message>"
+// per failing value, e.g. at '/schedule/0/cron': missing property 'cron'.
+func formatUnits(units []jsonschema.OutputUnit) string {
+	msgs := make([]string, 0, len(units))
+	for _, u := range units {
+		if u.Error == nil {
+			continue
+		}
+		loc := u.InstanceLocation
+		if loc == "" {
+			loc = "/"
+		}
+		msgs = append(msgs, fmt.Sprintf("at '%s': %s", loc, u.Error))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// checkEncrypted checks that the encrypted secrets file enc of j is a file in
+// the job dir that is deployed with the payload.
+func checkEncrypted(j job.Job, enc string) []Error {
+	errPath := j.Source("/secrets/encrypted")
+	if !filepath.IsLocal(enc) {
+		return []Error{{JobID: j.ID, Path: errPath, Msg: "secrets.encrypted must be a relative path inside the job directory: " + enc}}
+	}
+	p := filepath.Join(j.Dir, enc)
+	if fi, err := os.Stat(p); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []Error{{JobID: j.ID, Path: errPath, Msg: "secrets.encrypted file does not exist: " + p}}
+		}
+		return []Error{{JobID: j.ID, Path: errPath, Msg: fmt.Sprintf("stat secrets.encrypted: %s: %v", p, err)}}
+	} else if !fi.Mode().IsRegular() {
+		return []Error{{JobID: j.ID, Path: errPath, Msg: "secrets.encrypted is not a regular file: " + p}}
+	}
+	if f, err := payload.Load(j.Dir, j.Spec.Payload); err == nil && !f.Keep(filepath.ToSlash(enc)) {
+		return []Error{{JobID: j.ID, Path: errPath, Msg: "secrets.encrypted is excluded from the payload (payload.include/exclude or " + payload.IgnoreFile + "): " + enc}}
+	}
+	return nil
+}
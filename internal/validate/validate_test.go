@@ -3,8 +3,11 @@ package validate
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/santhosh-tekuri/jsonschema/v6"
@@ -95,3 +98,245 @@ func TestValidateJob_FailsForMissingEntrypointFile(t *testing.T) {
 		t.Fatalf("expected errors")
 	}
 }
+
+func TestValidateJob_FailsForFilteredEntrypoint(t *testing.T) {
+	t.Parallel()
+
+	j := job.Job{
+		ID:      "ok-job",
+		Dir:     t.TempDir(),
+		YAML:    "jobs/ok-job/job.yaml",
+		RawYAML: []byte("$schema: \"https://cronctl.usoltsev.xyz/v0.json\"\nenabled: true\nuser: root\ntags: []\nbuild: { enabled: false }\nrun: { entrypoint: run.sh }\npayload: { include: [bin/] }\nschedule: [{ cron: \"0 * * * *\" }]\n"),
+	}
+	_ = os.WriteFile(filepath.Join(j.Dir, "run.sh"), []byte("#!/usr/bin/env bash\n"), 0o755)
+
+	errs := Job(context.Background(), mustSchema(t, `{}`), j)
+	if len(errs) != 1 || !strings.Contains(errs[0].Msg, "excluded from the payload") {
+		t.Fatalf("expected payload error, got %v", errs)
+	}
+}
+
+func TestAll_ReportsInheritedErrors(t *testing.T) {
+	t.Parallel()
+
+	jobsDir := t.TempDir()
+	groupDir := filepath.Join(jobsDir, "db")
+	jobDir := filepath.Join(groupDir, "backup")
+	if err := os.MkdirAll(jobDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	files := map[string]string{
+		filepath.Join(jobsDir, job.DefaultsFile):  "$schema: \"https://cronctl.usoltsev.xyz/v0.json\"\nenabled: true\nuser: \"\"\nbuild: { enabled: false }\nrun: { entrypoint: run.sh }\n",
+		filepath.Join(groupDir, job.DefaultsFile): "tags: [db, legacy]\n",
+		filepath.Join(jobDir, "job.yaml"):         "name: backup\ntags: !append [nightly]\nschedule: [{ cron: \"0 * * *\", args: [], env: {} }]\n",
+		filepath.Join(jobDir, "run.sh"):           "#!/bin/sh\n",
+	}
+	for p, content := range files {
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", p, err)
+		}
+	}
+
+	jobs, err := job.DiscoverRaw(context.Background(), jobsDir)
+	if err != nil {
+		t.Fatalf("DiscoverRaw: %v", err)
+	}
+	err = All(context.Background(), jobs, Options{AllowedTags: []string{"db", "nightly"}})
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("All() = %v, want Errors", err)
+	}
+	got := make(map[string]bool)
+	for _, e := range errs {
+		switch {
+		case strings.Contains(e.Msg, "/user"):
+			got["user"] = e.Path == filepath.Join(jobsDir, job.DefaultsFile) && strings.Contains(e.Msg, "at '/user': ") && !strings.Contains(e.Msg, "keywordLocation")
+		case strings.Contains(e.Msg, `tag "legacy"`):
+			got["tag"] = e.Path == filepath.Join(groupDir, job.DefaultsFile)
+		case strings.Contains(e.Msg, "schedule[0].cron"):
+			got["cron"] = e.Path == filepath.Join(jobDir, "job.yaml")
+		}
+	}
+	if !got["user"] || !got["tag"] || !got["cron"] {
+		t.Fatalf("All() = %v, want user, tag and cron errors against their files", errs)
+	}
+}
+
+func TestValidateJob_FailsForCronInjection(t *testing.T) {
+	t.Parallel()
+
+	j := job.Job{
+		ID:      "ok-job",
+		Dir:     t.TempDir(),
+		YAML:    "jobs/ok-job/job.yaml",
+		RawYAML: []byte("$schema: \"https://cronctl.usoltsev.xyz/v0.json\"\nenabled: true\nuser: \"app root\"\nenv: { MAILTO: \"x\\n* * * * * root evil\" }\nbuild: { enabled: false }\nrun: { entrypoint: run.sh }\nschedule: [{ cron: \"0 * * * *\", args: [\"date +%Y\", \"a\\\\%b\"] }]\n"),
+	}
+	_ = os.WriteFile(filepath.Join(j.Dir, "run.sh"), []byte("#!/bin/sh\n"), 0o755)
+
+	var msgs []string
+	for _, e := range Job(context.Background(), mustSchema(t, `{}`), j) {
+		msgs = append(msgs, e.Msg)
+	}
+	got := strings.Join(msgs, "\n")
+	for _, want := range []string{"user: contains whitespace", "env.MAILTO: contains a control character", `schedule[0].args[1]: contains \%`} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("Job() = %q, want an error containing %q", msgs, want)
+		}
+	}
+	if strings.Contains(got, "args[0]") {
+		t.Fatalf("Job() = %q, want %% allowed in args", msgs)
+	}
+}
+
+func TestValidateJob_ReportsRenderErrors(t *testing.T) {
+	t.Parallel()
+
+	j := job.Job{
+		ID:      "ok-job",
+		Dir:     t.TempDir(),
+		YAML:    "jobs/ok-job/job.yaml",
+		RawYAML: []byte("$schema: \"https://cronctl.usoltsev.xyz/v0.json\"\nenabled: true\nuser: \"\"\nenv: { BAD-KEY: x }\nbuild: { enabled: false }\nrun: { entrypoint: ../shared/run.sh }\nschedule: [{ cron: \"\", env: { \"1X\": y } }]\n"),
+	}
+
+	var msgs []string
+	for _, e := range Job(context.Background(), mustSchema(t, `{}`), j) {
+		msgs = append(msgs, e.Msg)
+	}
+	got := strings.Join(msgs, "\n")
+	for _, want := range []string{
+		`env.BAD-KEY: invalid env key: "BAD-KEY"`,
+		"user: user is empty",
+		"run.entrypoint: must be a relative path inside the job dir: ../shared/run.sh",
+		"schedule[0].cron: cron is empty",
+		`schedule[0].env.1X: invalid env key: "1X"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("Job() = %q, want an error containing %q", msgs, want)
+		}
+	}
+	if strings.Contains(got, "5 fields") {
+		t.Fatalf("Job() = %q, want the empty cron reported once", msgs)
+	}
+}
+
+func TestValidateJob_ChecksEntrypoints(t *testing.T) {
+	t.Parallel()
+
+	outside := filepath.Join(t.TempDir(), "run.sh")
+	if err := os.WriteFile(outside, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	tests := []struct {
+		name   string
+		script string
+		mode   os.FileMode
+		link   string
+		spec   string
+		want   string
+	}{
+		{name: "ok", script: "#!/bin/sh\necho ok\n", mode: 0o755},
+		{name: "not executable", script: "#!/bin/sh\n", mode: 0o644, want: "run.entrypoint is not executable (chmod +x): run.sh"},
+		{name: "crlf", script: "#!/bin/sh\r\necho ok\r\n", mode: 0o755, want: "run.entrypoint has CRLF line endings"},
+		{name: "no shebang", script: "echo ok\n", mode: 0o755, want: "run.entrypoint has no #! line and is not an ELF binary: run.sh"},
+		{name: "empty shebang", script: "#!\n", mode: 0o755, want: "run.entrypoint has an empty #! line"},
+		{name: "relative interpreter", script: "#!bash\n", mode: 0o755, want: "run.entrypoint #! interpreter is not an absolute path: bash"},
+		{name: "symlink outside", link: outside, want: "run.entrypoint resolves outside the job dir through a symlink: run.sh -> "},
+		{name: "dotdot build", script: "#!/bin/sh\n", mode: 0o755, spec: "build: { enabled: true, entrypoint: ../build.sh }\n", want: "build.entrypoint resolves outside the job dir: ../build.sh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			p := filepath.Join(dir, "run.sh")
+			if tt.link != "" {
+				if err := os.Symlink(tt.link, p); err != nil {
+					t.Fatalf("symlink: %v", err)
+				}
+			} else if err := os.WriteFile(p, []byte(tt.script), tt.mode); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			spec := tt.spec
+			if spec == "" {
+				spec = "build: { enabled: false }\n"
+			}
+			j := job.Job{
+				ID:      "ok-job",
+				Dir:     dir,
+				YAML:    "jobs/ok-job/job.yaml",
+				RawYAML: []byte("$schema: \"https://cronctl.usoltsev.xyz/v0.json\"\nenabled: true\nuser: root\nrun: { entrypoint: run.sh }\nschedule: [{ cron: \"0 * * * *\" }]\n" + spec),
+			}
+
+			errs := Job(context.Background(), mustSchema(t, `{}`), j)
+			if tt.want == "" {
+				if len(errs) != 0 {
+					t.Fatalf("Job() = %v, want no errors", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Msg, tt.want) {
+				t.Fatalf("Job() = %v, want one error containing %q", errs, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRunnable(t *testing.T) {
+	t.Parallel()
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("executable: %v", err)
+	}
+	bin, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatalf("read executable: %v", err)
+	}
+	if !strings.HasPrefix(string(bin), "\x7fELF") {
+		t.Skip("test binary is not ELF")
+	}
+	tests := []struct {
+		name string
+		file []byte
+		opts Options
+		want string
+	}{
+		{name: "same arch", file: bin, opts: Options{AllowedTags: nil, Arch: runtime.GOARCH, OnHost: false}},
+		{name: "other arch", file: bin, opts: Options{AllowedTags: nil, Arch: "other", OnHost: false}, want: "run.entrypoint is built for " + runtime.GOARCH + ", the target is other: run"},
+		{name: "interpreter", file: []byte("#!/bin/sh\n"), opts: Options{AllowedTags: nil, Arch: "", OnHost: true}},
+		{name: "missing interpreter", file: []byte("#!/nonexistent/sh\n"), opts: Options{AllowedTags: nil, Arch: "", OnHost: true}, want: "run.entrypoint #! interpreter not found on this host: /nonexistent/sh"},
+		{name: "missing env command", file: []byte("#!/usr/bin/env -S cronctl-no-such-python -u\n"), opts: Options{AllowedTags: nil, Arch: "", OnHost: true}, want: "not found in PATH on this host: /usr/bin/env -S cronctl-no-such-python -u"},
+		{name: "not on host", file: []byte("#!/nonexistent/sh\n"), opts: Options{AllowedTags: nil, Arch: "amd64", OnHost: false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if tt.name == "missing env command" {
+				if _, err := os.Stat("/usr/bin/env"); err != nil {
+					t.Skip("no /usr/bin/env")
+				}
+			}
+			j := job.Job{
+				ID:      "ok-job",
+				Dir:     t.TempDir(),
+				YAML:    "jobs/ok-job/job.yaml",
+				RawYAML: []byte("enabled: true\nuser: root\nrun: { entrypoint: run }\n"),
+			}
+			if err := os.WriteFile(filepath.Join(j.Dir, "run"), tt.file, 0o755); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+
+			errs := checkRunnable(j, tt.opts)
+			if tt.want == "" {
+				if len(errs) != 0 {
+					t.Fatalf("checkRunnable() = %v, want no errors", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Msg, tt.want) {
+				t.Fatalf("checkRunnable() = %v, want one error containing %q", errs, tt.want)
+			}
+		})
+	}
+}